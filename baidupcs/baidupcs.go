@@ -38,6 +38,8 @@ const (
 	OperationFileDownload = "下载单个文件"
 	// OperationStreamFileDownload 下载流式文件
 	OperationStreamFileDownload = "下载流式文件"
+	// OperationLocateDownload 获取下载链接
+	OperationLocateDownload = "获取下载链接"
 	// OperationCloudDlAddTask 添加离线下载任务
 	OperationCloudDlAddTask = "添加离线下载任务"
 	// OperationCloudDlQueryTask 精确查询离线下载任务
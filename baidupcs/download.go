@@ -1,26 +1,71 @@
 package baidupcs
 
 import (
-	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/json-iterator/go"
 	"net/http/cookiejar"
 )
 
 // DownloadFunc 下载文件处理函数
-type DownloadFunc func(downloadURL string, jar *cookiejar.Jar, savePath string) error
+type DownloadFunc func(downloadURL string, jar *cookiejar.Jar) error
+
+// URLInfo 下载链接详情
+type URLInfo struct {
+	URLs []struct {
+		URL string `json:"url"`
+	} `json:"urls"`
+}
 
 // DownloadFile 下载单个文件
-func (pcs *BaiduPCS) DownloadFile(path string, downloadFunc DownloadFunc, savePath string) (err error) {
+func (pcs *BaiduPCS) DownloadFile(path string, downloadFunc DownloadFunc) (err error) {
 	pcs.setPCSURL("file", "download", map[string]string{
 		"path": path,
 	})
-	return downloadFunc(pcs.url.String(), pcs.client.Jar.(*cookiejar.Jar), pcsconfig.GetSavePath(savePath,path))
+
+	return downloadFunc(pcs.url.String(), pcs.client.Jar.(*cookiejar.Jar))
 }
 
 // DownloadStreamFile 下载流式文件
-func (pcs *BaiduPCS) DownloadStreamFile(path string, downloadFunc DownloadFunc, savePath string) (err error) {
+func (pcs *BaiduPCS) DownloadStreamFile(path string, downloadFunc DownloadFunc) (err error) {
 	pcs.setPCSURL("stream", "download", map[string]string{
 		"path": path,
 	})
 
-	return downloadFunc(pcs.url.String(), pcs.client.Jar.(*cookiejar.Jar), pcsconfig.GetSavePath(savePath,path))
+	return downloadFunc(pcs.url.String(), pcs.client.Jar.(*cookiejar.Jar))
+}
+
+// LocateDownload 获取文件的下载链接, 返回各下载服务器的直链
+func (pcs *BaiduPCS) LocateDownload(pcspath string) (urls []string, err error) {
+	dataReadCloser, err := pcs.PrepareLocateDownload(pcspath)
+	if err != nil {
+		return nil, err
+	}
+
+	defer dataReadCloser.Close()
+
+	errInfo := NewErrorInfo(OperationLocateDownload)
+	info := &struct {
+		URLInfo
+		*ErrInfo
+	}{
+		ErrInfo: errInfo,
+	}
+
+	d := jsoniter.NewDecoder(dataReadCloser)
+	err = d.Decode(info)
+	if err != nil {
+		errInfo.jsonError(err)
+		return nil, errInfo
+	}
+
+	if info.ErrCode != 0 {
+		return nil, info.ErrInfo
+	}
+
+	urls = make([]string, 0, len(info.URLs))
+	for _, u := range info.URLs {
+		if u.URL != "" {
+			urls = append(urls, u.URL)
+		}
+	}
+	return urls, nil
 }
@@ -335,6 +335,27 @@ func (pcs *BaiduPCS) PrepareUploadCreateSuperFile(targetPath string, blockList .
 	return resp.Body, nil
 }
 
+// PrepareLocateDownload 获取下载链接, 只返回服务器响应数据和错误信息
+func (pcs *BaiduPCS) PrepareLocateDownload(pcspath string) (dataReadCloser io.ReadCloser, err error) {
+	pcs.setPCSURL("file", "locatedownload", map[string]string{
+		"path": pcspath,
+		"ver":  "4.0",
+	})
+	pcs.url.Host = "d.pcs.baidu.com"
+
+	resp, err := pcs.client.Req("GET", pcs.url.String(), nil, nil)
+	if err != nil {
+		handleRespClose(resp)
+		return nil, &ErrInfo{
+			Operation: OperationLocateDownload,
+			ErrType:   ErrTypeNetError,
+			Err:       err,
+		}
+	}
+
+	return resp.Body, nil
+}
+
 // PrepareCloudDlAddTask 添加离线下载任务, 只返回服务器响应数据和错误信息
 func (pcs *BaiduPCS) PrepareCloudDlAddTask(sourceURL, savePath string) (dataReadCloser io.ReadCloser, err error) {
 	pcs.setPCSURL2("services/cloud_dl", "add_task", map[string]string{
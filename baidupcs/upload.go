@@ -10,6 +10,13 @@ import (
 // UploadFunc 上传文件处理函数
 type UploadFunc func(uploadURL string, jar *cookiejar.Jar) (resp *http.Response, err error)
 
+// UploadedFile 上传成功后, 服务器返回的文件信息
+type UploadedFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	MD5  string `json:"md5"`
+}
+
 // RapidUpload 秒传文件
 func (pcs *BaiduPCS) RapidUpload(targetPath, contentMD5, sliceMD5, crc32 string, length int64) (err error) {
 	dataReadCloser, err := pcs.PrepareRapidUpload(targetPath, contentMD5, sliceMD5, crc32, length)
@@ -40,8 +47,8 @@ func (pcs *BaiduPCS) RapidUpload(targetPath, contentMD5, sliceMD5, crc32 string,
 	return nil
 }
 
-// Upload 上传单个文件
-func (pcs *BaiduPCS) Upload(targetPath string, uploadFunc UploadFunc) (err error) {
+// Upload 上传单个文件, 返回服务器保存的文件信息, 用于校验
+func (pcs *BaiduPCS) Upload(targetPath string, uploadFunc UploadFunc) (uploaded *UploadedFile, err error) {
 	dataReadCloser, err := pcs.PrepareUpload(targetPath, uploadFunc)
 	if err != nil {
 		return
@@ -51,10 +58,11 @@ func (pcs *BaiduPCS) Upload(targetPath string, uploadFunc UploadFunc) (err error
 
 	// 数据处理
 	jsonData := &struct {
-		*PathJSON
+		*UploadedFile
 		*ErrInfo
 	}{
-		ErrInfo: NewErrorInfo(OperationUpload),
+		UploadedFile: &UploadedFile{},
+		ErrInfo:      NewErrorInfo(OperationUpload),
 	}
 
 	d := jsoniter.NewDecoder(dataReadCloser)
@@ -62,18 +70,18 @@ func (pcs *BaiduPCS) Upload(targetPath string, uploadFunc UploadFunc) (err error
 	err = d.Decode(jsonData)
 	if err != nil {
 		jsonData.ErrInfo.jsonError(err)
-		return jsonData.ErrInfo
+		return nil, jsonData.ErrInfo
 	}
 
 	if jsonData.ErrCode != 0 {
-		return jsonData.ErrInfo
+		return nil, jsonData.ErrInfo
 	}
 
 	if jsonData.Path == "" {
-		return fmt.Errorf("%s, unknown response data, file saved path not found", OperationUpload)
+		return nil, fmt.Errorf("%s, unknown response data, file saved path not found", OperationUpload)
 	}
 
-	return nil
+	return jsonData.UploadedFile, nil
 }
 
 // UploadTmpFile 分片上传—文件分片及上传
@@ -113,8 +121,9 @@ func (pcs *BaiduPCS) UploadTmpFile(uploadFunc UploadFunc) (md5 string, err error
 	return jsonData.MD5, nil
 }
 
-// UploadCreateSuperFile 分片上传—合并分片文件
-func (pcs *BaiduPCS) UploadCreateSuperFile(targetPath string, blockList ...string) (err error) {
+// UploadCreateSuperFile 分片上传—合并分片文件, 返回服务器保存的文件信息,
+// 合并后的文件的 md5 并非文件内容的 md5, 不可用于校验
+func (pcs *BaiduPCS) UploadCreateSuperFile(targetPath string, blockList ...string) (uploaded *UploadedFile, err error) {
 	dataReadCloser, err := pcs.PrepareUploadCreateSuperFile(targetPath, blockList...)
 	if err != nil {
 		return
@@ -122,18 +131,24 @@ func (pcs *BaiduPCS) UploadCreateSuperFile(targetPath string, blockList ...strin
 
 	defer dataReadCloser.Close()
 
-	errInfo := NewErrorInfo(OperationUploadCreateSuperFile)
+	jsonData := &struct {
+		*UploadedFile
+		*ErrInfo
+	}{
+		UploadedFile: &UploadedFile{},
+		ErrInfo:      NewErrorInfo(OperationUploadCreateSuperFile),
+	}
 
 	d := jsoniter.NewDecoder(dataReadCloser)
-	err = d.Decode(errInfo)
+	err = d.Decode(jsonData)
 	if err != nil {
-		errInfo.jsonError(err)
-		return errInfo
+		jsonData.ErrInfo.jsonError(err)
+		return nil, jsonData.ErrInfo
 	}
 
-	if errInfo.ErrCode != 0 {
-		return errInfo
+	if jsonData.ErrCode != 0 {
+		return nil, jsonData.ErrInfo
 	}
 
-	return nil
+	return jsonData.UploadedFile, nil
 }
@@ -3,9 +3,10 @@ package downloader
 import (
 	"errors"
 	"fmt"
-	"github.com/iikira/BaiduPCS-Go/downloader/cachepool"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
 	"io"
 	"net/http"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -18,9 +19,10 @@ type Block struct {
 	speedsStat SpeedsStat
 	IsFinal    bool `json:"isfinal"` // 最后线程, 因为最后的下载线程, 需要另外做处理
 
-	resp        *http.Response
-	running     int  // 线程的载入量
-	waitToWrite bool // 是否正在写入硬盘
+	resp    *http.Response
+	mirror  *mirror    // 正在使用的镜像
+	connMu  sync.Mutex // 保护 resp 和 mirror, 其他 goroutine 会关闭线程的连接
+	running int32      // 线程的载入量, 原子操作
 }
 
 // BlockList 下载区块列表
@@ -62,7 +64,32 @@ func (b *Block) setDone() {
 // isComplete 判断线程是否空闲,
 // 即 线程已完成下载任务
 func (b *Block) isComplete() bool {
-	return b.isDone() && b.running == 0
+	return b.isDone() && atomic.LoadInt32(&b.running) == 0
+}
+
+// setConn 设置线程正在使用的连接和镜像
+func (b *Block) setConn(resp *http.Response, m *mirror) {
+	b.connMu.Lock()
+	b.resp, b.mirror = resp, m
+	b.connMu.Unlock()
+}
+
+// hasConn 线程是否有连接
+func (b *Block) hasConn() bool {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	return b.resp != nil
+}
+
+// closeConn 关闭线程的连接, m 不为 nil 时, 只关闭使用镜像 m 的连接, 返回是否关闭了连接
+func (b *Block) closeConn(m *mirror) bool {
+	b.connMu.Lock()
+	defer b.connMu.Unlock()
+	if b.resp == nil || m != nil && b.mirror != m {
+		return false
+	}
+	b.resp.Body.Close()
+	return true
 }
 
 // expectedContentLength 获取期望的 Content-Length
@@ -104,11 +131,23 @@ func (bl *BlockList) isAllDone() bool {
 
 // addExecBlock 增加线程任务
 func (der *Downloader) addExecBlock(id int) {
-	der.status.BlockList[id].running++
+	atomic.AddInt32(&der.status.BlockList[id].running, 1)
+	attempt := 0 // 重试次数
 for_2: // code 为 1 时, 不重试
 	// 其他的 code, 无限重试
 	for {
+		// 已完成的线程不占用连接
+		if der.status.BlockList[id].isDone() {
+			break
+		}
+
+		// 等待空闲的连接, 暂停则退出
+		if !der.conns.acquire() {
+			break
+		}
+
 		code, err := der.execBlock(id)
+		der.conns.release()
 
 		// 下载成功, 或者下载暂停, 退出循环
 		if code == 0 || err == nil || der.status.paused {
@@ -117,6 +156,14 @@ for_2: // code 为 1 时, 不重试
 
 		// 未成功(有错误), 继续
 		verbosef("DEBUG: thread failed, thread id: %d, code: %d, error: %s\n", id, code, err)
+		if code != 1 {
+			attempt++
+			der.emit(pcsevent.Retry, func(e *pcsevent.Event) {
+				e.BlockID = id
+				e.Attempt = attempt
+				e.Error = err.Error()
+			})
+		}
 		switch code {
 		case 1: // 不重试
 			break for_2
@@ -132,7 +179,7 @@ for_2: // code 为 1 时, 不重试
 		continue
 	}
 
-	der.status.BlockList[id].running--
+	atomic.AddInt32(&der.status.BlockList[id].running, -1)
 }
 
 // downloadBlock 块执行下载任务
@@ -143,57 +190,66 @@ func (der *Downloader) execBlock(id int) (code int, err error) {
 		return 1, errors.New("thread is done")
 	}
 
+	// 选择镜像, 各线程分散到各镜像下载
+	m := der.mirrors.pick()
+	defer der.mirrors.release(m)
+
 	// 设置 Range 请求头, 给各线程分配内容
 	// 开始 http 请求
-	block.resp, err = der.Config.Client.Req("GET", der.URL, nil, map[string]string{
+	resp, err := der.Config.Client.Req("GET", m.url, nil, der.header(map[string]string{
 		"Range": fmt.Sprintf("bytes=%d-%d", atomic.LoadInt64(&block.Begin), atomic.LoadInt64(&block.End)),
-	})
-	if block.resp != nil {
-		defer block.resp.Body.Close()
+	}))
+	block.setConn(resp, m)
+	if resp != nil {
+		defer resp.Body.Close()
 	}
 	if err != nil {
-		return 2, err
+		return der.mirrorFailed(m, err), err
 	}
 
 	// 检测响应Body的错误
 	es := block.expectedContentLength()
-	if block.resp.ContentLength != es {
-		return 2, fmt.Errorf("Content-Length is unexpected: %d, need %d", block.resp.ContentLength, es)
+	if resp.ContentLength != es && resp.StatusCode != 416 {
+		err = fmt.Errorf("Content-Length is unexpected: %d, need %d", resp.ContentLength, es)
+		return der.mirrorFailed(m, err), err
 	}
 
-	switch block.resp.StatusCode {
+	switch resp.StatusCode {
 	case 200, 206:
 		// do nothing, continue
 	case 416: //Requested Range Not Satisfiable
 		// 可能是线程在等待响应时, 已被其他线程重载
-		return 1, errors.New("thread reload, " + block.resp.Status)
-	case 403: // Forbidden
-		fallthrough
+		return 1, errors.New("thread reload, " + resp.Status)
 	case 406: // Not Acceptable
 		// 暂时不知道出错的原因......
-		return 2, errors.New(block.resp.Status)
-	case 429, 509: // Too Many Requests
-		for atomic.LoadInt64(&der.status.StatusStat.Speeds) >= atomic.LoadInt64(&der.status.StatusStat.maxSpeeds)/5 {
-			// 下载速度若不减慢, 循环就不会退出
-			time.Sleep(1 * time.Second)
-		}
-		return 2, errors.New(block.resp.Status)
+		err = errors.New(resp.Status)
+		return der.mirrorFailed(m, err), err
+	case 403, 429, 509: // Forbidden, Too Many Requests, Bandwidth Limit Exceeded
+		// 连接数过多, 被服务端限制, 减少连接数
+		der.conns.throttle(id, resp.Status)
+		der.mirrorFailed(m, errors.New(resp.Status))
+		return 2, errors.New(resp.Status)
 	default:
-		fmt.Printf("unexpected http status code, %d, %s\n", block.resp.StatusCode, block.resp.Status) // 调试
-		return 2, errors.New(block.resp.Status)
+		verbosef("DEBUG: unexpected http status code, %d, %s, host: %s\n", resp.StatusCode, resp.Status, m.host)
+		err = errors.New(resp.Status)
+		return der.mirrorFailed(m, err), err
 	}
+	der.mirrors.succeed(m)
 
 	var (
-		buf        = cachepool.SetIfNotExist(int32(id), der.Config.CacheSize)
+		buf        = der.bufOwner.Get(der.Config.CacheSize)
 		n          int
 		n64, begin int64
 		writeErr   error // 写入磁盘发生的错误
 	)
+	defer func() {
+		der.bufOwner.Put(buf)
+	}()
 
 	for {
 		begin = atomic.LoadInt64(&block.Begin) // 用于下文比较
 
-		n, err = readFullFrom(block.resp.Body, buf, &der.status.StatusStat.speedsStat, &block.speedsStat)
+		n, err = readFullFrom(resp.Body, buf, &der.status.speedsStat, &block.speedsStat, &m.speedsStat)
 
 		n64 = int64(n)
 
@@ -213,19 +269,13 @@ func (der *Downloader) execBlock(id int) (code int, err error) {
 			err = io.EOF
 		}
 
-		if !der.Config.Testing {
-			block.waitToWrite = true
-			der.writeMu.Lock()                                    // 加锁, 减轻硬盘的压力
-			_, writeErr = der.status.file.WriteAt(buf[:n], begin) // 写入数据
+		if !der.Config.Testing && n > 0 {
+			// 提交到写入线程, 缓存交由写入线程归还, 换一个新的缓存继续下载
+			writeErr = der.writer.write(begin, buf[:n])
+			buf = der.bufOwner.Get(der.Config.CacheSize)
 			if writeErr != nil {
-				der.cancel()
-				triggerOnError(der.OnCancelError, 1, fmt.Errorf("写入文件错误, %s", writeErr))
-				der.writeMu.Unlock()
 				return 1, writeErr
 			}
-
-			der.writeMu.Unlock() //解锁
-			block.waitToWrite = false
 		}
 
 		// 两次 begin 不相等, 可能已有新的空闲线程参与
@@ -236,6 +286,7 @@ func (der *Downloader) execBlock(id int) (code int, err error) {
 
 		// 更新数据
 		atomic.AddInt64(&der.status.StatusStat.Downloaded, n64)
+		atomic.AddInt64(&m.downloaded, n64)
 		atomic.AddInt64(&block.Begin, n64)
 
 		if err != nil {
@@ -2,109 +2,271 @@
 package cachepool
 
 import (
+	"fmt"
 	"sync"
-	"sync/atomic"
+)
+
+const (
+	// MinClassSize 最小的缓存大小, 小于此大小的按此大小分配
+	MinClassSize = 1024
 )
 
 var (
-	// CachePool []byte 缓存池
-	CachePool = cachePool{
-		cachepool: sync.Map{},
-	}
+	// DefaultPool 默认的缓存池
+	DefaultPool = NewPool(128 << 20)
 )
 
-type cachePool struct {
-	lastID    int32
-	cachepool sync.Map
+// Pool []byte 缓存池, 缓存按 2 的幂次方分级复用,
+// 已分配的缓存总内存 (使用中 + 空闲) 不超过 maxMemory,
+// 超出时, 申请缓存会等待其他缓存归还
+type Pool struct {
+	maxMemory int64 // 总内存上限, <= 0 表示不限制
+	allocated int64 // 已分配的总内存
+	inUse     int64 // 使用中的内存
+
+	free   map[int][][]byte // 各级空闲的缓存
+	owners map[*byte]*Owner // 使用中的缓存, 以及其所属
+	stats  Stats
+
+	mu   sync.Mutex
+	cond *sync.Cond
+}
+
+// Stats 缓存池统计信息
+type Stats struct {
+	MaxMemory int64 // 总内存上限
+	Allocated int64 // 已分配的总内存
+	InUse     int64 // 使用中的内存
+	Idle      int64 // 空闲的内存
+	Owners    int   // 正在使用缓存池的任务数
+	Gets      int64 // 申请缓存的次数
+	Allocs    int64 // 新分配缓存的次数
+	Waits     int64 // 因内存不足而等待的次数
+}
+
+// Owner 缓存的所属者, 一般对应一个传输任务,
+// 任务结束时调用 Release 丢弃所有未归还的缓存
+type Owner struct {
+	pool *Pool
+	bufs map[*byte][]byte // 使用中的缓存
+}
+
+// NewPool 返回总内存上限为 maxMemory 的缓存池
+func NewPool(maxMemory int64) *Pool {
+	p := &Pool{
+		maxMemory: maxMemory,
+		free:      map[int][][]byte{},
+		owners:    map[*byte]*Owner{},
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// classSize 返回 size 所属级别的缓存大小
+func classSize(size int) int {
+	cs := MinClassSize
+	for cs < size {
+		cs <<= 1
+	}
+	return cs
+}
+
+// SetMaxMemory 设置总内存上限, <= 0 表示不限制
+func (p *Pool) SetMaxMemory(maxMemory int64) {
+	p.mu.Lock()
+	p.maxMemory = maxMemory
+	p.trimIdle(0)
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// NewOwner 创建缓存的所属者
+func (p *Pool) NewOwner() *Owner {
+	p.mu.Lock()
+	p.stats.Owners++
+	p.mu.Unlock()
+
+	return &Owner{
+		pool: p,
+		bufs: map[*byte][]byte{},
+	}
+}
+
+// overLimit 再分配 size 大小的内存, 是否超出上限
+func (p *Pool) overLimit(size int) bool {
+	return p.maxMemory > 0 && p.allocated+int64(size) > p.maxMemory
+}
+
+// trimIdle 释放空闲的缓存, 直到可以再分配 size 大小的内存,
+// 返回是否释放了缓存
+func (p *Pool) trimIdle(size int) (trimmed bool) {
+	for cs, list := range p.free {
+		for len(list) > 0 && p.overLimit(size) {
+			list[len(list)-1] = nil
+			list = list[:len(list)-1]
+			p.allocated -= int64(cs)
+			trimmed = true
+		}
+		p.free[cs] = list
+	}
+	return
 }
 
-func (cp *cachePool) Apply(size int) (id int32) {
+func (p *Pool) get(o *Owner, size int) []byte {
+	cs := classSize(size)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stats.Gets++
+
+	var buf []byte
 	for {
-		_, ok := cp.cachepool.Load(cp.lastID)
-		atomic.AddInt32(&cp.lastID, 1)
-		if ok {
-			continue
+		// 优先复用空闲的缓存
+		if list := p.free[cs]; len(list) > 0 {
+			buf = list[len(list)-1]
+			list[len(list)-1] = nil
+			p.free[cs] = list[:len(list)-1]
+			break
 		}
+
+		// 超出上限, 释放其他级别空闲的缓存, 或等待缓存归还,
+		// 没有使用中的缓存时, 总是允许分配, 避免永久等待
+		if p.overLimit(cs) {
+			if p.trimIdle(cs) {
+				continue
+			}
+			if p.inUse > 0 {
+				p.stats.Waits++
+				p.cond.Wait()
+				continue
+			}
+		}
+
+		buf = make([]byte, cs)
+		p.allocated += int64(cs)
+		p.stats.Allocs++
 		break
 	}
 
-	cp.Set(cp.lastID, size)
-	return cp.lastID
+	p.inUse += int64(cs)
+	p.owners[&buf[0]] = o
+	if o != nil {
+		o.bufs[&buf[0]] = buf
+	}
+	return buf[:size]
 }
 
-func (cp *cachePool) Existed(id int32) (existed bool) {
-	_, existed = cp.cachepool.Load(id)
-	return
-}
+// put 归还缓存, 调用者需持有锁
+func (p *Pool) put(buf []byte) {
+	if cap(buf) == 0 {
+		return
+	}
 
-func (cp *cachePool) Get(id int32) []byte {
-	cache, ok := cp.cachepool.Load(id)
-	if !ok {
-		return nil
+	buf = buf[:cap(buf)]
+	o, ok := p.owners[&buf[0]]
+	if !ok { // 不属于缓存池, 或已归还
+		return
 	}
-	return cache.([]byte)
+
+	delete(p.owners, &buf[0])
+	if o != nil {
+		delete(o.bufs, &buf[0])
+	}
+
+	cs := cap(buf)
+	p.inUse -= int64(cs)
+	if p.maxMemory > 0 && p.allocated > p.maxMemory {
+		// 上限被调低, 直接丢弃
+		p.allocated -= int64(cs)
+	} else {
+		p.free[cs] = append(p.free[cs], buf)
+	}
+	p.cond.Broadcast()
 }
 
-func (cp *cachePool) Set(id int32, size int) []byte {
-	cache := make([]byte, size)
-	cp.cachepool.Store(id, cache)
-	return cp.Get(id)
+// Get 申请大小为 size 的缓存, 内存不足时等待, 不属于任何任务
+func (p *Pool) Get(size int) []byte {
+	return p.get(nil, size)
 }
 
-func (cp *cachePool) SetIfNotExist(id int32, size int) []byte {
-	ok := cp.Existed(id)
-	cache := cp.Get(id)
-	if !ok || len(cache) < size {
-		cache = nil
-		cp.Delete(id)
-		cp.Set(id, size)
-	}
-	return cp.Get(id)
+// Put 归还缓存, 重复归还或归还不属于缓存池的 []byte 会被忽略
+func (p *Pool) Put(buf []byte) {
+	p.mu.Lock()
+	p.put(buf)
+	p.mu.Unlock()
+}
+
+// Stats 返回缓存池统计信息
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats
+	s.MaxMemory = p.maxMemory
+	s.Allocated = p.allocated
+	s.InUse = p.inUse
+	s.Idle = p.allocated - p.inUse
+	return s
 }
 
-func (cp *cachePool) Delete(id int32) {
-	cp.cachepool.Store(id, nil)
-	cp.cachepool.Delete(id)
+// Get 为任务申请大小为 size 的缓存
+func (o *Owner) Get(size int) []byte {
+	return o.pool.get(o, size)
 }
 
-func (cp *cachePool) DeleteAll() {
-	cp.cachepool.Range(func(k interface{}, _ interface{}) bool {
-		cp.Delete(k.(int32))
-		return true
-	})
+// Put 归还任务的缓存
+func (o *Owner) Put(buf []byte) {
+	o.pool.Put(buf)
 }
 
-// Apply 申请缓存, 返回缓存id
-func Apply(size int) (id int32) {
-	return CachePool.Apply(size)
+// Release 任务结束时调用, 未归还的缓存可能仍被任务引用,
+// 不再复用, 直接从缓存池中丢弃, 之后再归还的会被忽略
+func (o *Owner) Release() {
+	p := o.pool
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if o.bufs == nil { // 已经释放
+		return
+	}
+
+	for ptr, buf := range o.bufs {
+		delete(p.owners, ptr)
+		p.inUse -= int64(cap(buf))
+		p.allocated -= int64(cap(buf))
+	}
+	o.bufs = nil
+	p.stats.Owners--
+	p.cond.Broadcast()
 }
 
-// Existed 通过缓存id检测是否存在缓存
-func Existed(id int32) bool {
-	return CachePool.Existed(id)
+func (s Stats) String() string {
+	return fmt.Sprintf("max memory: %d, allocated: %d, in use: %d, idle: %d, owners: %d, gets: %d, allocs: %d, waits: %d",
+		s.MaxMemory, s.Allocated, s.InUse, s.Idle, s.Owners, s.Gets, s.Allocs, s.Waits)
 }
 
-// Get 通过缓存id获取缓存[]byte
-func Get(id int32) []byte {
-	return CachePool.Get(id)
+// SetMaxMemory 设置默认缓存池的总内存上限
+func SetMaxMemory(maxMemory int64) {
+	DefaultPool.SetMaxMemory(maxMemory)
 }
 
-// Set 设置缓存, 通过给定的缓存id
-func Set(id int32, size int) []byte {
-	return CachePool.Set(id, size)
+// NewOwner 在默认缓存池中创建缓存的所属者
+func NewOwner() *Owner {
+	return DefaultPool.NewOwner()
 }
 
-// SetIfNotExist 如果缓存不存在, 则设置缓存池
-func SetIfNotExist(id int32, size int) []byte {
-	return CachePool.SetIfNotExist(id, size)
+// Get 从默认缓存池申请缓存
+func Get(size int) []byte {
+	return DefaultPool.Get(size)
 }
 
-// Delete 通过缓存id删除缓存
-func Delete(id int32) {
-	CachePool.Delete(id)
+// Put 归还缓存到默认缓存池
+func Put(buf []byte) {
+	DefaultPool.Put(buf)
 }
 
-// DeleteAll 清空缓存池
-func DeleteAll() {
-	CachePool.DeleteAll()
+// GetStats 返回默认缓存池的统计信息
+func GetStats() Stats {
+	return DefaultPool.Stats()
 }
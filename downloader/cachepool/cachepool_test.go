@@ -0,0 +1,91 @@
+package cachepool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPoolReuse(t *testing.T) {
+	p := NewPool(0)
+	o := p.NewOwner()
+
+	buf := o.Get(3000)
+	if len(buf) != 3000 || cap(buf) != 4096 {
+		t.Fatalf("len %d, cap %d", len(buf), cap(buf))
+	}
+	o.Put(buf)
+	o.Put(buf) // 重复归还
+
+	buf2 := o.Get(4000)
+	if &buf2[0] != &buf[0] {
+		t.Fatal("buffer not reused")
+	}
+
+	s := p.Stats()
+	if s.Allocs != 1 || s.InUse != 4096 || s.Idle != 0 {
+		t.Fatalf("unexpected stats: %s", s)
+	}
+}
+
+func TestPoolLimit(t *testing.T) {
+	p := NewPool(8192)
+	o := p.NewOwner()
+
+	var (
+		a, b = o.Get(4096), o.Get(4096)
+		c    []byte
+	)
+
+	got := make(chan []byte)
+	go func() {
+		got <- o.Get(4096)
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("allocated over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	o.Put(a)
+	select {
+	case c = <-got:
+		if &c[0] != &a[0] {
+			t.Fatal("buffer not reused")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting after put")
+	}
+
+	o.Put(b)
+	o.Put(c)
+	// 其他级别的空闲缓存会被释放
+	d := o.Get(8192)
+	if s := p.Stats(); s.Allocated != 8192 || s.Waits != 1 {
+		t.Fatalf("unexpected stats: %s", s)
+	}
+	o.Put(d)
+}
+
+func TestOwnerRelease(t *testing.T) {
+	p := NewPool(4096)
+	o1, o2 := p.NewOwner(), p.NewOwner()
+
+	buf := o1.Get(4096)
+	o1.Release()
+
+	if s := p.Stats(); s.InUse != 0 || s.Allocated != 0 || s.Owners != 1 {
+		t.Fatalf("unexpected stats: %s", s)
+	}
+
+	// 已丢弃的缓存不会被复用
+	buf2 := o2.Get(4096)
+	if &buf2[0] == &buf[0] {
+		t.Fatal("released buffer reused")
+	}
+	o1.Put(buf)
+	if s := p.Stats(); s.InUse != 4096 {
+		t.Fatalf("unexpected stats: %s", s)
+	}
+	o2.Release()
+}
@@ -1,20 +1,24 @@
 package downloader
 
 import (
-	"fmt"
+	"errors"
 	"mime"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // Check 检查配置, 环境, 准备下载条件
 func (der *Downloader) Check() (err error) {
 	der.Config.Fix()
 
-	// 如果文件存在, 取消下载
+	// 如果文件存在, 且不覆盖, 取消下载
 	// 测试下载时, 则不检查
-	if !der.Config.Testing {
+	if !der.Config.Testing && !der.Config.IsOverwrite {
 		if der.Config.SavePath != "" {
 			err = checkFileExist(der.Config.SavePath)
 			if err != nil {
@@ -23,24 +27,25 @@ func (der *Downloader) Check() (err error) {
 		}
 	}
 
-	// 获取文件信息
-	resp, err := der.Config.Client.Req("HEAD", der.URL, nil, nil)
-	if err != nil {
-		return
-	}
-
-	// 检测网络错误
-	switch resp.StatusCode / 100 {
-	case 2: // succeed
-	case 4, 5: // error
-		return fmt.Errorf(resp.Status)
+	// 获取文件信息, 服务端不支持 HEAD 请求时, 改用 Range 请求探测
+	resp, err := der.Config.Client.Req("HEAD", der.URL, nil, der.header(nil))
+	if err == nil && resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		err = errors.New(resp.Status)
 	}
+	if err != nil {
+		verbosef("DEBUG: HEAD failed, %s, probe with range request\n", err)
+		resp, err = der.probe()
+		if err != nil {
+			return
+		}
+	} else {
+		der.status.StatusStat.TotalSize = resp.ContentLength
 
-	der.status.StatusStat.TotalSize = resp.ContentLength
-
-	// 判断服务端是否支持断点续传
-	if resp.ContentLength <= 0 {
-		der.status.blockUnsupport = true
+		// 判断服务端是否支持断点续传
+		if resp.ContentLength <= 0 {
+			der.status.blockUnsupport = true
+		}
 	}
 
 	if !der.Config.Testing && der.Config.SavePath == "" {
@@ -51,14 +56,20 @@ func (der *Downloader) Check() (err error) {
 		}
 
 		if err != nil || der.Config.SavePath == "" {
-			// 找不到文件名, 凑合吧
+			// 找不到文件名, 使用网址的文件名
 			der.Config.SavePath = filepath.Base(der.URL)
+			if u, uerr := url.Parse(der.URL); uerr == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+				der.Config.SavePath = path.Base(u.Path)
+			}
 		}
+		der.Config.SavePath = filepath.Join(der.Config.SaveDir, filepath.Base(der.Config.SavePath))
 
-		// 如果文件存在, 取消下载
-		err = checkFileExist(der.Config.SavePath)
-		if err != nil {
-			return err
+		// 如果文件存在, 且不覆盖, 取消下载
+		if !der.Config.IsOverwrite {
+			err = checkFileExist(der.Config.SavePath)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -80,14 +91,22 @@ func (der *Downloader) Check() (err error) {
 		}
 
 		// 检测要下载的文件是否存在
-		// 如果存在, 则打开文件
+		// 如果存在, 则打开文件, 没有断点信息的 (覆盖文件), 清空文件
 		// 不存在则创建文件
-		file, err := os.OpenFile(der.Config.SavePath, os.O_RDWR|os.O_CREATE, 0666)
+		flag := os.O_RDWR | os.O_CREATE
+		if _, err = os.Stat(der.Config.SavePath + DownloadingFileSuffix); err != nil {
+			flag |= os.O_TRUNC
+		}
+
+		file, err := os.OpenFile(der.Config.SavePath, flag, 0666)
 		if err != nil {
 			return err
 		}
 
 		der.status.file = file
+		if der.Config.WrapWriter != nil {
+			der.status.file = der.Config.WrapWriter(file)
+		}
 	} else {
 		der.status.file, _ = os.Open(os.DevNull)
 	}
@@ -95,3 +114,39 @@ func (der *Downloader) Check() (err error) {
 	der.checked = true
 	return resp.Body.Close()
 }
+
+// probe 使用 Range: bytes=0-0 请求探测文件大小, 以及服务端是否支持断点续传,
+// 用于不支持 HEAD 请求的服务端
+func (der *Downloader) probe() (resp *http.Response, err error) {
+	resp, err = der.Config.Client.Req("GET", der.URL, nil, der.header(map[string]string{
+		"Range": "bytes=0-0",
+	}))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 206: // Partial Content
+		// Content-Range: bytes 0-0/1024
+		contentRange := resp.Header.Get("Content-Range")
+		i := strings.LastIndex(contentRange, "/")
+		if i != -1 {
+			der.status.StatusStat.TotalSize, err = strconv.ParseInt(contentRange[i+1:], 10, 64)
+		}
+		if i == -1 || err != nil {
+			// 不知道文件大小
+			der.status.StatusStat.TotalSize = 0
+			der.status.blockUnsupport = true
+		}
+		return resp, nil
+	}
+
+	switch resp.StatusCode / 100 {
+	case 2: // 不支持 Range 请求, 返回了整个文件
+		der.status.StatusStat.TotalSize = resp.ContentLength
+		der.status.blockUnsupport = true
+		return resp, nil
+	}
+	return nil, errors.New(resp.Status)
+}
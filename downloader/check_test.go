@@ -0,0 +1,66 @@
+package downloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestProbe 服务端不支持 HEAD 请求时, 使用 Range 请求探测文件大小
+func TestProbe(t *testing.T) {
+	data := make([]byte, 4*1024*1024)
+	rand.Read(data)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "data", time.Time{}, bytes.NewReader(data))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := NewConfig()
+	cfg.SaveDir = dir
+	cfg.Parallel = 4
+	cfg.Header = map[string]string{"X-Token": "secret"}
+
+	der, err := NewDownloader(ts.URL+"/files/probe.bin", *cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := der.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if der.status.blockUnsupport || der.status.StatusStat.TotalSize != int64(len(data)) {
+		t.Fatalf("probe: blockUnsupport %v, total size %d", der.status.blockUnsupport, der.status.StatusStat.TotalSize)
+	}
+
+	saved, err := ioutil.ReadFile(filepath.Join(dir, "probe.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Fatal("data mismatch")
+	}
+}
@@ -3,6 +3,7 @@ package downloader
 import (
 	"github.com/iikira/BaiduPCS-Go/pcsutil"
 	"github.com/iikira/BaiduPCS-Go/requester"
+	"time"
 )
 
 var (
@@ -11,16 +12,30 @@ var (
 
 	// MinParallelSize 单个线程最小的数据量
 	MinParallelSize = 128 * pcsutil.KB
+
+	// InitialParallel 自适应调整连接数时, 初始的连接数
+	InitialParallel = 4
 )
 
 // Config 下载配置
 type Config struct {
-	Client    *requester.HTTPClient // http 客户端
-	SavePath  string                // relative or absulute path
-	remotePath string				// 云盘地址
-	Parallel  int                   // 最大下载并发量
-	CacheSize int                   // 下载缓冲
-	Testing   bool                  // 是否测试下载
+	Client        *requester.HTTPClient // http 客户端
+	SavePath      string                // relative or absulute path
+	SaveDir       string                // SavePath 为空时, 根据服务端返回的文件名, 保存到此目录
+	Header        map[string]string     // 附加的请求头
+	Mirrors       []string              // 镜像, 即同一文件的其他下载链接, 各线程分散到 URL 和各镜像下载
+	remotePath    string                // 云盘地址
+	Parallel      int                   // 最大下载并发量
+	FixedParallel bool                  // 固定使用 Parallel 个连接, 不自适应调整
+	IsOverwrite   bool                  // 本地文件已存在时, 覆盖文件
+	CacheSize     int                   // 下载缓冲
+	Preallocate   bool                  // 下载前预分配硬盘空间
+	SyncInterval  time.Duration         // 定期将数据同步到硬盘的间隔, 0 表示不同步
+	Testing       bool                  // 是否测试下载
+
+	// WrapWriter 包装保存下载内容的文件, 例如写入时解密, 为 nil 则直接写入文件.
+	// 偏移量仍为下载内容的偏移量, 包装后不再预分配硬盘空间
+	WrapWriter func(w Writer) Writer
 }
 
 // NewConfig 返回预设配置
@@ -0,0 +1,166 @@
+package downloader
+
+import (
+	"sync"
+	"time"
+)
+
+// connController 以 AIMD (加性增, 乘性减) 的方式控制下载的连接数,
+// 总下载速度持续上升时, 逐个增加连接数;
+// 服务端返回 429, 509, 403 等限制信号时, 将连接数减半
+type connController struct {
+	min, max int // 连接数的下限和上限
+	limit    int // 当前允许的连接数
+	active   int // 当前活跃的连接数
+	paused   bool
+
+	window      int       // 每轮调整所统计的监控周期数
+	samples     int       // 本轮已统计的周期数
+	sum         int64     // 本轮统计的速度总和
+	lastSpeeds  int64     // 上一轮的平均速度
+	saturated   bool      // 本轮内连接数是否曾达到上限
+	throttled   int       // 本轮内收到限制信号的次数
+	lastBackoff time.Time // 上次减少连接数的时间
+
+	mu   sync.Mutex
+	cond *sync.Cond
+}
+
+// newConnController 返回 connController, adaptive 为 false 时, 连接数固定为 max
+func newConnController(max int, adaptive bool) *connController {
+	if max < 1 {
+		max = 1
+	}
+
+	cc := &connController{
+		min:    1,
+		max:    max,
+		limit:  max,
+		window: 3,
+	}
+	cc.cond = sync.NewCond(&cc.mu)
+
+	if adaptive {
+		if InitialParallel < max {
+			cc.limit = InitialParallel
+		}
+	} else {
+		cc.min = max
+	}
+	return cc
+}
+
+// acquire 申请一个连接, 连接数已满时阻塞等待,
+// 暂停时返回 false
+func (cc *connController) acquire() bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for !cc.paused && cc.active >= cc.limit {
+		cc.cond.Wait()
+	}
+
+	if cc.paused {
+		return false
+	}
+
+	cc.active++
+	if cc.active >= cc.limit {
+		cc.saturated = true
+	}
+	return true
+}
+
+// release 释放连接
+func (cc *connController) release() {
+	cc.mu.Lock()
+	cc.active--
+	cc.mu.Unlock()
+	cc.cond.Signal()
+}
+
+// pause 暂停, 唤醒所有等待连接的线程
+func (cc *connController) pause() {
+	cc.mu.Lock()
+	cc.paused = true
+	cc.mu.Unlock()
+	cc.cond.Broadcast()
+}
+
+// resume 恢复
+func (cc *connController) resume() {
+	cc.mu.Lock()
+	cc.paused = false
+	cc.mu.Unlock()
+}
+
+// throttle 收到服务端的限制信号, 乘性减少连接数
+func (cc *connController) throttle(id int, status string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.throttled++
+
+	// 同一时间多个线程收到限制信号, 只减少一次
+	if time.Since(cc.lastBackoff) < time.Duration(cc.window)*time.Second {
+		return
+	}
+
+	old := cc.limit
+	cc.limit /= 2
+	if cc.limit < cc.min {
+		cc.limit = cc.min
+	}
+	cc.lastBackoff = time.Now()
+	cc.resetWindow()
+
+	if cc.limit != old {
+		verbosef("CONTROLLER: thread %d got %s, connections: %d -> %d\n", id, status, old, cc.limit)
+	}
+}
+
+// adjust 每个监控周期调用一次, speeds 为当前总下载速度,
+// 返回超出限制, 需要关闭的连接数
+func (cc *connController) adjust(speeds int64) (excess int) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.active > cc.limit {
+		excess = cc.active - cc.limit
+	}
+
+	cc.sum += speeds
+	cc.samples++
+	if cc.samples < cc.window {
+		return
+	}
+
+	avg := cc.sum / int64(cc.samples)
+
+	// 速度上升超过 5%, 且连接数已被用满, 说明还有余量, 增加连接数
+	// 刚减少过连接数的, 需等待一轮再尝试增加
+	if cc.throttled == 0 && cc.saturated && cc.limit < cc.max && avg > cc.lastSpeeds+cc.lastSpeeds/20 &&
+		time.Since(cc.lastBackoff) >= 2*time.Duration(cc.window)*time.Second {
+		cc.limit++
+		verbosef("CONTROLLER: speeds rising %d -> %d, connections: %d -> %d\n", cc.lastSpeeds, avg, cc.limit-1, cc.limit)
+		cc.cond.Signal()
+	}
+
+	cc.lastSpeeds = avg
+	cc.resetWindow()
+	return
+}
+
+func (cc *connController) resetWindow() {
+	cc.sum = 0
+	cc.samples = 0
+	cc.saturated = cc.active >= cc.limit
+	cc.throttled = 0
+}
+
+// getLimit 返回当前允许的连接数
+func (cc *connController) getLimit() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.limit
+}
@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnControllerAIMD(t *testing.T) {
+	cc := newConnController(10, true)
+	if cc.getLimit() != InitialParallel {
+		t.Fatalf("initial limit %d, want %d", cc.getLimit(), InitialParallel)
+	}
+
+	for i := 0; i < cc.getLimit(); i++ {
+		if !cc.acquire() {
+			t.Fatal("acquire failed")
+		}
+	}
+
+	// 速度持续上升, 每轮增加一个连接
+	var speeds int64 = 1000
+	for round := 0; round < 2; round++ {
+		for i := 0; i < cc.window; i++ {
+			cc.adjust(speeds)
+		}
+		speeds *= 2
+
+		// 用满新增的连接
+		for cc.active < cc.getLimit() {
+			cc.acquire()
+		}
+	}
+	if cc.getLimit() != InitialParallel+2 {
+		t.Fatalf("limit after rising %d, want %d", cc.getLimit(), InitialParallel+2)
+	}
+
+	// 连接数未用满, 不再增加
+	cc.release()
+	for i := 0; i < 2*cc.window; i++ {
+		cc.adjust(speeds / 2)
+	}
+	for i := 0; i < cc.window; i++ {
+		cc.adjust(speeds)
+	}
+	if cc.getLimit() != InitialParallel+2 {
+		t.Fatalf("limit increased while not saturated: %d", cc.getLimit())
+	}
+
+	// 收到限制信号, 连接数减半, 短时间内不重复减少
+	cc.throttle(0, "429 Too Many Requests")
+	cc.throttle(1, "429 Too Many Requests")
+	if cc.getLimit() != (InitialParallel+2)/2 {
+		t.Fatalf("limit after throttle %d, want %d", cc.getLimit(), (InitialParallel+2)/2)
+	}
+
+	// 超出限制的连接需要关闭
+	if excess := cc.adjust(speeds); excess != cc.active-cc.getLimit() {
+		t.Fatalf("excess %d, want %d", excess, cc.active-cc.getLimit())
+	}
+}
+
+func TestConnControllerFixed(t *testing.T) {
+	cc := newConnController(8, false)
+	cc.throttle(0, "509 Bandwidth Limit Exceeded")
+	if cc.getLimit() != 8 {
+		t.Fatalf("fixed limit changed to %d", cc.getLimit())
+	}
+}
+
+func TestConnControllerPause(t *testing.T) {
+	cc := newConnController(1, true)
+	cc.acquire()
+
+	done := make(chan bool)
+	go func() {
+		done <- cc.acquire()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cc.pause()
+
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("acquire should fail when paused")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pause did not wake waiting acquire")
+	}
+}
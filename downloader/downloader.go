@@ -2,10 +2,14 @@
 package downloader
 
 import (
+	"errors"
 	"fmt"
 	"github.com/iikira/BaiduPCS-Go/downloader/cachepool"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
 	"io"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,9 +24,14 @@ type Downloader struct {
 	OnCancel      func()                    // 手动取消
 	OnCancelError func(code int, err error) // 中途遇到下载错误而取消的
 
+	Events pcsevent.Emitter // 下载事件
+
 	status    Status
+	conns     *connController  // 控制连接数
+	bufOwner  *cachepool.Owner // 下载缓存
 	sinceTime time.Time
-	writeMu   sync.Mutex
+	writer    blockWriter // 写入线程
+	mirrors   *mirrorSet  // 镜像
 	monitorMu sync.Mutex
 
 	URL    string
@@ -90,9 +99,31 @@ func (der *Downloader) Execute() (done <-chan struct{}, err error) {
 			// 将余出数据分配给最后一个线程
 			der.status.BlockList[der.Config.Parallel-1].End = der.status.StatusStat.TotalSize
 			der.status.BlockList[der.Config.Parallel-1].IsFinal = true
+
+			// 预分配硬盘空间
+			if file, ok := der.status.file.(*os.File); ok && der.Config.Preallocate && !der.Config.Testing {
+				if perr := preallocate(file, der.status.StatusStat.TotalSize); perr != nil {
+					verbosef("DEBUG: preallocate failed, %s\n", perr)
+				}
+			}
 		}
 	}
 
+	der.mirrors = newMirrorSet(append([]string{der.URL}, der.Config.Mirrors...))
+	if len(der.mirrors.list) > 1 {
+		verbosef("MIRROR: %d mirror(s)\n", len(der.mirrors.list))
+	}
+
+	// 自适应调整连接数, 不超过 Parallel
+	der.conns = newConnController(der.Config.Parallel, !der.Config.FixedParallel)
+	verbosef("CONTROLLER: initial connections: %d, max: %d\n", der.conns.getLimit(), der.Config.Parallel)
+
+	der.bufOwner = cachepool.NewOwner()
+	der.writer = newBlockWriter(der.status.file, der.bufOwner, der.Config.SyncInterval, func(werr error) {
+		der.cancel()
+		triggerOnError(der.OnCancelError, 1, fmt.Errorf("写入文件错误, %s", werr))
+	})
+
 	verbosef("DEBUG: download start\n")
 
 	go func() {
@@ -104,13 +135,15 @@ func (der *Downloader) Execute() (done <-chan struct{}, err error) {
 
 		// 开始下载
 		der.sinceTime = time.Now()
+		der.emit(pcsevent.Started, func(e *pcsevent.Event) {
+			e.Connections = der.conns.getLimit()
+		})
+		go der.progressEmitter()
 
+		var derr error
 		if der.status.blockUnsupport {
 			// 不支持断点续传
-			serr := der.singleDownload()
-			if serr != nil {
-				err = serr
-			}
+			derr = der.singleDownload()
 		} else {
 			for id := range der.status.BlockList {
 				// 分配缓存空间
@@ -122,8 +155,26 @@ func (der *Downloader) Execute() (done <-chan struct{}, err error) {
 		}
 
 		// 下载结束
-		der.status.done = true
+		der.status.setDone()
+		if werr := der.writer.close(); werr != nil && derr == nil {
+			derr = werr
+		}
 		der.status.file.Close()
+		der.bufOwner.Release()
+		verbosef("CACHEPOOL: %s\n", cachepool.GetStats())
+		if len(der.mirrors.list) > 1 {
+			for _, stat := range der.mirrors.stats() {
+				verbosef("MIRROR: %s\n", stat)
+			}
+		}
+
+		if derr != nil {
+			der.emit(pcsevent.Failed, func(e *pcsevent.Event) {
+				e.Error = derr.Error()
+			})
+		} else {
+			der.emit(pcsevent.Finished, nil)
+		}
 		trigger(der.OnFinish)
 		verbosef("DEBUG: download finish\n")
 	}()
@@ -139,9 +190,13 @@ func (der *Downloader) Pause() {
 	}
 
 	der.status.paused = true
+	if der.conns != nil {
+		der.conns.pause()
+	}
+	der.emit(pcsevent.Paused, nil)
 	for _, block := range der.status.BlockList {
-		if block != nil && block.resp != nil && !block.resp.Close {
-			block.resp.Body.Close()
+		if block != nil {
+			block.closeConn(nil)
 		}
 	}
 }
@@ -154,6 +209,10 @@ func (der *Downloader) Resume() {
 	}
 
 	der.status.paused = false
+	if der.conns != nil {
+		der.conns.resume()
+	}
+	der.emit(pcsevent.Resumed, nil)
 	for id := range der.status.BlockList {
 		go der.addExecBlock(id)
 	}
@@ -168,8 +227,8 @@ func (der *Downloader) Cancel() {
 func (der *Downloader) cancel() {
 	// 关闭所有连接
 	for _, block := range der.status.BlockList {
-		if block != nil && block.resp != nil && !block.resp.Close {
-			block.resp.Body.Close()
+		if block != nil {
+			block.closeConn(nil)
 		}
 	}
 
@@ -179,7 +238,7 @@ func (der *Downloader) cancel() {
 }
 
 func (der *Downloader) singleDownload() (err error) {
-	der.status.singleResp, err = der.Config.Client.Req("GET", der.URL, nil, nil)
+	der.status.singleResp, err = der.Config.Client.Req("GET", der.URL, nil, der.header(nil))
 	if der.status.singleResp != nil {
 		defer der.status.singleResp.Body.Close()
 	}
@@ -189,19 +248,20 @@ func (der *Downloader) singleDownload() (err error) {
 
 	switch der.status.singleResp.StatusCode / 100 {
 	case 4, 5:
-		return fmt.Errorf(der.status.singleResp.Status)
+		return errors.New(der.status.singleResp.Status)
 	}
 
 	var (
-		buf = cachepool.SetIfNotExist(0, der.Config.CacheSize)
+		buf = der.bufOwner.Get(der.Config.CacheSize)
 		n   int
 	)
+	defer der.bufOwner.Put(buf)
 
 	for {
 		n, err = io.ReadFull(der.status.singleResp.Body, buf)
 		n64 := int64(n)
 
-		der.status.StatusStat.speedsStat.AddReaded(n64)
+		der.status.speedsStat.AddReaded(n64)
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -215,7 +275,7 @@ func (der *Downloader) singleDownload() (err error) {
 			return err
 		}
 
-		der.status.StatusStat.Downloaded += n64
+		atomic.AddInt64(&der.status.StatusStat.Downloaded, n64)
 	}
 
 	return nil
@@ -0,0 +1,70 @@
+package downloader
+
+import (
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
+	"sync/atomic"
+	"time"
+)
+
+// newEvent 生成当前下载状态的事件
+func (der *Downloader) newEvent(typ pcsevent.Type) *pcsevent.Event {
+	e := &pcsevent.Event{
+		Type:        typ,
+		Kind:        pcsevent.KindDownload,
+		LocalPath:   der.Config.SavePath,
+		Total:       der.status.StatusStat.TotalSize,
+		Transferred: atomic.LoadInt64(&der.status.StatusStat.Downloaded),
+		Speed:       atomic.LoadInt64(&der.status.StatusStat.Speeds),
+	}
+	if !der.sinceTime.IsZero() {
+		e.Elapsed = time.Since(der.sinceTime)
+	}
+	return e
+}
+
+// emit 发布事件, 没有订阅者时忽略
+func (der *Downloader) emit(typ pcsevent.Type, fn func(e *pcsevent.Event)) {
+	if !der.Events.HasSubscriber() {
+		return
+	}
+
+	e := der.newEvent(typ)
+	if fn != nil {
+		fn(e)
+	}
+	der.Events.Emit(e)
+}
+
+// progressEmitter 每秒发布一次下载进度, 直到下载结束
+func (der *Downloader) progressEmitter() {
+	for {
+		time.Sleep(1 * time.Second)
+		if der.status.isDone() {
+			return
+		}
+
+		if der.status.paused {
+			continue
+		}
+
+		der.emit(pcsevent.Progress, func(e *pcsevent.Event) {
+			// 针对单线程下载的速度统计
+			if der.status.blockUnsupport {
+				e.Speed = der.status.speedsStat.GetSpeedsPerSecond()
+				return
+			}
+
+			e.Connections = der.conns.getLimit()
+			e.Blocks = make([]pcsevent.BlockStat, 0, len(der.status.BlockList))
+			for k, block := range der.status.BlockList {
+				e.Blocks = append(e.Blocks, pcsevent.BlockStat{
+					ID:    k,
+					Begin: atomic.LoadInt64(&block.Begin),
+					End:   atomic.LoadInt64(&block.End),
+					Speed: atomic.LoadInt64(&block.speed),
+					Done:  block.isDone(),
+				})
+			}
+		})
+	}
+}
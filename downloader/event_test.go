@@ -0,0 +1,86 @@
+package downloader
+
+import (
+	"bytes"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDownloadEvents(t *testing.T) {
+	data := make([]byte, 8*1024*1024)
+	rand.Read(data)
+
+	ts := newThrottledServer(data, 1024*1024)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := NewConfig()
+	cfg.SavePath = filepath.Join(dir, "data")
+	cfg.Parallel = 4
+
+	der, err := NewDownloader(ts.URL, *cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		events []pcsevent.Event
+		mu     sync.Mutex
+	)
+	der.Events.Subscribe(func(e *pcsevent.Event) {
+		mu.Lock()
+		events = append(events, *e)
+		mu.Unlock()
+	})
+
+	done, err := der.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	saved, err := ioutil.ReadFile(cfg.SavePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Fatal("data mismatch")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(events) < 3 {
+		t.Fatalf("got %d events", len(events))
+	}
+	if events[0].Type != pcsevent.Started {
+		t.Fatalf("first event %s", events[0].Type)
+	}
+	last := events[len(events)-1]
+	if last.Type != pcsevent.Finished || last.Transferred != int64(len(data)) || last.Total != int64(len(data)) {
+		t.Fatalf("last event %+v", last)
+	}
+
+	var progress int
+	for _, e := range events {
+		if e.Type == pcsevent.Progress {
+			progress++
+			if len(e.Blocks) == 0 || e.Kind != pcsevent.KindDownload {
+				t.Fatalf("progress event %+v", e)
+			}
+		}
+	}
+	if progress == 0 {
+		t.Fatal("no progress event")
+	}
+}
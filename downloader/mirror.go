@@ -0,0 +1,242 @@
+package downloader
+
+import (
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	// MaxMirrorFailures 镜像连续请求失败达到此次数时, 停用该镜像
+	MaxMirrorFailures = 3
+
+	// SlowMirrorChecks 镜像的单连接速度连续低于最快镜像的 1/10 达到此次数 (每秒检查一次) 时, 停用该镜像
+	SlowMirrorChecks = 10
+)
+
+// mirror 下载镜像, 即同一文件的另一个下载链接
+type mirror struct {
+	url        string
+	host       string
+	speedsStat SpeedsStat
+	speed      int64 // 速度
+	downloaded int64 // 已下载的数据量
+	conns      int32 // 使用中的连接数
+	failures   int   // 连续请求失败的次数
+	slow       int   // 连续速度过慢的次数
+	dropped    bool  // 已停用
+}
+
+// MirrorStat 镜像的统计数据
+type MirrorStat struct {
+	URL        string
+	Host       string
+	Speed      int64 // 速度
+	Downloaded int64 // 已下载的数据量
+	Failures   int   // 连续请求失败的次数
+	Dropped    bool  // 已停用
+}
+
+func (ms MirrorStat) String() string {
+	state := ""
+	if ms.Dropped {
+		state = ", 已停用"
+	}
+	return fmt.Sprintf("%s: 已下载 %s, %s/s%s", ms.Host, pcsutil.ConvertFileSize(ms.Downloaded, 2), pcsutil.ConvertFileSize(ms.Speed, 2), state)
+}
+
+// mirrorSet 镜像列表, 各线程分散到各镜像下载
+type mirrorSet struct {
+	list []*mirror
+	mu   sync.Mutex
+}
+
+// newMirrorSet 创建镜像列表, 忽略空的和重复的链接
+func newMirrorSet(urls []string) *mirrorSet {
+	ms := &mirrorSet{
+		list: make([]*mirror, 0, len(urls)),
+	}
+
+	seen := make(map[string]bool, len(urls))
+	for _, u := range urls {
+		if u == "" || seen[u] {
+			continue
+		}
+		seen[u] = true
+
+		m := &mirror{
+			url:  u,
+			host: u,
+		}
+		if pu, err := url.Parse(u); err == nil && pu.Host != "" {
+			m.host = pu.Host
+		}
+		ms.list = append(ms.list, m)
+	}
+	return ms
+}
+
+// healthy 返回未停用的镜像数, 需加锁
+func (ms *mirrorSet) healthy() (n int) {
+	for _, m := range ms.list {
+		if !m.dropped {
+			n++
+		}
+	}
+	return
+}
+
+// pick 选择使用中的连接数最少的镜像, 连接数相同时选择较快的,
+// 返回的镜像需调用 release 归还
+func (ms *mirrorSet) pick() *mirror {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var best *mirror
+	for _, m := range ms.list {
+		if m.dropped {
+			continue
+		}
+		if best == nil {
+			best = m
+			continue
+		}
+
+		conns, bestConns := atomic.LoadInt32(&m.conns), atomic.LoadInt32(&best.conns)
+		if conns < bestConns || (conns == bestConns && atomic.LoadInt64(&m.speed) > atomic.LoadInt64(&best.speed)) {
+			best = m
+		}
+	}
+
+	if best != nil {
+		atomic.AddInt32(&best.conns, 1)
+	}
+	return best
+}
+
+// release 归还镜像
+func (ms *mirrorSet) release(m *mirror) {
+	atomic.AddInt32(&m.conns, -1)
+}
+
+// succeed 记录镜像请求成功
+func (ms *mirrorSet) succeed(m *mirror) {
+	ms.mu.Lock()
+	m.failures = 0
+	ms.mu.Unlock()
+}
+
+// fail 记录镜像请求失败, 连续失败过多时停用该镜像, 至少保留一个镜像.
+// 返回是否停用了该镜像, 以及是否还有其他可用的镜像
+func (ms *mirrorSet) fail(m *mirror) (dropped, others bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	m.failures++
+	healthy := ms.healthy()
+	if !m.dropped && m.failures >= MaxMirrorFailures && healthy > 1 {
+		m.dropped = true
+		return true, true
+	}
+	if m.dropped {
+		return false, healthy > 0
+	}
+	return false, healthy > 1
+}
+
+// check 统计各镜像的速度, 停用持续过慢的镜像, 至少保留一个镜像.
+// 返回被停用的镜像
+func (ms *mirrorSet) check() (dropped []*mirror) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	// 单连接的速度
+	var (
+		perConn = make([]int64, len(ms.list))
+		fastest int64
+	)
+	for k, m := range ms.list {
+		speed := m.speedsStat.GetSpeedsPerSecond()
+		atomic.StoreInt64(&m.speed, speed)
+		if conns := int64(atomic.LoadInt32(&m.conns)); conns > 0 {
+			perConn[k] = speed / conns
+		}
+		if perConn[k] > fastest {
+			fastest = perConn[k]
+		}
+	}
+
+	if len(ms.list) <= 1 || fastest == 0 {
+		return nil
+	}
+
+	for k, m := range ms.list {
+		if m.dropped || atomic.LoadInt32(&m.conns) == 0 {
+			continue
+		}
+
+		if perConn[k] >= fastest/10 {
+			m.slow = 0
+			continue
+		}
+
+		m.slow++
+		if m.slow >= SlowMirrorChecks && ms.healthy() > 1 {
+			m.dropped = true
+			dropped = append(dropped, m)
+		}
+	}
+	return dropped
+}
+
+// stats 返回各镜像的统计数据
+func (ms *mirrorSet) stats() []MirrorStat {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	stats := make([]MirrorStat, len(ms.list))
+	for k, m := range ms.list {
+		stats[k] = MirrorStat{
+			URL:        m.url,
+			Host:       m.host,
+			Speed:      atomic.LoadInt64(&m.speed),
+			Downloaded: atomic.LoadInt64(&m.downloaded),
+			Failures:   m.failures,
+			Dropped:    m.dropped,
+		}
+	}
+	return stats
+}
+
+// MirrorStats 返回各镜像的统计数据, 第一个为 URL
+func (der *Downloader) MirrorStats() []MirrorStat {
+	if der.mirrors == nil {
+		return nil
+	}
+	return der.mirrors.stats()
+}
+
+// mirrorFailed 记录镜像请求失败, 连续失败过多时停用该镜像,
+// 返回线程的 code, 还有其他可用的镜像时, 不休息, 立即换镜像重试
+func (der *Downloader) mirrorFailed(m *mirror, err error) (code int) {
+	dropped, others := der.mirrors.fail(m)
+	if dropped {
+		der.dropMirror(m, err.Error())
+	}
+	if others {
+		return 61
+	}
+	return 2
+}
+
+// dropMirror 停用镜像, 关闭该镜像的连接, 线程会重新选择镜像
+func (der *Downloader) dropMirror(m *mirror, reason string) {
+	verbosef("MIRROR: dropped %s, %s\n", m.host, reason)
+	for _, block := range der.status.BlockList {
+		if block != nil {
+			block.closeConn(m)
+		}
+	}
+}
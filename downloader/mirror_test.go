@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newMirrorServers 启动 n 个提供相同数据的本地服务器, 模拟多个下载服务器,
+// 返回各服务器和各服务器收到的请求数
+func newMirrorServers(data []byte, rate, n int) ([]*httptest.Server, []int64) {
+	var (
+		servers  = make([]*httptest.Server, n)
+		requests = make([]int64, n)
+	)
+	for k := range servers {
+		counter := &requests[k]
+		servers[k] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(counter, 1)
+			http.ServeContent(w, r, "data", time.Time{}, &throttledReadSeeker{
+				ReadSeeker: bytes.NewReader(data),
+				rate:       rate,
+			})
+		}))
+	}
+	return servers, requests
+}
+
+func TestMirrors(t *testing.T) {
+	data := make([]byte, 8*1024*1024)
+	rand.Read(data)
+
+	servers, requests := newMirrorServers(data, 1024*1024, 2)
+	for _, ts := range servers {
+		defer ts.Close()
+	}
+
+	// 总是失败的镜像
+	var badRequests int64
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&badRequests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer bad.Close()
+
+	dir, err := ioutil.TempDir("", "downloader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := NewConfig()
+	cfg.SavePath = filepath.Join(dir, "data")
+	cfg.Parallel = 6
+	cfg.FixedParallel = true
+	cfg.Mirrors = []string{servers[1].URL, bad.URL, servers[1].URL}
+
+	der, err := NewDownloader(servers[0].URL, *cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := der.Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	saved, err := ioutil.ReadFile(cfg.SavePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Fatal("data mismatch")
+	}
+
+	stats := der.MirrorStats()
+	if len(stats) != 3 {
+		t.Fatalf("got %d mirrors, want 3", len(stats))
+	}
+	if stats[0].Downloaded == 0 || stats[1].Downloaded == 0 {
+		t.Fatalf("ranges not spread across mirrors: %v", stats)
+	}
+	if !stats[2].Dropped || stats[2].Downloaded != 0 {
+		t.Fatalf("bad mirror not dropped: %v", stats[2])
+	}
+	// 停用前已发出的请求, 最多每个连接一个
+	if n := atomic.LoadInt64(&badRequests); n > int64(MaxMirrorFailures+cfg.Parallel) {
+		t.Fatalf("bad mirror requested %d times", n)
+	}
+	if stats[0].Downloaded+stats[1].Downloaded != int64(len(data)) {
+		t.Fatalf("downloaded %d, want %d", stats[0].Downloaded+stats[1].Downloaded, len(data))
+	}
+	t.Logf("requests: %v, stats: %v", requests, stats)
+}
@@ -2,6 +2,7 @@ package downloader
 
 import (
 	"os"
+	"sort"
 	"sync/atomic"
 	"time"
 )
@@ -34,12 +35,22 @@ func (der *Downloader) blockMonitor() <-chan struct{} {
 			}
 
 			// 获取下载速度
-			speeds := der.status.StatusStat.speedsStat.GetSpeedsPerSecond()
+			speeds := der.status.speedsStat.GetSpeedsPerSecond()
 			atomic.StoreInt64(&der.status.StatusStat.Speeds, speeds)
 			if speeds > atomic.LoadInt64(&der.status.StatusStat.maxSpeeds) {
 				atomic.StoreInt64(&der.status.StatusStat.maxSpeeds, speeds)
 			}
 
+			// 调整连接数, 关闭超出限制的连接
+			if excess := der.conns.adjust(speeds); excess > 0 {
+				der.shedConnections(excess)
+			}
+
+			// 统计各镜像的速度, 停用过慢的镜像
+			for _, m := range der.mirrors.check() {
+				der.dropMirror(m, "too slow")
+			}
+
 			// 统计各线程的速度
 			go func() {
 				for k := range der.status.BlockList {
@@ -56,15 +67,14 @@ func (der *Downloader) blockMonitor() <-chan struct{} {
 				atomic.StoreInt64(&der.status.StatusStat.maxSpeeds, 0)
 				for k := range der.status.BlockList {
 					go func(k int) {
-						// 重设长时间无响应, 和下载速度为 0 的线程, 忽略正在写入数据到硬盘的
+						// 重设长时间无响应, 和下载速度为 0 的线程
 						// 过滤速度有变化的线程
-						if der.status.BlockList[k].waitToWrite || atomic.LoadInt64(&der.status.BlockList[k].speed) != 0 {
+						if atomic.LoadInt64(&der.status.BlockList[k].speed) != 0 {
 							return
 						}
 
 						// 重设连接
-						if r := der.status.BlockList[k].resp; r != nil {
-							r.Body.Close()
+						if der.status.BlockList[k].closeConn(nil) {
 							verbosef("MONITER: thread reload, thread id: %d\n", k)
 						}
 
@@ -112,3 +122,27 @@ func (der *Downloader) blockMonitor() <-chan struct{} {
 	}()
 	return c
 }
+
+// shedConnections 关闭 n 个速度最慢的连接,
+// 被关闭的线程会重新等待空闲的连接
+func (der *Downloader) shedConnections(n int) {
+	running := make(BlockList, 0, len(der.status.BlockList))
+	for _, block := range der.status.BlockList {
+		if atomic.LoadInt32(&block.running) > 0 && block.hasConn() && !block.isDone() {
+			running = append(running, block)
+		}
+	}
+
+	sort.Slice(running, func(i, j int) bool {
+		return atomic.LoadInt64(&running[i].speed) < atomic.LoadInt64(&running[j].speed)
+	})
+
+	if n > len(running) {
+		n = len(running)
+	}
+
+	for _, block := range running[:n] {
+		block.closeConn(nil)
+	}
+	verbosef("CONTROLLER: closed %d connection(s) over the limit\n", n)
+}
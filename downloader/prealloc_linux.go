@@ -0,0 +1,16 @@
+package downloader
+
+import (
+	"os"
+	"syscall"
+)
+
+// preallocate 为文件预分配硬盘空间, 减少磁盘碎片,
+// 文件系统不支持 fallocate 时, 改为调整文件大小
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), 0, 0, size)
+	if err == nil {
+		return nil
+	}
+	return file.Truncate(size)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package downloader
+
+import (
+	"os"
+)
+
+// preallocate 为文件预分配硬盘空间, 调整文件大小
+func preallocate(file *os.File, size int64) error {
+	return file.Truncate(size)
+}
@@ -14,22 +14,28 @@ type SpeedsStat struct {
 	timeElapsed time.Duration
 	nowTime     time.Time
 	once        sync.Once
+	mu          sync.Mutex // 多个 goroutine 可能同时统计速度
 }
 
 // AddReaded 原子操作, 增加数据量
 func (sps *SpeedsStat) AddReaded(readed int64) {
-	// 初始化
-	sps.once.Do(func() {
-		if sps.nowTime.Unix() == 0 {
-			sps.nowTime = time.Now()
-		}
-	})
-
+	sps.once.Do(sps.init)
 	atomic.AddInt64(&sps.readed, readed)
 }
 
+// init 初始化
+func (sps *SpeedsStat) init() {
+	if sps.nowTime.Unix() == 0 {
+		sps.nowTime = time.Now()
+	}
+}
+
 // GetSpeedsPerSecond 结束统计速度, 并返回每秒的速度
 func (sps *SpeedsStat) GetSpeedsPerSecond() (speeds int64) {
+	sps.mu.Lock()
+	defer sps.mu.Unlock()
+	sps.once.Do(sps.init)
+
 	int64Ptr := (*int64)(unsafe.Pointer(&sps.timeElapsed))
 	atomic.StoreInt64(int64Ptr, (int64)(time.Since(sps.nowTime)))
 	if atomic.LoadInt64(int64Ptr) == 0 {
@@ -5,6 +5,7 @@ import (
 	"github.com/json-iterator/go"
 	"io/ioutil"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,20 +16,30 @@ type StatusStat struct {
 	Speeds      int64         `json:"-"`          // 下载速度
 	maxSpeeds   int64         // 最大下载速度
 	TimeElapsed time.Duration `json:"-"` // 下载的时间
-	speedsStat  SpeedsStat
 }
 
 // Status 下载状态
 type Status struct {
 	StatusStat
 
+	speedsStat SpeedsStat     // 总下载速度统计
 	singleResp *http.Response // 单线程下载的http响应
 
 	file           Writer
 	BlockList      BlockList `json:"block_list"` // 下载区块列表
 	blockUnsupport bool      // 服务端是否支持断点续传
 	paused         bool      // 是否暂停
-	done           bool      // 是否已经结束
+	done           int32     // 是否已经结束, 原子操作, 使用 setDone 和 isDone
+}
+
+// setDone 设置下载已结束
+func (s *Status) setDone() {
+	atomic.StoreInt32(&s.done, 1)
+}
+
+// isDone 下载是否已结束
+func (s *Status) isDone() bool {
+	return atomic.LoadInt32(&s.done) == 1
 }
 
 // GetStatusChan 返回 Status 对象的 channel
@@ -42,11 +53,11 @@ func (der *Downloader) GetStatusChan() <-chan StatusStat {
 
 			// 针对单线程下载的速度统计
 			if der.status.blockUnsupport {
-				der.status.StatusStat.Speeds = der.status.StatusStat.speedsStat.GetSpeedsPerSecond()
+				der.status.StatusStat.Speeds = der.status.speedsStat.GetSpeedsPerSecond()
 			}
 
 			// 下载结束, 关闭 chan
-			if der.status.done {
+			if der.status.isDone() {
 				close(c)
 				return
 			}
@@ -68,14 +79,43 @@ func (der *Downloader) recordBreakPoint() error {
 		return errors.New("服务端不支持断点续传, 不记录断点信息")
 	}
 
-	byt, err := jsoniter.Marshal(&der.status)
+	byt, err := jsoniter.Marshal(der.breakPoint())
 	if err != nil {
 		return err
 	}
 
+	// 断点信息中已下载的数据, 需确保已写入文件
+	if der.writer != nil {
+		if err = der.writer.flush(); err != nil {
+			return err
+		}
+	}
+
 	return ioutil.WriteFile(der.Config.SavePath+DownloadingFileSuffix, byt, 0644)
 }
 
+// breakPoint 返回用于保存的下载状态, 各线程仍在下载, 使用原子操作读取
+func (der *Downloader) breakPoint() *Status {
+	der.monitorMu.Lock()
+	defer der.monitorMu.Unlock()
+
+	bp := &Status{
+		StatusStat: StatusStat{
+			TotalSize:  der.status.StatusStat.TotalSize,
+			Downloaded: atomic.LoadInt64(&der.status.StatusStat.Downloaded),
+		},
+		BlockList: make(BlockList, len(der.status.BlockList)),
+	}
+	for k, block := range der.status.BlockList {
+		bp.BlockList[k] = &Block{
+			Begin:   atomic.LoadInt64(&block.Begin),
+			End:     atomic.LoadInt64(&block.End),
+			IsFinal: block.IsFinal,
+		}
+	}
+	return bp
+}
+
 // loadBreakPoint 尝试从文件载入下载断点
 func (der *Downloader) loadBreakPoint() error {
 	if der.Config.Testing {
@@ -39,3 +39,19 @@ func verbosef(format string, a ...interface{}) {
 		fmt.Printf(format, a...)
 	}
 }
+
+// header 合并附加的请求头 Config.Header 和 h, h 优先
+func (der *Downloader) header(h map[string]string) map[string]string {
+	if len(der.Config.Header) == 0 {
+		return h
+	}
+
+	merged := make(map[string]string, len(der.Config.Header)+len(h))
+	for k, v := range der.Config.Header {
+		merged[k] = v
+	}
+	for k, v := range h {
+		merged[k] = v
+	}
+	return merged
+}
@@ -1,7 +1,23 @@
 package downloader
 
 import (
+	"errors"
+	"github.com/iikira/BaiduPCS-Go/downloader/cachepool"
 	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// MaxCoalesceSize 合并写入的最大数据量
+	MaxCoalesceSize = 4 * 1024 * 1024
+
+	// WriteQueueSize 写入队列的长度
+	WriteQueueSize = 256
+
+	// errWriterClosed 写入已结束
+	errWriterClosed = errors.New("writer closed")
 )
 
 // Writer 接口
@@ -9,3 +25,247 @@ type Writer interface {
 	io.WriteCloser
 	io.WriterAt
 }
+
+// syncer 支持同步到硬盘的 Writer, 如 *os.File
+type syncer interface {
+	Sync() error
+}
+
+// writeMsg 写入请求, 写入 buf 到 offset 处
+type writeMsg struct {
+	offset int64
+	buf    []byte
+}
+
+// blockWriter 各下载线程写入数据的方式
+type blockWriter interface {
+	write(offset int64, buf []byte) error // buf 的所有权转交给 blockWriter
+	flush() error
+	close() error
+}
+
+// newBlockWriter 创建下载使用的 blockWriter, 测试时可替换
+var newBlockWriter = func(w io.WriterAt, owner *cachepool.Owner, syncInterval time.Duration, onError func(err error)) blockWriter {
+	return newAsyncWriter(w, owner, syncInterval, onError)
+}
+
+// asyncWriter 独立的写入线程, 各下载线程将数据提交到写入队列后,
+// 即可继续下载, 不必等待硬盘.
+// 相邻的数据合并为一次写入, 写入后将缓存归还到缓存池
+type asyncWriter struct {
+	w            io.WriterAt
+	owner        *cachepool.Owner // 写入的缓存所属
+	msgs         chan writeMsg
+	syncInterval time.Duration // 定期同步到硬盘的间隔, 0 表示不同步
+	onError      func(err error)
+	scratch      []byte // 合并写入的缓存
+
+	queued  int64 // 已提交的写入请求数
+	written int64 // 已完成的写入请求数
+	err     error
+	closed  bool
+	closeMu sync.RWMutex // 保证关闭后不再提交写入请求
+	mu      sync.Mutex
+	cond    *sync.Cond
+	done    chan struct{}
+
+	writes    int64 // 实际的写入次数
+	coalesced int64 // 被合并的写入请求数
+}
+
+// newAsyncWriter 创建写入线程, syncInterval 大于 0 时, 定期将数据同步到硬盘,
+// 写入出错时调用 onError
+func newAsyncWriter(w io.WriterAt, owner *cachepool.Owner, syncInterval time.Duration, onError func(err error)) *asyncWriter {
+	aw := &asyncWriter{
+		w:            w,
+		owner:        owner,
+		msgs:         make(chan writeMsg, WriteQueueSize),
+		syncInterval: syncInterval,
+		onError:      onError,
+		done:         make(chan struct{}),
+	}
+	aw.cond = sync.NewCond(&aw.mu)
+	go aw.run()
+	return aw
+}
+
+// write 提交写入请求, buf 的所有权转交给写入线程, 调用后不可再使用.
+// 之前的写入已出错时, 返回该错误
+func (aw *asyncWriter) write(offset int64, buf []byte) error {
+	aw.closeMu.RLock()
+	defer aw.closeMu.RUnlock()
+
+	aw.mu.Lock()
+	if aw.err != nil || aw.closed {
+		err := aw.err
+		if err == nil {
+			err = errWriterClosed
+		}
+		aw.mu.Unlock()
+		aw.owner.Put(buf)
+		return err
+	}
+	aw.queued++
+	aw.mu.Unlock()
+
+	aw.msgs <- writeMsg{
+		offset: offset,
+		buf:    buf,
+	}
+	return nil
+}
+
+// flush 等待已提交的写入请求全部完成
+func (aw *asyncWriter) flush() error {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+
+	target := aw.queued
+	for aw.written < target && aw.err == nil {
+		aw.cond.Wait()
+	}
+	return aw.err
+}
+
+// close 等待剩余的数据写入完成, 结束写入线程
+func (aw *asyncWriter) close() error {
+	aw.closeMu.Lock()
+	aw.mu.Lock()
+	closed := aw.closed
+	aw.closed = true
+	aw.mu.Unlock()
+	if !closed {
+		close(aw.msgs)
+	}
+	aw.closeMu.Unlock()
+
+	<-aw.done
+	if closed {
+		return aw.getErr()
+	}
+
+	aw.sync()
+	verbosef("WRITER: writes: %d, coalesced: %d\n", aw.writes, aw.coalesced)
+	return aw.getErr()
+}
+
+func (aw *asyncWriter) getErr() error {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	return aw.err
+}
+
+func (aw *asyncWriter) setErr(err error) {
+	aw.mu.Lock()
+	first := aw.err == nil
+	if first {
+		aw.err = err
+	}
+	aw.mu.Unlock()
+	aw.cond.Broadcast()
+
+	if first && aw.onError != nil {
+		aw.onError(err)
+	}
+}
+
+func (aw *asyncWriter) sync() {
+	if s, ok := aw.w.(syncer); ok {
+		if err := s.Sync(); err != nil {
+			verbosef("WRITER: sync error, %s\n", err)
+		}
+	}
+}
+
+func (aw *asyncWriter) run() {
+	defer close(aw.done)
+
+	var tick <-chan time.Time
+	if aw.syncInterval > 0 {
+		ticker := time.NewTicker(aw.syncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	var (
+		batch    = make([]writeMsg, 0, WriteQueueSize)
+		modified bool // 上次同步后是否有写入
+	)
+	for {
+		select {
+		case msg, ok := <-aw.msgs:
+			if !ok {
+				return
+			}
+
+			// 取出队列中所有等待的请求, 一起处理
+			batch = append(batch[:0], msg)
+		drain:
+			for len(batch) < cap(batch) {
+				select {
+				case msg, ok = <-aw.msgs:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, msg)
+				default:
+					break drain
+				}
+			}
+
+			aw.writeBatch(batch)
+			modified = true
+
+			aw.mu.Lock()
+			aw.written += int64(len(batch))
+			aw.mu.Unlock()
+			aw.cond.Broadcast()
+		case <-tick:
+			if modified {
+				aw.sync()
+				modified = false
+			}
+		}
+	}
+}
+
+// writeBatch 按 offset 排序, 合并相邻的数据后写入
+func (aw *asyncWriter) writeBatch(batch []writeMsg) {
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].offset < batch[j].offset
+	})
+
+	for i := 0; i < len(batch); {
+		// 找出从 i 开始相邻的数据 [i, j)
+		j, size := i+1, len(batch[i].buf)
+		for j < len(batch) && batch[j].offset == batch[i].offset+int64(size) && size+len(batch[j].buf) <= MaxCoalesceSize {
+			size += len(batch[j].buf)
+			j++
+		}
+
+		data := batch[i].buf
+		if j-i > 1 {
+			if cap(aw.scratch) < size {
+				aw.scratch = make([]byte, 0, MaxCoalesceSize)
+			}
+			data = aw.scratch[:0]
+			for k := i; k < j; k++ {
+				data = append(data, batch[k].buf...)
+			}
+			aw.coalesced += int64(j - i - 1)
+		}
+
+		if aw.getErr() == nil {
+			if _, err := aw.w.WriteAt(data, batch[i].offset); err != nil {
+				aw.setErr(err)
+			}
+			aw.writes++
+		}
+
+		for k := i; k < j; k++ {
+			aw.owner.Put(batch[k].buf)
+			batch[k].buf = nil
+		}
+		i = j
+	}
+}
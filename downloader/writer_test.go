@@ -0,0 +1,238 @@
+package downloader
+
+import (
+	"bytes"
+	"github.com/iikira/BaiduPCS-Go/downloader/cachepool"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowWriterAt 模拟较慢的硬盘, 每次写入都有固定的延迟
+type slowWriterAt struct {
+	data    []byte
+	latency time.Duration
+	calls   int
+	mu      sync.Mutex
+}
+
+func (sw *slowWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	time.Sleep(sw.latency)
+	sw.calls++
+	return copy(sw.data[off:], p), nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	const (
+		chunk  = 4096
+		chunks = 64
+	)
+
+	var (
+		src   = make([]byte, chunk*chunks)
+		dst   = &slowWriterAt{data: make([]byte, chunk*chunks), latency: time.Millisecond}
+		owner = cachepool.NewPool(0).NewOwner()
+		aw    = newAsyncWriter(dst, owner, 0, nil)
+	)
+	rand.Read(src)
+
+	// 乱序提交
+	for _, k := range rand.Perm(chunks) {
+		buf := owner.Get(chunk)
+		copy(buf, src[k*chunk:])
+		if err := aw.write(int64(k*chunk), buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := aw.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(src, dst.data) {
+		t.Fatal("data mismatch")
+	}
+	if dst.calls >= chunks || aw.coalesced == 0 {
+		t.Fatalf("not coalesced, writes: %d", dst.calls)
+	}
+	if aw.write(0, owner.Get(chunk)) != errWriterClosed {
+		t.Fatal("write after close")
+	}
+}
+
+// benchmarkWrite 模拟 parallel 个线程, 各自连续写入 chunk 大小的数据
+func benchmarkWrite(b *testing.B, owner *cachepool.Owner, write func(offset int64, buf []byte)) {
+	b.SetBytes(benchParallel * benchChunk)
+
+	var wg sync.WaitGroup
+	wg.Add(benchParallel)
+	for id := 0; id < benchParallel; id++ {
+		go func(id int) {
+			defer wg.Done()
+			begin := int64(id * b.N * benchChunk)
+			for i := 0; i < b.N; i++ {
+				write(begin+int64(i*benchChunk), owner.Get(benchChunk))
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+const (
+	benchParallel = 64
+	benchChunk    = 32 * 1024
+)
+
+func BenchmarkWriteMutex(b *testing.B) {
+	var (
+		dst   = &slowWriterAt{data: make([]byte, benchParallel*benchChunk*b.N), latency: 200 * time.Microsecond}
+		owner = cachepool.NewPool(0).NewOwner()
+		mu    sync.Mutex
+	)
+
+	benchmarkWrite(b, owner, func(offset int64, buf []byte) {
+		mu.Lock()
+		dst.WriteAt(buf, offset)
+		mu.Unlock()
+		owner.Put(buf)
+	})
+}
+
+func BenchmarkWriteAsync(b *testing.B) {
+	var (
+		dst   = &slowWriterAt{data: make([]byte, benchParallel*benchChunk*b.N), latency: 200 * time.Microsecond}
+		owner = cachepool.NewPool(0).NewOwner()
+		aw    = newAsyncWriter(dst, owner, 0, nil)
+	)
+
+	benchmarkWrite(b, owner, func(offset int64, buf []byte) {
+		aw.write(offset, buf)
+	})
+	aw.close()
+}
+
+// throttledReadSeeker 限制每个连接的速度
+type throttledReadSeeker struct {
+	io.ReadSeeker
+	rate int // 字节每秒
+}
+
+func (tr *throttledReadSeeker) Read(p []byte) (int, error) {
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err := tr.ReadSeeker.Read(p)
+	time.Sleep(time.Duration(n) * time.Second / time.Duration(tr.rate))
+	return n, err
+}
+
+// newThrottledServer 返回本地的 http 服务器, 支持 Range 请求, 每个连接限速 rate 字节每秒
+func newThrottledServer(data []byte, rate int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data", time.Time{}, &throttledReadSeeker{
+			ReadSeeker: bytes.NewReader(data),
+			rate:       rate,
+		})
+	}))
+}
+
+// latencyWriterAt 模拟较慢的硬盘, 每次写入都有固定的延迟, 可并发写入
+type latencyWriterAt struct {
+	io.WriterAt
+	latency time.Duration
+}
+
+func (lw *latencyWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	time.Sleep(lw.latency)
+	return lw.WriterAt.WriteAt(p, off)
+}
+
+// mutexWriter 原来的写入方式, 各下载线程加锁后直接写入
+type mutexWriter struct {
+	w     io.WriterAt
+	owner *cachepool.Owner
+	mu    sync.Mutex
+}
+
+func (mw *mutexWriter) write(offset int64, buf []byte) error {
+	mw.mu.Lock()
+	_, err := mw.w.WriteAt(buf, offset)
+	mw.mu.Unlock()
+	mw.owner.Put(buf)
+	return err
+}
+
+func (mw *mutexWriter) flush() error { return nil }
+
+func (mw *mutexWriter) close() error { return nil }
+
+// benchmarkDownload 从本地限速的 http 服务器下载文件, 每次写入硬盘有 1ms 的延迟,
+// newWriter 为下载使用的写入方式
+func benchmarkDownload(b *testing.B, newWriter func(w io.WriterAt, owner *cachepool.Owner, syncInterval time.Duration, onError func(err error)) blockWriter) {
+	data := make([]byte, 64*1024*1024)
+	rand.Read(data)
+
+	ts := newThrottledServer(data, 8*1024*1024)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "downloader")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(orig func(w io.WriterAt, owner *cachepool.Owner, syncInterval time.Duration, onError func(err error)) blockWriter) {
+		newBlockWriter = orig
+	}(newBlockWriter)
+	newBlockWriter = func(w io.WriterAt, owner *cachepool.Owner, syncInterval time.Duration, onError func(err error)) blockWriter {
+		return newWriter(&latencyWriterAt{WriterAt: w, latency: time.Millisecond}, owner, syncInterval, onError)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cfg := NewConfig()
+		cfg.SavePath = filepath.Join(dir, strconv.Itoa(i))
+		cfg.Parallel = 16
+		cfg.FixedParallel = true
+		cfg.CacheSize = 64 * 1024
+
+		der, err := NewDownloader(ts.URL, *cfg)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		done, err := der.Execute()
+		if err != nil {
+			b.Fatal(err)
+		}
+		<-done
+	}
+}
+
+// BenchmarkDownloadMutex 对照: 原来的加锁写入
+func BenchmarkDownloadMutex(b *testing.B) {
+	benchmarkDownload(b, func(w io.WriterAt, owner *cachepool.Owner, _ time.Duration, _ func(err error)) blockWriter {
+		return &mutexWriter{w: w, owner: owner}
+	})
+}
+
+// BenchmarkDownloadAsync 使用写入线程
+func BenchmarkDownloadAsync(b *testing.B) {
+	benchmarkDownload(b, func(w io.WriterAt, owner *cachepool.Owner, syncInterval time.Duration, onError func(err error)) blockWriter {
+		return newAsyncWriter(w, owner, syncInterval, onError)
+	})
+}
@@ -0,0 +1,633 @@
+package pcscommand
+
+import (
+	"container/list"
+	"encoding/hex"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcspath"
+	"github.com/iikira/BaiduPCS-Go/pcstable"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/json-iterator/go"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// backupManifestFile 快照的清单文件, 保存在快照目录中
+	backupManifestFile = ".pcsbackup.json"
+
+	// backupTimeFormat 快照目录名的时间格式, 本地时间
+	backupTimeFormat = "20060102-150405"
+
+	// backupLatest 表示最新的完整快照
+	backupLatest = "latest"
+
+	// backupMaxManifestSize 清单文件的最大大小
+	backupMaxManifestSize = 256 * pcsutil.MB
+)
+
+// BackupOptions 备份可选参数
+type BackupOptions struct {
+	UploadOptions // 遍历本地目录的规则, 计算摘要值的选项, 进度的输出方式
+	BackupRetention
+
+	Root string // 备份根目录, 快照保存在 <Root>/<Name>/<时间>/
+	Name string // 备份名称, 默认为本地目录名
+}
+
+// BackupRetention 快照的保留策略, 每项保留最近 N 个日, 周, 月中各自最新的快照, 最新的快照总是保留
+type BackupRetention struct {
+	KeepDaily   int
+	KeepWeekly  int
+	KeepMonthly int
+}
+
+// isSet 是否设置了保留策略
+func (br *BackupRetention) isSet() bool {
+	return br.KeepDaily > 0 || br.KeepWeekly > 0 || br.KeepMonthly > 0
+}
+
+// backupManifest 快照的清单
+type backupManifest struct {
+	Version   int                `json:"version"`
+	Name      string             `json:"name"`
+	Snapshot  string             `json:"snapshot"`
+	Source    string             `json:"source"` // 本地目录
+	Host      string             `json:"host"`
+	Time      int64              `json:"time"`
+	TotalSize int64              `json:"total_size"`
+	Failed    int                `json:"failed"` // 上传失败的文件数, 不在 Files 中
+	Files     []*backupFileEntry `json:"files"`
+	EmptyDirs []string           `json:"empty_dirs,omitempty"`
+}
+
+// backupFileEntry 清单中的文件, 路径相对于快照目录, 以 / 分隔
+type backupFileEntry struct {
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Mtime int64  `json:"mtime"`
+	MD5   string `json:"md5,omitempty"`
+}
+
+// backupSnapshot 网盘中的快照
+type backupSnapshot struct {
+	name string    // 快照目录名
+	path string    // 快照目录的网盘路径
+	time time.Time // 快照的时间
+
+	complete bool // 清单可读取且没有备份失败的文件
+}
+
+// backupRootPath 返回备份根目录的网盘绝对路径
+func backupRootPath(root string) string {
+	if root == "" {
+		root = "/backup"
+	}
+	return pcspath.NewPCSPath(&pcsconfig.Config.MustGetActive().Workdir, root).AbsPathNoMatch()
+}
+
+// checkBackupName 检查备份名称, 名称为备份根目录下的一层目录
+func checkBackupName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("备份名称不合法: %s", name)
+	}
+	return nil
+}
+
+// RunBackup 将本地目录 localDir 备份为新的快照, 上传到 <备份根目录>/<名称>/<时间>/,
+// 先检测秒传, 未改变的文件不需重新上传, 完成后上传清单, 设置了保留策略时删除过期的快照
+func RunBackup(localDir string, options *BackupOptions) {
+	if options == nil {
+		options = &BackupOptions{}
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	localDir, err = filepath.Abs(localDir)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	name := options.Name
+	if name == "" {
+		name = filepath.Base(localDir)
+	}
+	err = checkBackupName(name)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	walker, err := newUploadWalker(&options.UploadOptions)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	local, err := walkSyncLocal(localDir, walker, progress)
+	if err != nil {
+		progress.printf("遍历本地目录失败, %s\n", err)
+		return
+	}
+
+	var (
+		root     = backupRootPath(options.Root)
+		now      = time.Now()
+		snapshot = now.Format(backupTimeFormat)
+		snapDir  = path.Join(root, name, snapshot)
+	)
+	if _, err = info.FilesDirectoriesMeta(snapDir); err == nil {
+		progress.printf("快照 %s 已存在, 请稍后重试\n", snapDir)
+		return
+	}
+
+	files := make([]string, 0, len(local.files))
+	for rel := range local.files {
+		if rel == backupManifestFile {
+			progress.printf("跳过 %s, 与快照的清单文件同名\n", rel)
+			continue
+		}
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+
+	var (
+		ulist     = list.New()
+		emptyDirs = make([]string, 0, len(local.emptyDirs))
+	)
+	for k, rel := range files {
+		ulist.PushBack(&utask{
+			ListTask: ListTask{
+				ID:       k + 1,
+				MaxRetry: 3,
+			},
+			uploadInfo: &LocalPathInfo{
+				Path: local.files[rel].path,
+			},
+			savePath: path.Join(snapDir, rel),
+		})
+	}
+	for _, rel := range local.emptyDirs {
+		emptyDirs = append(emptyDirs, path.Join(snapDir, rel))
+	}
+
+	msg := fmt.Sprintf("开始备份 %s 到 %s, 共 %d 个文件\n", localDir, snapDir, len(files))
+	progress.printf("%s", msg)
+	pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+
+	// Ctrl+C 取消, Ctrl+Z 暂停/恢复
+	control := newTaskControl(progress)
+	defer control.stop()
+
+	// 快照目录为空时也创建, 清单保存在其中
+	emptyDirs = append(emptyDirs, snapDir)
+	result := uploadTasks(ulist, emptyDirs, nil, &options.UploadOptions, progress, control)
+
+	succeeded := make(map[string]bool, len(result.succeeded))
+	for _, savePath := range result.succeeded {
+		succeeded[savePath] = true
+	}
+
+	hostname, _ := os.Hostname()
+	manifest := &backupManifest{
+		Version:   1,
+		Name:      name,
+		Snapshot:  snapshot,
+		Source:    localDir,
+		Host:      hostname,
+		Time:      now.Unix(),
+		EmptyDirs: local.emptyDirs,
+	}
+	for _, rel := range files {
+		if !succeeded[path.Join(snapDir, rel)] {
+			manifest.Failed++
+			continue
+		}
+		lf := local.files[rel]
+		mf := &backupFileEntry{
+			Path:  rel,
+			Size:  lf.size,
+			Mtime: lf.mtime,
+		}
+		// 上传时已计算, 从摘要值缓存中读取
+		if lp, err := GetFileSum(lf.path, &SumOption{IsMD5Sum: true}); err == nil {
+			mf.MD5 = hex.EncodeToString(lp.MD5)
+		}
+		manifest.Files = append(manifest.Files, mf)
+		manifest.TotalSize += lf.size
+	}
+
+	data, err := jsoniter.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = uploadBytes(info, path.Join(snapDir, backupManifestFile), data)
+	}
+	if err != nil {
+		msg = fmt.Sprintf("上传快照 %s 的清单失败, %s, 该快照不完整\n", snapDir, err)
+		progress.printf("%s", msg)
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+		return
+	}
+
+	msg = fmt.Sprintf("\n备份完成: %s, 文件 %d, 失败 %d, 总大小: %s\n", snapDir, len(manifest.Files), manifest.Failed, pcsutil.ConvertFileSize(manifest.TotalSize))
+	progress.printf("%s", msg)
+	pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+
+	if !options.isSet() {
+		return
+	}
+	if manifest.Failed > 0 || control.aborted() {
+		progress.printf("有文件备份失败, 不删除过期的快照\n")
+		return
+	}
+	pruneBackup(root, name, &options.BackupRetention, false, progress)
+}
+
+// listBackupSnapshots 列出备份 name 的快照, 按时间从新到旧排列, 忽略不是快照的目录
+func listBackupSnapshots(root, name string) ([]*backupSnapshot, error) {
+	fdl, err := info.FilesDirectoriesList(path.Join(root, name), false)
+	if err != nil {
+		return nil, err
+	}
+
+	snaps := make([]*backupSnapshot, 0, len(fdl))
+	for _, fd := range fdl {
+		if !fd.Isdir {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, fd.Filename, time.Local)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, &backupSnapshot{
+			name: fd.Filename,
+			path: fd.Path,
+			time: t,
+		})
+	}
+	sort.Slice(snaps, func(i, j int) bool {
+		return snaps[i].time.After(snaps[j].time)
+	})
+	return snaps, nil
+}
+
+// loadBackupManifest 读取快照的清单
+func loadBackupManifest(snapDir string) (*backupManifest, error) {
+	data, err := downloadBytes(info, path.Join(snapDir, backupManifestFile), backupMaxManifestSize)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &backupManifest{}
+	err = jsoniter.Unmarshal(data, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Version != 1 {
+		return nil, fmt.Errorf("不支持的清单版本: %d", manifest.Version)
+	}
+	return manifest, nil
+}
+
+// RunBackupList 列出备份根目录下的备份, name 不为空时, 列出该备份的快照
+func RunBackupList(root, name string) {
+	root = backupRootPath(root)
+	if name == "" {
+		fdl, err := info.FilesDirectoriesList(root, false)
+		if err != nil {
+			fmt.Printf("获取备份根目录 %s 失败, %s\n", root, err)
+			return
+		}
+
+		tb := pcstable.NewTable(os.Stdout)
+		tb.SetHeader([]string{"#", "备份名称", "快照数", "最新快照"})
+		var n int
+		for _, fd := range fdl {
+			if !fd.Isdir {
+				continue
+			}
+			snaps, err := listBackupSnapshots(root, fd.Filename)
+			if err != nil || len(snaps) == 0 {
+				continue
+			}
+			tb.Append([]string{strconv.Itoa(n), fd.Filename, strconv.Itoa(len(snaps)), snaps[0].name})
+			n++
+		}
+		tb.Render()
+		return
+	}
+
+	snaps, err := listBackupSnapshots(root, name)
+	if err != nil {
+		fmt.Printf("获取备份 %s 的快照失败, %s\n", name, err)
+		return
+	}
+
+	tb := pcstable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "快照", "时间", "文件数", "总大小", "状态"})
+	for k, snap := range snaps {
+		var (
+			files, size string
+			status      = "完整"
+		)
+		manifest, err := loadBackupManifest(snap.path)
+		switch {
+		case err != nil:
+			status = "不完整, 无清单"
+		case manifest.Failed > 0:
+			status = fmt.Sprintf("%d 个文件失败", manifest.Failed)
+		}
+		if manifest != nil {
+			files, size = strconv.Itoa(len(manifest.Files)), pcsutil.ConvertFileSize(manifest.TotalSize)
+		}
+		tb.Append([]string{strconv.Itoa(k), snap.name, snap.time.Format("2006-01-02 15:04:05"), files, size, status})
+	}
+	tb.Render()
+}
+
+// BackupRestoreOptions 恢复快照的可选参数
+type BackupRestoreOptions struct {
+	Root     string // 备份根目录
+	To       string // 恢复到的本地目录, 默认为 ./<名称>
+	Progress string // 进度的输出方式, text, json
+}
+
+// RunBackupRestore 从快照 snapshot (<名称>/<时间>, 时间为 latest 时使用最新的完整快照) 恢复文件到本地,
+// paths 不为空时, 只恢复快照中的这些文件或目录. 本地已有大小和修改时间相同的文件时跳过, 否则覆盖
+func RunBackupRestore(snapshot string, paths []string, options *BackupRestoreOptions) {
+	if options == nil {
+		options = &BackupRestoreOptions{}
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	name, snapName := path.Split(strings.Trim(snapshot, "/"))
+	name = strings.TrimSuffix(name, "/")
+	if checkBackupName(name) != nil || snapName == "" {
+		progress.printf("快照格式错误: %s, 应为 <备份名称>/<快照>, 例如 app/20180101-120000 或 app/latest\n", snapshot)
+		return
+	}
+
+	root := backupRootPath(options.Root)
+	snapDir, manifest, err := findBackupSnapshot(root, name, snapName)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+	if manifest.Failed > 0 {
+		progress.printf("警告: 快照 %s 中有 %d 个文件备份失败, 只能恢复其余的文件\n", snapDir, manifest.Failed)
+	}
+
+	to := options.To
+	if to == "" {
+		to = name
+	}
+
+	filters := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		filters[strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")] = true
+	}
+	selected := func(rel string) bool {
+		return len(filters) == 0 || underAny(rel, filters)
+	}
+
+	var (
+		restored, skipped, failed int
+		size                      int64
+	)
+	for _, rel := range manifest.EmptyDirs {
+		if !selected(rel) {
+			continue
+		}
+		localPath, err := backupRestorePath(to, rel)
+		if err != nil {
+			progress.printf("跳过目录 %s, %s\n", rel, err)
+			continue
+		}
+		os.MkdirAll(localPath, 0777)
+	}
+	for _, mf := range manifest.Files {
+		if !selected(mf.Path) {
+			continue
+		}
+
+		localPath, err := backupRestorePath(to, mf.Path)
+		if err != nil {
+			msg := fmt.Sprintf("恢复 %s 失败, %s\n", mf.Path, err)
+			progress.printf("%s", msg)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			failed++
+			continue
+		}
+		if fi, err := os.Stat(localPath); err == nil && fi.Mode().IsRegular() && fi.Size() == mf.Size && fi.ModTime().Unix() == mf.Mtime {
+			skipped++
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(localPath), 0777)
+		if err == nil {
+			err = syncDownload(restored+failed+1, &baidupcs.FileDirectory{
+				Path:  path.Join(snapDir, mf.Path),
+				Size:  mf.Size,
+				Mtime: mf.Mtime, // 恢复备份时的修改时间
+			}, localPath, progress)
+		}
+		if err != nil {
+			msg := fmt.Sprintf("恢复 %s 失败, %s\n", mf.Path, err)
+			progress.printf("%s", msg)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			failed++
+			continue
+		}
+		restored++
+		size += mf.Size
+	}
+
+	if restored+skipped+failed == 0 && len(filters) > 0 {
+		progress.printf("快照 %s 中未找到指定的路径\n", snapDir)
+		return
+	}
+	progress.printf("\n恢复完成: %s -> %s, 恢复 %d, 已存在 %d, 失败 %d, 总大小: %s\n", snapDir, to, restored, skipped, failed, pcsutil.ConvertFileSize(size))
+}
+
+// backupRestorePath 返回清单中的路径 rel 恢复到本地目录 to 的路径,
+// 清单保存在网盘中, 可能被篡改, 拒绝绝对路径和包含 .. 的路径, 防止写入 to 以外的位置
+func backupRestorePath(to, rel string) (string, error) {
+	slashed := filepath.ToSlash(filepath.FromSlash(rel)) // windows 下 \ 也是分隔符
+	if rel == "" || path.IsAbs(slashed) || filepath.IsAbs(rel) || filepath.VolumeName(filepath.FromSlash(rel)) != "" {
+		return "", fmt.Errorf("清单中的路径无效: %s", rel)
+	}
+	for _, elem := range strings.Split(slashed, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("清单中的路径无效: %s", rel)
+		}
+	}
+
+	base, err := filepath.Abs(to)
+	if err != nil {
+		return "", err
+	}
+	prefix := base
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	localPath := filepath.Join(base, filepath.FromSlash(rel))
+	if !strings.HasPrefix(localPath, prefix) {
+		return "", fmt.Errorf("清单中的路径无效: %s", rel)
+	}
+	return localPath, nil
+}
+
+// findBackupSnapshot 查找快照, 读取其清单, snapName 为 latest 时返回最新的完整快照
+func findBackupSnapshot(root, name, snapName string) (snapDir string, manifest *backupManifest, err error) {
+	if snapName != backupLatest {
+		snapDir = path.Join(root, name, snapName)
+		manifest, err = loadBackupManifest(snapDir)
+		if err != nil {
+			return "", nil, fmt.Errorf("读取快照 %s 的清单失败, %s", snapDir, err)
+		}
+		return
+	}
+
+	snaps, err := listBackupSnapshots(root, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("获取备份 %s 的快照失败, %s", name, err)
+	}
+	for _, snap := range snaps {
+		manifest, err = loadBackupManifest(snap.path)
+		if err == nil && manifest.Failed == 0 {
+			return snap.path, manifest, nil
+		}
+	}
+	return "", nil, fmt.Errorf("备份 %s 没有完整的快照", name)
+}
+
+// RunBackupPrune 按保留策略删除备份 name 的过期快照, 删除的快照可在网盘文件回收站找回
+func RunBackupPrune(root, name string, retention *BackupRetention, dryRun bool) {
+	if !retention.isSet() {
+		fmt.Printf("请指定保留策略, 例如 --keep-daily 7 --keep-weekly 4 --keep-monthly 12\n")
+		return
+	}
+	err := checkBackupName(name)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	progress, err := newProgressOutput("text")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	pruneBackup(backupRootPath(root), name, retention, dryRun, progress)
+}
+
+func pruneBackup(root, name string, retention *BackupRetention, dryRun bool, progress *progressOutput) {
+	snaps, err := listBackupSnapshots(root, name)
+	if err != nil {
+		progress.printf("获取备份 %s 的快照失败, %s\n", name, err)
+		return
+	}
+	for _, snap := range snaps {
+		manifest, err := loadBackupManifest(snap.path)
+		snap.complete = err == nil && manifest.Failed == 0
+	}
+
+	keep := backupKeep(snaps, retention)
+	var removes []string
+	for _, snap := range snaps {
+		if reasons, ok := keep[snap.name]; ok {
+			progress.printf("[保留] %s (%s)\n", snap.name, strings.Join(reasons, ", "))
+			continue
+		}
+		progress.printf("[删除] %s\n", snap.name)
+		removes = append(removes, snap.path)
+	}
+
+	if dryRun {
+		progress.printf("\n模拟运行, 未做任何修改: 保留 %d, 删除 %d\n", len(keep), len(removes))
+		return
+	}
+	if len(removes) == 0 {
+		return
+	}
+
+	err = info.Remove(removes...)
+	if err != nil {
+		msg := fmt.Sprintf("删除过期的快照失败, %s\n", err)
+		progress.printf("%s", msg)
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+		return
+	}
+	msg := fmt.Sprintf("已删除备份 %s 的 %d 个过期快照, 可在网盘文件回收站找回\n", name, len(removes))
+	progress.printf("%s", msg)
+	pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+}
+
+// backupKeep 返回要保留的快照及保留的原因, snaps 按时间从新到旧排列.
+// 每项策略从最新的快照开始, 保留每个日, 周, 月中最新的完整快照, 直到达到数量.
+// 不完整的快照不计入策略, 比最新的完整快照新的不完整快照也保留
+func backupKeep(snaps []*backupSnapshot, retention *BackupRetention) map[string][]string {
+	keep := map[string][]string{}
+	for _, snap := range snaps {
+		if snap.complete {
+			keep[snap.name] = []string{"最新"}
+			break
+		}
+		keep[snap.name] = []string{"不完整"}
+	}
+
+	rules := []struct {
+		n      int
+		reason string
+		period func(t time.Time) string
+	}{
+		{retention.KeepDaily, "每日", func(t time.Time) string { return t.Format("2006-01-02") }},
+		{retention.KeepWeekly, "每周", func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+		{retention.KeepMonthly, "每月", func(t time.Time) string { return t.Format("2006-01") }},
+	}
+	for _, rule := range rules {
+		var (
+			last  string
+			count int
+		)
+		for _, snap := range snaps {
+			if count >= rule.n {
+				break
+			}
+			if !snap.complete {
+				continue
+			}
+			period := rule.period(snap.time)
+			if period == last {
+				continue
+			}
+			last = period
+			count++
+			keep[snap.name] = append(keep[snap.name], rule.reason)
+		}
+	}
+	return keep
+}
@@ -0,0 +1,157 @@
+package pcscommand
+
+import (
+	"github.com/json-iterator/go"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestBackupKeep(t *testing.T) {
+	snap := func(year int, month time.Month, day, hour int, complete bool) *backupSnapshot {
+		tm := time.Date(year, month, day, hour, 0, 0, 0, time.Local)
+		return &backupSnapshot{
+			name:     tm.Format(backupTimeFormat),
+			time:     tm,
+			complete: complete,
+		}
+	}
+	name := func(year int, month time.Month, day, hour int) string {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.Local).Format(backupTimeFormat)
+	}
+
+	for _, tt := range []struct {
+		desc      string
+		snaps     []*backupSnapshot
+		retention BackupRetention
+		want      map[string][]string
+	}{
+		{
+			desc: "每日",
+			snaps: []*backupSnapshot{
+				snap(2026, 1, 5, 23, true),
+				snap(2026, 1, 5, 10, true),
+				snap(2026, 1, 4, 9, true),
+				snap(2026, 1, 3, 9, true),
+			},
+			retention: BackupRetention{KeepDaily: 2},
+			want: map[string][]string{
+				name(2026, 1, 5, 23): {"最新", "每日"},
+				name(2026, 1, 4, 9):  {"每日"},
+			},
+		},
+		{
+			// 2026-01-04 为周日, 2025-12-29 至 2026-01-04 为 2026 年第 1 周
+			desc: "每周和每月",
+			snaps: []*backupSnapshot{
+				snap(2026, 1, 5, 9, true),
+				snap(2026, 1, 4, 9, true),
+				snap(2026, 1, 1, 9, true),
+				snap(2025, 12, 29, 9, true),
+				snap(2025, 12, 28, 9, true),
+			},
+			retention: BackupRetention{KeepWeekly: 2, KeepMonthly: 2},
+			want: map[string][]string{
+				name(2026, 1, 5, 9):   {"最新", "每周", "每月"},
+				name(2026, 1, 4, 9):   {"每周"},
+				name(2025, 12, 29, 9): {"每月"},
+			},
+		},
+		{
+			desc: "最新的快照不完整",
+			snaps: []*backupSnapshot{
+				snap(2026, 1, 5, 23, false),
+				snap(2026, 1, 5, 10, true),
+				snap(2026, 1, 4, 9, true),
+			},
+			retention: BackupRetention{KeepDaily: 1},
+			want: map[string][]string{
+				name(2026, 1, 5, 23): {"不完整"},
+				name(2026, 1, 5, 10): {"最新", "每日"},
+			},
+		},
+		{
+			desc: "不完整的快照不代表所在的月",
+			snaps: []*backupSnapshot{
+				snap(2026, 2, 1, 9, true),
+				snap(2026, 1, 31, 9, false),
+				snap(2026, 1, 30, 9, true),
+				snap(2025, 12, 31, 9, true),
+			},
+			retention: BackupRetention{KeepMonthly: 2},
+			want: map[string][]string{
+				name(2026, 2, 1, 9):  {"最新", "每月"},
+				name(2026, 1, 30, 9): {"每月"},
+			},
+		},
+		{
+			desc: "没有完整的快照",
+			snaps: []*backupSnapshot{
+				snap(2026, 1, 5, 9, false),
+				snap(2026, 1, 4, 9, false),
+			},
+			retention: BackupRetention{KeepDaily: 1},
+			want: map[string][]string{
+				name(2026, 1, 5, 9): {"不完整"},
+				name(2026, 1, 4, 9): {"不完整"},
+			},
+		},
+	} {
+		got := backupKeep(tt.snaps, &tt.retention)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: backupKeep = %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestBackupRestorePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	to := filepath.Join(dir, "to")
+
+	// 被篡改的清单
+	manifest := &backupManifest{}
+	err = jsoniter.Unmarshal([]byte(`{
+		"files": [
+			{"path": "a/1.txt"},
+			{"path": "../evil.txt"},
+			{"path": "a/../../evil.txt"},
+			{"path": "/etc/evil.txt"},
+			{"path": "a\\..\\..\\evil.txt"},
+			{"path": "."},
+			{"path": ""}
+		],
+		"empty_dirs": ["b", "..", "b/../.."]
+	}`), manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var accepted []string
+	for _, mf := range manifest.Files {
+		if localPath, err := backupRestorePath(to, mf.Path); err == nil {
+			accepted = append(accepted, localPath)
+		}
+	}
+	for _, rel := range manifest.EmptyDirs {
+		if localPath, err := backupRestorePath(to, rel); err == nil {
+			accepted = append(accepted, localPath)
+		}
+	}
+
+	want := []string{filepath.Join(to, "a", "1.txt"), filepath.Join(to, "b")}
+	if runtime.GOOS != "windows" {
+		// 非 windows 下 \ 不是分隔符, 是文件名的一部分
+		want = []string{filepath.Join(to, "a", "1.txt"), filepath.Join(to, `a\..\..\evil.txt`), filepath.Join(to, "b")}
+	}
+	if !reflect.DeepEqual(accepted, want) {
+		t.Errorf("accepted = %v, want %v", accepted, want)
+	}
+}
@@ -8,19 +8,19 @@ import (
 )
 
 var (
-	info = new(baidupcs.BaiduPCS)
+	info = newCryptPCS(new(baidupcs.BaiduPCS))
 )
 
 // GetPCSInfo 重载并返回 PCS 配置信息
 func GetPCSInfo() *baidupcs.BaiduPCS {
 	ReloadInfo()
-	return info
+	return info.BaiduPCS
 }
 
 // ReloadInfo 重载配置
 func ReloadInfo() {
 	pcsconfig.Reload()
-	info = baidupcs.NewPCS(pcsconfig.Config.MustGetActive().BDUSS)
+	info = newCryptPCS(baidupcs.NewPCS(pcsconfig.Config.MustGetActive().BDUSS))
 }
 
 // ReloadIfInConsole 程序在 Console 模式下才会重载配置
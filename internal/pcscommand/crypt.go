@@ -0,0 +1,71 @@
+package pcscommand
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
+	"github.com/iikira/BaiduPCS-Go/requester"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+const (
+	// CryptKeyEnv 未指定 --key 和 --key-file 时, 从此环境变量读取加密口令
+	CryptKeyEnv = "BAIDUPCS_GO_KEY"
+)
+
+// CryptKeyOptions 加密口令的来源, 优先级: Key, KeyFile, 环境变量 CryptKeyEnv
+type CryptKeyOptions struct {
+	Key     string // 口令
+	KeyFile string // 从文件读取口令, 去除末尾的换行符
+}
+
+// Passphrase 读取加密口令
+func (ko *CryptKeyOptions) Passphrase() ([]byte, error) {
+	switch {
+	case ko.Key != "":
+		return []byte(ko.Key), nil
+	case ko.KeyFile != "":
+		data, err := ioutil.ReadFile(ko.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取密钥文件失败, %s", err)
+		}
+		data = bytes.TrimRight(data, "\r\n")
+		if len(data) == 0 {
+			return nil, fmt.Errorf("密钥文件 %s 为空", ko.KeyFile)
+		}
+		return data, nil
+	}
+	if key := os.Getenv(CryptKeyEnv); key != "" {
+		return []byte(key), nil
+	}
+	return nil, fmt.Errorf("未指定密钥, 请使用 --key, --key-file 或环境变量 %s", CryptKeyEnv)
+}
+
+// fetchCryptHeader 请求下载链接的开头部分, 解析加密文件的头部, 不是加密文件返回 pcscrypto.ErrNotEncrypted
+func fetchCryptHeader(h *requester.HTTPClient, downloadURL string) (*pcscrypto.Header, error) {
+	resp, err := h.Req("GET", downloadURL, nil, map[string]string{
+		"Range": "bytes=0-" + strconv.Itoa(pcscrypto.HeaderSize-1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200, 206:
+	case 416: // Range Not Satisfiable, 空文件
+		return nil, pcscrypto.ErrNotEncrypted
+	default:
+		return nil, fmt.Errorf("获取文件头部失败, %s", resp.Status)
+	}
+
+	buf := make([]byte, pcscrypto.HeaderSize)
+	n, err := io.ReadFull(resp.Body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return pcscrypto.ParseHeader(buf[:n])
+}
@@ -0,0 +1,322 @@
+package pcscommand
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
+	"path"
+	"strings"
+	"sync"
+)
+
+// cryptPCS 在 baidupcs.BaiduPCS 的基础上, 加密解密加密根目录下的文件名和目录名.
+// 各命令使用明文路径, 网盘中保存加密的路径, 返回的文件信息为明文路径
+type cryptPCS struct {
+	*baidupcs.BaiduPCS
+
+	ciphers map[string]*pcscrypto.NameCipher // 各加密根目录的密钥, 以根目录路径和盐为键
+	mu      sync.Mutex
+}
+
+func newCryptPCS(pcs *baidupcs.BaiduPCS) *cryptPCS {
+	return &cryptPCS{
+		BaiduPCS: pcs,
+		ciphers:  map[string]*pcscrypto.NameCipher{},
+	}
+}
+
+// findCryptRoot 返回路径 p 所在的加密根目录, 不在加密根目录内返回 nil
+func findCryptRoot(p string) *pcsconfig.CryptRoot {
+	activeUser, err := pcsconfig.Config.GetActive()
+	if err != nil {
+		return nil
+	}
+	for _, root := range activeUser.CryptRoots {
+		if p == root.Path || strings.HasPrefix(p, root.Path+"/") {
+			return root
+		}
+	}
+	return nil
+}
+
+// nameCipher 返回加密根目录的密钥, 并校验口令
+func (cp *cryptPCS) nameCipher(root *pcsconfig.CryptRoot) (*pcscrypto.NameCipher, error) {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cacheKey := root.Path + "\x00" + root.Salt
+	if nc, ok := cp.ciphers[cacheKey]; ok {
+		return nc, nil
+	}
+
+	passphrase, err := (&CryptKeyOptions{KeyFile: root.KeyFile}).Passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("加密根目录 %s, %s", root.Path, err)
+	}
+	nc, err := newRootNameCipher(root, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("加密根目录 %s, %s", root.Path, err)
+	}
+	cp.ciphers[cacheKey] = nc
+	return nc, nil
+}
+
+// newRootNameCipher 由口令派生加密根目录的密钥, 口令错误返回 pcscrypto.ErrWrongKey
+func newRootNameCipher(root *pcsconfig.CryptRoot, passphrase []byte) (*pcscrypto.NameCipher, error) {
+	salt, err := hex.DecodeString(root.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nc, check, err := pcscrypto.NewNameCipher(passphrase, salt, root.Iterations)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(check) != root.Check {
+		return nil, pcscrypto.ErrWrongKey
+	}
+	return nc, nil
+}
+
+// encryptPath 加密路径中位于加密根目录下的各层名称
+func (cp *cryptPCS) encryptPath(p string) (string, error) {
+	root := findCryptRoot(p)
+	if root == nil || p == root.Path {
+		return p, nil
+	}
+
+	nc, err := cp.nameCipher(root)
+	if err != nil {
+		return "", err
+	}
+
+	names := strings.Split(p[len(root.Path)+1:], "/")
+	for k := range names {
+		if names[k] == "" {
+			continue
+		}
+		names[k], err = nc.EncryptName(names[k])
+		if err != nil {
+			return "", fmt.Errorf("加密路径 %s 失败, %s", p, err)
+		}
+	}
+	return root.Path + "/" + strings.Join(names, "/"), nil
+}
+
+func (cp *cryptPCS) encryptPaths(paths []string) (encrypted []string, err error) {
+	encrypted = make([]string, len(paths))
+	for k := range paths {
+		encrypted[k], err = cp.encryptPath(paths[k])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return encrypted, nil
+}
+
+// decryptPath 解密路径中位于加密根目录下的各层名称, 无法解密的名称保持不变, 例如在网页中上传的文件
+func (cp *cryptPCS) decryptPath(p string) string {
+	root := findCryptRoot(p)
+	if root == nil || p == root.Path {
+		return p
+	}
+
+	nc, err := cp.nameCipher(root)
+	if err != nil {
+		return p
+	}
+
+	names := strings.Split(p[len(root.Path)+1:], "/")
+	for k := range names {
+		if name, err := nc.DecryptName(names[k]); err == nil {
+			names[k] = name
+		}
+	}
+	return root.Path + "/" + strings.Join(names, "/")
+}
+
+func (cp *cryptPCS) decryptFileDirectory(fd *baidupcs.FileDirectory) {
+	if fd == nil {
+		return
+	}
+	if p := cp.decryptPath(fd.Path); p != fd.Path {
+		fd.Path, fd.Filename = p, path.Base(p)
+	}
+}
+
+func (cp *cryptPCS) decryptFileDirectoryList(fdl baidupcs.FileDirectoryList) {
+	for _, fd := range fdl {
+		cp.decryptFileDirectory(fd)
+	}
+}
+
+// encryptCpMvJSON 加密复制, 移动的源路径和目标路径.
+// 目录中各层的名称不会重新加密, 不在同一个加密根目录内的目录 (包括移入, 移出加密根目录) 返回错误
+func (cp *cryptPCS) encryptCpMvJSON(cpmvJSON []*baidupcs.CpMvJSON) (encrypted []*baidupcs.CpMvJSON, err error) {
+	encrypted = make([]*baidupcs.CpMvJSON, len(cpmvJSON))
+	for k := range cpmvJSON {
+		from, err := cp.encryptPath(cpmvJSON[k].From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := cp.encryptPath(cpmvJSON[k].To)
+		if err != nil {
+			return nil, err
+		}
+		if findCryptRoot(cpmvJSON[k].From) != findCryptRoot(cpmvJSON[k].To) {
+			fd, err := cp.BaiduPCS.FilesDirectoriesMeta(from)
+			if err == nil && fd.Isdir {
+				return nil, fmt.Errorf("%s 与 %s 不在同一个加密根目录内, 目录中的文件名不会重新加密, 不能复制或移动目录, 请逐个复制或移动其中的文件", cpmvJSON[k].From, cpmvJSON[k].To)
+			}
+		}
+		encrypted[k] = &baidupcs.CpMvJSON{
+			From: from,
+			To:   to,
+		}
+	}
+	return encrypted, nil
+}
+
+// FilesDirectoriesMeta 获取单个文件/目录的元信息
+func (cp *cryptPCS) FilesDirectoriesMeta(p string) (data *baidupcs.FileDirectory, err error) {
+	ep, err := cp.encryptPath(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err = cp.BaiduPCS.FilesDirectoriesMeta(ep)
+	cp.decryptFileDirectory(data)
+	return
+}
+
+// FilesDirectoriesBatchMeta 获取多个文件/目录的元信息
+func (cp *cryptPCS) FilesDirectoriesBatchMeta(paths ...string) (data baidupcs.FileDirectoryList, err error) {
+	eps, err := cp.encryptPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+	data, err = cp.BaiduPCS.FilesDirectoriesBatchMeta(eps...)
+	cp.decryptFileDirectoryList(data)
+	return
+}
+
+// FilesDirectoriesList 获取目录下的文件和目录列表
+func (cp *cryptPCS) FilesDirectoriesList(p string, recurse bool) (data baidupcs.FileDirectoryList, err error) {
+	ep, err := cp.encryptPath(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err = cp.BaiduPCS.FilesDirectoriesList(ep, recurse)
+	if err != nil {
+		return nil, err
+	}
+
+	// 隐藏加密根目录的元数据文件
+	list := data[:0]
+	for _, fd := range data {
+		if isCryptRootMetaFile(fd.Path) {
+			continue
+		}
+		cp.decryptFileDirectory(fd)
+		list = append(list, fd)
+	}
+	return list, nil
+}
+
+// Remove 批量删除文件/目录
+func (cp *cryptPCS) Remove(paths ...string) error {
+	eps, err := cp.encryptPaths(paths)
+	if err != nil {
+		return err
+	}
+	return cp.BaiduPCS.Remove(eps...)
+}
+
+// Mkdir 创建目录
+func (cp *cryptPCS) Mkdir(p string) error {
+	ep, err := cp.encryptPath(p)
+	if err != nil {
+		return err
+	}
+	return cp.BaiduPCS.Mkdir(ep)
+}
+
+// Rename 重命名文件/目录
+func (cp *cryptPCS) Rename(from, to string) error {
+	cj, err := cp.encryptCpMvJSON([]*baidupcs.CpMvJSON{{From: from, To: to}})
+	if err != nil {
+		return err
+	}
+	return cp.BaiduPCS.Rename(cj[0].From, cj[0].To)
+}
+
+// Copy 批量拷贝文件/目录
+func (cp *cryptPCS) Copy(cpmvJSON ...*baidupcs.CpMvJSON) error {
+	cj, err := cp.encryptCpMvJSON(cpmvJSON)
+	if err != nil {
+		return err
+	}
+	return cp.BaiduPCS.Copy(cj...)
+}
+
+// Move 批量移动文件/目录
+func (cp *cryptPCS) Move(cpmvJSON ...*baidupcs.CpMvJSON) error {
+	cj, err := cp.encryptCpMvJSON(cpmvJSON)
+	if err != nil {
+		return err
+	}
+	return cp.BaiduPCS.Move(cj...)
+}
+
+// DownloadFile 下载单个文件
+func (cp *cryptPCS) DownloadFile(p string, downloadFunc baidupcs.DownloadFunc) error {
+	ep, err := cp.encryptPath(p)
+	if err != nil {
+		return err
+	}
+	return cp.BaiduPCS.DownloadFile(ep, downloadFunc)
+}
+
+// LocateDownload 获取文件的下载链接
+func (cp *cryptPCS) LocateDownload(p string) (urls []string, err error) {
+	ep, err := cp.encryptPath(p)
+	if err != nil {
+		return nil, err
+	}
+	return cp.BaiduPCS.LocateDownload(ep)
+}
+
+// RapidUpload 秒传文件
+func (cp *cryptPCS) RapidUpload(targetPath, contentMD5, sliceMD5, crc32 string, length int64) error {
+	ep, err := cp.encryptPath(targetPath)
+	if err != nil {
+		return err
+	}
+	return cp.BaiduPCS.RapidUpload(ep, contentMD5, sliceMD5, crc32, length)
+}
+
+// Upload 上传单个文件
+func (cp *cryptPCS) Upload(targetPath string, uploadFunc baidupcs.UploadFunc) (uploaded *baidupcs.UploadedFile, err error) {
+	ep, err := cp.encryptPath(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	uploaded, err = cp.BaiduPCS.Upload(ep, uploadFunc)
+	if uploaded != nil {
+		uploaded.Path = cp.decryptPath(uploaded.Path)
+	}
+	return
+}
+
+// UploadCreateSuperFile 分片上传, 合并分片文件
+func (cp *cryptPCS) UploadCreateSuperFile(targetPath string, blockList ...string) (uploaded *baidupcs.UploadedFile, err error) {
+	ep, err := cp.encryptPath(targetPath)
+	if err != nil {
+		return nil, err
+	}
+	uploaded, err = cp.BaiduPCS.UploadCreateSuperFile(ep, blockList...)
+	if uploaded != nil {
+		uploaded.Path = cp.decryptPath(uploaded.Path)
+	}
+	return
+}
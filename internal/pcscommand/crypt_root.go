@@ -0,0 +1,214 @@
+package pcscommand
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
+	"github.com/iikira/BaiduPCS-Go/pcspath"
+	"github.com/iikira/BaiduPCS-Go/pcstable"
+	"github.com/json-iterator/go"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// cryptRootMetaFile 加密根目录的元数据文件, 记录密钥派生的参数, 不含密钥
+	cryptRootMetaFile = ".pcscrypt"
+
+	cryptRootMethod = "aes-256-siv-base32"
+)
+
+// cryptRootMeta 加密根目录的元数据
+type cryptRootMeta struct {
+	Version    int    `json:"version"`
+	Method     string `json:"method"`
+	Salt       string `json:"salt"`       // hex 编码
+	Iterations int    `json:"iterations"` // PBKDF2 迭代次数
+	Check      string `json:"check"`      // 口令的校验值, hex 编码
+}
+
+// isCryptRootMetaFile 是否为加密根目录的元数据文件
+func isCryptRootMetaFile(p string) bool {
+	if path.Base(p) != cryptRootMetaFile {
+		return false
+	}
+	root := findCryptRoot(p)
+	return root != nil && path.Dir(p) == root.Path
+}
+
+// RunCryptRootInit 将网盘目录设为文件名加密的根目录, 目录不存在则创建.
+// 目录中已有元数据文件时, 例如在其他设备初始化过, 校验口令后添加到本地配置
+func RunCryptRootInit(dir string, ko *CryptKeyOptions) {
+	dir = pcspath.NewPCSPath(&pcsconfig.Config.MustGetActive().Workdir, dir).AbsPathNoMatch()
+	if dir == "/" {
+		fmt.Printf("不能将根目录设为加密根目录\n")
+		return
+	}
+
+	activeUser := pcsconfig.Config.MustGetActive()
+	for _, root := range activeUser.CryptRoots {
+		switch {
+		case root.Path == dir:
+			fmt.Printf("%s 已是加密根目录\n", dir)
+			return
+		case strings.HasPrefix(dir, root.Path+"/"), strings.HasPrefix(root.Path, dir+"/"):
+			fmt.Printf("加密根目录不能嵌套, 已有加密根目录: %s\n", root.Path)
+			return
+		}
+	}
+
+	passphrase, err := ko.Passphrase()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	root := &pcsconfig.CryptRoot{
+		Path: dir,
+	}
+	if ko.Key != "" {
+		fmt.Printf("警告: --key 指定的口令不会保存, 使用时需设置环境变量 %s, 建议使用 --key-file\n", CryptKeyEnv)
+	}
+	if ko.Key == "" && ko.KeyFile != "" {
+		root.KeyFile, err = filepath.Abs(ko.KeyFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	// 使用未加密路径的操作
+	pcs := info.BaiduPCS
+	metaPath := dir + "/" + cryptRootMetaFile
+	if _, err = pcs.FilesDirectoriesMeta(metaPath); err == nil {
+		err = loadCryptRootMeta(root, metaPath, passphrase)
+		if err != nil {
+			fmt.Printf("读取加密根目录的元数据失败, %s\n", err)
+			return
+		}
+		fmt.Printf("已读取加密根目录 %s 的元数据, 口令正确\n", dir)
+	} else {
+		err = createCryptRoot(root, metaPath, passphrase)
+		if err != nil {
+			fmt.Printf("初始化加密根目录失败, %s\n", err)
+			return
+		}
+		fmt.Printf("初始化加密根目录成功: %s\n", dir)
+	}
+
+	activeUser.CryptRoots = append(activeUser.CryptRoots, root)
+	err = pcsconfig.Config.Save()
+	if err != nil {
+		fmt.Printf("保存配置失败, %s\n", err)
+		return
+	}
+
+	if root.KeyFile == "" {
+		fmt.Printf("使用时从环境变量 %s 读取口令\n", CryptKeyEnv)
+	} else {
+		fmt.Printf("使用时从 %s 读取口令\n", root.KeyFile)
+	}
+}
+
+// loadCryptRootMeta 读取网盘中的元数据, 校验口令
+func loadCryptRootMeta(root *pcsconfig.CryptRoot, metaPath string, passphrase []byte) error {
+	data, err := downloadBytes(info.BaiduPCS, metaPath, 64*1024)
+	if err != nil {
+		return err
+	}
+
+	meta := &cryptRootMeta{}
+	err = jsoniter.Unmarshal(data, meta)
+	if err != nil {
+		return err
+	}
+	if meta.Version != 1 || meta.Method != cryptRootMethod {
+		return fmt.Errorf("不支持的版本或加密方法: %d, %s", meta.Version, meta.Method)
+	}
+
+	root.Salt, root.Iterations, root.Check = meta.Salt, meta.Iterations, meta.Check
+	_, err = newRootNameCipher(root, passphrase)
+	return err
+}
+
+// createCryptRoot 创建目录, 生成密钥派生的参数, 上传元数据
+func createCryptRoot(root *pcsconfig.CryptRoot, metaPath string, passphrase []byte) error {
+	pcs := info.BaiduPCS
+	fd, err := pcs.FilesDirectoriesMeta(root.Path)
+	switch {
+	case err != nil:
+		err = pcs.Mkdir(root.Path)
+		if err != nil {
+			return err
+		}
+	case !fd.Isdir:
+		return fmt.Errorf("%s 不是目录", root.Path)
+	default:
+		if files, _ := pcs.FilesDirectoriesList(root.Path, false); len(files) > 0 {
+			fmt.Printf("警告: 目录 %s 不为空, 已有的文件名不会被加密\n", root.Path)
+		}
+	}
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return err
+	}
+	_, check, err := pcscrypto.NewNameCipher(passphrase, salt, pcscrypto.DefaultIterations)
+	if err != nil {
+		return err
+	}
+
+	root.Salt, root.Iterations, root.Check = hex.EncodeToString(salt), pcscrypto.DefaultIterations, hex.EncodeToString(check)
+	data, err := jsoniter.MarshalIndent(&cryptRootMeta{
+		Version:    1,
+		Method:     cryptRootMethod,
+		Salt:       root.Salt,
+		Iterations: root.Iterations,
+		Check:      root.Check,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return uploadBytes(pcs, metaPath, data)
+}
+
+// RunCryptRootList 列出当前帐号的加密根目录
+func RunCryptRootList() {
+	tb := pcstable.NewTable(os.Stdout)
+	tb.SetHeader([]string{"#", "加密根目录", "口令来源"})
+	for k, root := range pcsconfig.Config.MustGetActive().CryptRoots {
+		source := "环境变量 " + CryptKeyEnv
+		if root.KeyFile != "" {
+			source = root.KeyFile
+		}
+		tb.Append([]string{strconv.Itoa(k), root.Path, source})
+	}
+	tb.Render()
+}
+
+// RunCryptRootRemove 从本地配置中移除加密根目录, 不修改网盘中的文件
+func RunCryptRootRemove(dir string) {
+	dir = pcspath.NewPCSPath(&pcsconfig.Config.MustGetActive().Workdir, dir).AbsPathNoMatch()
+
+	activeUser := pcsconfig.Config.MustGetActive()
+	for k, root := range activeUser.CryptRoots {
+		if root.Path != dir {
+			continue
+		}
+		activeUser.CryptRoots = append(activeUser.CryptRoots[:k], activeUser.CryptRoots[k+1:]...)
+		err := pcsconfig.Config.Save()
+		if err != nil {
+			fmt.Printf("保存配置失败, %s\n", err)
+			return
+		}
+		fmt.Printf("已移除加密根目录: %s, 网盘中的文件不受影响\n", dir)
+		return
+	}
+	fmt.Printf("%s 不是加密根目录\n", dir)
+}
@@ -6,7 +6,10 @@ import (
 	"github.com/iikira/BaiduPCS-Go/baidupcs"
 	"github.com/iikira/BaiduPCS-Go/downloader"
 	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
 	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/iikira/BaiduPCS-Go/pcsverbose"
 	"github.com/iikira/BaiduPCS-Go/requester"
 	"net/http/cookiejar"
 	"os"
@@ -18,15 +21,19 @@ import (
 type dtask struct {
 	ListTask
 	path         string                  // 下载的路径
+	root         string                  // 所属的下载目录, 用于过滤
 	downloadInfo *baidupcs.FileDirectory // 文件或目录详情
+	localPath    string                  // 本地保存路径, 首次处理时确定, 重试时沿用
+	action       conflictAction          // 本地文件已存在时的处理
 }
 
-func getDownloadFunc(id int, cfg *downloader.Config) baidupcs.DownloadFunc {
+// getDownloadFunc 返回下载文件的函数, keys 不为 nil 时, 解密下载
+func getDownloadFunc(id int, remotePath, savePath string, cfg *downloader.Config, keys *pcscrypto.KeyCache, progress *progressOutput) baidupcs.DownloadFunc {
 	if cfg == nil {
 		cfg = downloader.NewConfig()
 	}
 
-	return func(downloadURL string, jar *cookiejar.Jar, savePath string) error {
+	return func(downloadURL string, jar *cookiejar.Jar) error {
 		h := requester.NewHTTPClient()
 		h.UserAgent = pcsconfig.Config.UserAgent
 
@@ -37,46 +44,52 @@ func getDownloadFunc(id int, cfg *downloader.Config) baidupcs.DownloadFunc {
 		cfg.Client = h
 		cfg.SavePath = savePath
 
+		// 解密下载, 先获取加密文件的头部, 不是加密文件时按原样下载
+		cfg.WrapWriter = nil
+		if keys != nil && !cfg.Testing {
+			header, err := fetchCryptHeader(h, downloadURL)
+			switch err {
+			case nil:
+				c, err := keys.Open(header) // 同一次上传的文件使用同一个密钥, 只派生一次
+				if err != nil {
+					return fmt.Errorf("[%d] 解密失败, %s", id, err)
+				}
+				cfg.WrapWriter = func(w downloader.Writer) downloader.Writer {
+					return pcscrypto.NewDecryptWriter(w, c)
+				}
+			case pcscrypto.ErrNotEncrypted:
+				progress.printf("[%d] 提示: %s 不是加密文件, 按原样下载\n", id, remotePath)
+			default:
+				return fmt.Errorf("[%d] 解密失败, %s", id, err)
+			}
+		}
+
 		download, err := downloader.NewDownloader(downloadURL, *cfg)
 		if err != nil {
 			return err
 		}
+		progress.subscribe(&download.Events, id, remotePath, savePath)
 
-		exitDownloadFunc := make(chan struct{})
-
-		download.OnExecute = func() {
-			if cfg.Testing {
-				fmt.Printf("[%d] 测试下载开始\n\n", id)
-			}
-
-			dlog := fmt.Sprintf("%s/%d.log", pcsutil.CheckLogPath(), id)
-			logmsg := fmt.Sprintf("[%d] %s\n", id, dlog)
-			fmt.Print(logmsg)
-			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), logmsg, false)
-			ds := download.GetStatusChan()
-			for {
-				select {
-				case <-exitDownloadFunc:
-					return
-				case v, ok := <-ds:
-					if !ok { // channel 已经关闭
-						return
-					}
-					msg := fmt.Sprintf("[%d] ↓ %s/%s %s/s in %s ............\n", id,
-						pcsutil.ConvertFileSize(v.Downloaded, 2),
-						pcsutil.ConvertFileSize(v.TotalSize, 2),
-						pcsutil.ConvertFileSize(v.Speeds, 2),
-						v.TimeElapsed,
-					)
-					fmt.Print(msg)
-					pcsutil.WriteLog(dlog, msg, true)
-				}
+		dlog := fmt.Sprintf("%s/%d.log", pcsutil.CheckLogPath(), id)
+		download.Events.Subscribe(func(e *pcsevent.Event) {
+			if e.Type != pcsevent.Progress {
+				return
 			}
-		}
+			msg := fmt.Sprintf("[%d] ↓ %s/%s %s/s in %s ............\n", id,
+				pcsutil.ConvertFileSize(e.Transferred, 2),
+				pcsutil.ConvertFileSize(e.Total, 2),
+				pcsutil.ConvertFileSize(e.Speed, 2),
+				e.Elapsed/1e6*1e6,
+			)
+			pcsutil.WriteLog(dlog, msg, true)
+		})
 
-		download.OnFinish = func() {
-			exitDownloadFunc <- struct{}{}
+		if cfg.Testing {
+			progress.printf("[%d] 测试下载开始\n\n", id)
 		}
+		logmsg := fmt.Sprintf("[%d] %s\n", id, dlog)
+		progress.printf("%s", logmsg)
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), logmsg, false)
 
 		done, err := download.Execute()
 		if err != nil {
@@ -89,37 +102,127 @@ func getDownloadFunc(id int, cfg *downloader.Config) baidupcs.DownloadFunc {
 		if !cfg.Testing {
 			msg := fmt.Sprintf("[%d] 下载完成, 保存位置: %s\n", id, savePath)
 			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
-			fmt.Print(msg)
+			progress.printf("%s", msg)
 		} else {
-			fmt.Printf("\n\n[%d] 测试下载结束\n\n", id)
+			progress.printf("\n\n[%d] 测试下载结束\n\n", id)
 		}
 
 		return nil
 	}
 }
 
+// DownloadOptions 下载可选参数
+type DownloadOptions struct {
+	IsTest          bool          // 测试下载
+	IsFixedParallel bool          // 固定线程数, 不自适应调整
+	Parallel        int           // 最大下载并发量
+	SaveTo          string        // 储存目录
+	OnConflict      string        // 本地文件已存在时的处理方式, skip, overwrite, rename, newer, md5
+	NoMtime         bool          // 不保留网盘文件的修改时间
+	IsPreallocate   bool          // 下载前预分配硬盘空间
+	SyncInterval    time.Duration // 定期将数据同步到硬盘的间隔
+	Progress        string        // 进度的输出方式, text, json
+	NoMirrors       bool          // 不获取其他下载服务器的链接, 只从 pcs.baidu.com 下载
+
+	// 本地储存路径的布局
+	IsFlat          bool   // 所有文件直接保存到储存目录
+	StripComponents int    // 去除网盘路径开头的层数
+	RelativeTo      string // 网盘路径相对于此目录保存
+
+	// 目录下载的过滤规则
+	Includes  []string // 只下载匹配的文件
+	Excludes  []string // 排除匹配的文件和目录
+	MinSize   string   // 文件大小下限
+	MaxSize   string   // 文件大小上限
+	NewerThan string   // 只下载修改时间晚于此时间的文件
+	OlderThan string   // 只下载修改时间早于此时间的文件
+
+	// 解密使用 upload --encrypt 上传的文件
+	Decrypt bool
+	CryptKeyOptions
+}
+
 // RunDownload 执行下载网盘内文件
-func RunDownload(testing bool, parallel int, savePath string, paths []string) {
+func RunDownload(paths []string, options *DownloadOptions) {
+	if options == nil {
+		options = &DownloadOptions{}
+	}
+
+	var (
+		testing  = options.IsTest
+		savePath = options.SaveTo
+	)
+
 	// 设置下载配置
 	cfg := &downloader.Config{
-		Testing:   testing,
-		CacheSize: pcsconfig.Config.CacheSize,
+		Testing:       testing,
+		FixedParallel: options.IsFixedParallel,
+		CacheSize:     pcsconfig.Config.CacheSize,
+		Preallocate:   options.IsPreallocate,
+		SyncInterval:  options.SyncInterval,
 	}
 
 	// 设置下载最大并发量
-	if parallel == 0 {
-		parallel = pcsconfig.Config.MaxParallel
+	cfg.Parallel = options.Parallel
+	if cfg.Parallel == 0 {
+		cfg.Parallel = pcsconfig.Config.MaxParallel
 	}
-	cfg.Parallel = parallel
 
-	paths, err := getAllAbsPaths(paths...)
+	policy, err := parseConflictPolicy(options.OnConflict)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
 
-	fmt.Printf("\n")
-	fmt.Printf("[0] 提示:当前下载最大并发量为: %d, 下载缓存为: %d\n", cfg.Parallel, cfg.CacheSize)
+	// 解密下载, 缓存派生的密钥
+	var keys *pcscrypto.KeyCache
+	if options.Decrypt {
+		passphrase, err := options.Passphrase()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		keys = pcscrypto.NewKeyCache(passphrase)
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	filter, err := newDownloadFilter(options)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	paths, err = getAllAbsPaths(paths...)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	layout := &downloadLayout{
+		saveDir:         savePath,
+		stripComponents: options.StripComponents,
+		flat:            options.IsFlat,
+	}
+	if options.RelativeTo != "" {
+		layout.relativeTo, err = getAbsPath(options.RelativeTo)
+		if err != nil {
+			progress.printf("%s\n", err)
+			return
+		}
+	}
+
+	progress.printf("\n")
+	if cfg.FixedParallel {
+		progress.printf("[0] 提示: 当前下载并发量为: %d, 下载缓存为: %d\n", cfg.Parallel, cfg.CacheSize)
+	} else {
+		progress.printf("[0] 提示: 当前下载最大并发量为: %d (根据下载速度自动调整), 下载缓存为: %d\n", cfg.Parallel, cfg.CacheSize)
+	}
 
 	dlist := list.New()
 	lastID := 0
@@ -133,7 +236,7 @@ func RunDownload(testing bool, parallel int, savePath string, paths []string) {
 			},
 			path: paths[k],
 		})
-		fmt.Printf("[%d] 加入下载队列: %s\n", lastID, paths[k])
+		progress.printf("[%d] 加入下载队列: %s\n", lastID, paths[k])
 	}
 
 	var (
@@ -150,21 +253,29 @@ func RunDownload(testing bool, parallel int, savePath string, paths []string) {
 
 			// 不重试的情况
 			switch {
-			case strings.Compare(errManifest, "下载文件错误") == 0 && strings.Contains(err.Error(), "文件已存在"):
-				fmt.Printf("[%d] %s, %s\n", task.ID, errManifest, err)
+			case strings.Compare(errManifest, "下载文件错误") == 0 && strings.Contains(err.Error(), "文件已存在"),
+				strings.Contains(err.Error(), pcscrypto.ErrWrongKey.Error()), strings.Contains(err.Error(), pcscrypto.ErrUnsupported.Error()),
+				strings.Contains(err.Error(), pcscrypto.ErrIterations.Error()):
+				progress.printf("[%d] %s, %s\n", task.ID, errManifest, err)
+				progress.emitTask(pcsevent.Failed, pcsevent.KindDownload, &task.ListTask, task.path, "", err)
 				return
 			}
 
-			fmt.Printf("[%d] %s, %s, 重试 %d/%d\n", task.ID, errManifest, err, task.retry, task.MaxRetry)
+			progress.printf("[%d] %s, %s, 重试 %d/%d\n", task.ID, errManifest, err, task.retry, task.MaxRetry)
 
 			// 未达到失败重试最大次数, 将任务推送到队列末尾
 			if task.retry < task.MaxRetry {
 				task.retry++
 				dlist.PushBack(task)
+				progress.emitTask(pcsevent.Retry, pcsevent.KindDownload, &task.ListTask, task.path, "", err)
+			} else {
+				progress.emitTask(pcsevent.Failed, pcsevent.KindDownload, &task.ListTask, task.path, "", err)
 			}
 			time.Sleep(3 * time.Duration(task.retry) * time.Second)
 		}
 		totalSize int64
+		summary   conflictSummary
+		dirs      []dirMtime // 需要设置修改时间的目录
 	)
 
 	for {
@@ -184,28 +295,49 @@ func RunDownload(testing bool, parallel int, savePath string, paths []string) {
 			task.downloadInfo, err = info.FilesDirectoriesMeta(task.path)
 			if err != nil {
 				// 不重试
-				fmt.Printf("[%d] 获取路径信息错误, %s\n", task.ID, err)
+				progress.printf("[%d] 获取路径信息错误, %s\n", task.ID, err)
 				continue
 			}
 		}
 
-		fmt.Printf("\n")
-		fmt.Printf("[%d] ----\n%s\n", task.ID, task.downloadInfo.String())
+		progress.printf("\n")
+		progress.printf("[%d] ----\n%s\n", task.ID, task.downloadInfo.String())
 
 		// 如果是一个目录, 将子文件和子目录加入队列
 		if task.downloadInfo.Isdir {
-			if !testing { // 测试下载, 不建立空目录
-				os.MkdirAll(pcsconfig.GetSavePath(savePath,task.path), 0777) // 首先在本地创建目录
+			// 测试下载, 不建立空目录
+			// 启用过滤时, 目录可能没有需要下载的文件, 也不预先建立目录
+			// 使用 flat 或被 strip-components 去除的目录, 不在本地建立
+			if !testing && filter.isEmpty() && layout.keepDir(task.path) {
+				os.MkdirAll(layout.localPath(task.path), 0777) // 首先在本地创建目录
+			}
+
+			if !testing && !options.NoMtime && layout.keepDir(task.path) {
+				dirs = append(dirs, dirMtime{
+					localPath: layout.localPath(task.path),
+					mtime:     task.downloadInfo.Mtime,
+				})
 			}
 
 			fileList, err := info.FilesDirectoriesList(task.path, false)
 			if err != nil {
 				// 不重试
-				fmt.Printf("[%d] 获取目录信息错误, %s\n", task.ID, err)
+				progress.printf("[%d] 获取目录信息错误, %s\n", task.ID, err)
 				continue
 			}
 
+			root := task.root
+			if root == "" {
+				root = task.path
+			}
+
 			for k := range fileList {
+				// 过滤, 被排除的目录不会再获取其目录信息
+				if !filter.match(root, fileList[k]) {
+					pcsverbose.Verbosef("[%d] 过滤: %s\n", task.ID, fileList[k].Path)
+					continue
+				}
+
 				lastID++
 				dlist.PushBack(&dtask{
 					ListTask: ListTask{
@@ -213,23 +345,87 @@ func RunDownload(testing bool, parallel int, savePath string, paths []string) {
 						MaxRetry: 3,
 					},
 					path:         fileList[k].Path,
+					root:         root,
 					downloadInfo: fileList[k],
 				})
-				fmt.Printf("[%d] 加入下载队列: %s\n", lastID, fileList[k].Path)
+				progress.printf("[%d] 加入下载队列: %s\n", lastID, fileList[k].Path)
 			}
 			continue
 		}
 
+		var (
+			taskCfg      = *cfg
+			downloadFunc baidupcs.DownloadFunc
+		)
+
+		// 重试时沿用首次确定的本地路径, 否则重命名时会选择新的文件名, 遗留未完成的文件
+		if task.localPath == "" {
+			localPath := layout.localPath(task.path)
+
+			// 使用 flat 或 strip-components 时, 不同的网盘文件可能保存到相同的本地路径
+			if other := layout.claim(task.path, localPath); other != "" {
+				progress.printf("[%d] 与 %s 保存到相同的本地路径, 跳过: %s\n", task.ID, other, localPath)
+				summary.addCollision(task.path, other)
+				continue
+			}
+
+			// 本地文件已存在, 根据 policy 处理
+			action := actionDownload
+			if !testing {
+				var reason string
+				action, reason = policy.resolve(task.downloadInfo, localPath, keys != nil)
+				switch action {
+				case actionSkip:
+					progress.printf("[%d] %s, 跳过: %s\n", task.ID, reason, localPath)
+					summary.add(action, localPath)
+					continue
+				case actionOverwrite:
+					progress.printf("[%d] %s, 覆盖: %s\n", task.ID, reason, localPath)
+				case actionRename:
+					localPath = renameLocalPath(localPath)
+					progress.printf("[%d] %s, 保存为: %s\n", task.ID, reason, localPath)
+				}
+			}
+			task.localPath, task.action = localPath, action
+		}
+		localPath := task.localPath
+		taskCfg.IsOverwrite = task.action == actionOverwrite
+
+		// 获取各下载服务器的链接, 分散到多个服务器下载
+		if !options.NoMirrors {
+			mirrors, merr := info.LocateDownload(task.path)
+			if merr != nil {
+				pcsverbose.Verbosef("[%d] 获取下载链接失败, 只从默认服务器下载, %s\n", task.ID, merr)
+			}
+			taskCfg.Mirrors = mirrors
+		}
+
+		downloadFunc = getDownloadFunc(task.ID, task.path, localPath, &taskCfg, keys, progress)
+
 		msg := fmt.Sprintf("[%d] 准备下载: %s\n", task.ID, task.path)
-		fmt.Print(msg)
+		progress.printf("%s", msg)
 		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
-		err = info.DownloadFile(task.path, getDownloadFunc(task.ID, cfg), savePath)
+		err = info.DownloadFile(task.path, downloadFunc)
 		if err != nil {
 			handleTaskErr(task, "下载文件错误", err)
 			continue
 		}
+
+		// 保留网盘文件的修改时间
+		if !testing && !options.NoMtime {
+			mtime := time.Unix(task.downloadInfo.Mtime, 0)
+			err = os.Chtimes(localPath, mtime, mtime)
+			if err != nil {
+				progress.printf("[%d] 设置文件修改时间失败, %s\n", task.ID, err)
+			}
+		}
+
+		summary.add(task.action, localPath)
 		totalSize += task.downloadInfo.Size
 	}
 
-	fmt.Printf("任务结束, 数据总量: %s\n", pcsutil.ConvertFileSize(totalSize))
+	setDirsMtime(dirs, savePath)
+
+	progress.printf("%s", summary.String())
+	progress.printf("任务结束, 数据总量: %s\n", pcsutil.ConvertFileSize(totalSize))
 }
@@ -0,0 +1,172 @@
+package pcscommand
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/downloader"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// conflictPolicy 本地文件已存在时的处理方式
+type conflictPolicy int
+
+const (
+	// conflictSkip 跳过
+	conflictSkip conflictPolicy = iota
+	// conflictOverwrite 覆盖
+	conflictOverwrite
+	// conflictRename 重命名新下载的文件
+	conflictRename
+	// conflictNewer 网盘文件比本地文件新时覆盖, 否则跳过
+	conflictNewer
+	// conflictMD5 本地文件与网盘文件的 md5 不一致时覆盖, 否则跳过
+	conflictMD5
+)
+
+// conflictAction 对已存在的本地文件采取的操作
+type conflictAction int
+
+const (
+	actionDownload  conflictAction = iota // 本地文件不存在, 正常下载
+	actionSkip                            // 跳过
+	actionOverwrite                       // 覆盖
+	actionRename                          // 重命名后下载
+)
+
+// parseConflictPolicy 解析 --on-conflict 参数
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "skip":
+		return conflictSkip, nil
+	case "overwrite":
+		return conflictOverwrite, nil
+	case "rename":
+		return conflictRename, nil
+	case "newer":
+		return conflictNewer, nil
+	case "md5":
+		return conflictMD5, nil
+	}
+	return conflictSkip, fmt.Errorf("未知的文件冲突处理方式: %s, 可选: skip, overwrite, rename, newer, md5", s)
+}
+
+// resolve 根据 policy 决定如何处理已存在的本地文件 localPath, decrypt 为是否解密下载,
+// 返回的 reason 用于输出提示
+func (policy conflictPolicy) resolve(fd *baidupcs.FileDirectory, localPath string, decrypt bool) (action conflictAction, reason string) {
+	// 只有当文件存在, 断点续传文件不存在时, 才判断为存在
+	localInfo, err := os.Stat(localPath)
+	if err != nil {
+		return actionDownload, ""
+	}
+	if _, err = os.Stat(localPath + downloader.DownloadingFileSuffix); err == nil {
+		return actionDownload, ""
+	}
+
+	switch policy {
+	case conflictOverwrite:
+		return actionOverwrite, "覆盖本地文件"
+	case conflictRename:
+		return actionRename, "本地文件已存在, 重命名"
+	case conflictNewer:
+		if fd.Mtime > localInfo.ModTime().Unix() {
+			return actionOverwrite, "网盘文件较新"
+		}
+		return actionSkip, "本地文件不比网盘文件旧"
+	case conflictMD5:
+		// 加密文件比解密后的本地文件多一个头部, 网盘中的 md5 为密文的 md5, 无法与本地文件比较,
+		// 大小一致不代表内容相同, 覆盖
+		if decrypt && localInfo.Size()+int64(pcscrypto.HeaderSize) == fd.Size {
+			return actionOverwrite, "加密文件无法比较 md5"
+		}
+		if localInfo.Size() != fd.Size {
+			return actionOverwrite, "文件大小不一致"
+		}
+
+		lp, err := GetFileSum(localPath, &SumOption{
+			IsMD5Sum: true,
+		})
+		if err != nil {
+			return actionOverwrite, fmt.Sprintf("计算本地文件 md5 失败, %s", err)
+		}
+
+		remoteMD5, _ := hex.DecodeString(fd.MD5)
+		if !bytes.Equal(remoteMD5, lp.MD5) {
+			return actionOverwrite, "md5 不一致"
+		}
+		return actionSkip, "md5 一致"
+	}
+
+	return actionSkip, "本地文件已存在"
+}
+
+// renameLocalPath 为已存在的 localPath 找到一个未被占用的文件名,
+// 例如 1.mp4 -> 1 (1).mp4
+func renameLocalPath(localPath string) string {
+	var (
+		ext  = filepath.Ext(localPath)
+		base = strings.TrimSuffix(localPath, ext)
+	)
+
+	for i := 1; ; i++ {
+		p := base + " (" + strconv.Itoa(i) + ")" + ext
+		if _, err := os.Stat(p); err != nil {
+			return p
+		}
+	}
+}
+
+// conflictSummary 统计已存在的本地文件的处理结果
+type conflictSummary struct {
+	skipped  []string // 跳过的文件
+	replaced []string // 覆盖的文件
+	renamed  []string // 重命名保存的文件
+	collided []string // 与其他网盘文件保存到相同的本地路径, 未下载的文件
+}
+
+func (cs *conflictSummary) add(action conflictAction, localPath string) {
+	switch action {
+	case actionSkip:
+		cs.skipped = append(cs.skipped, localPath)
+	case actionOverwrite:
+		cs.replaced = append(cs.replaced, localPath)
+	case actionRename:
+		cs.renamed = append(cs.renamed, localPath)
+	}
+}
+
+// addCollision 记录与网盘文件 other 保存到相同本地路径的网盘文件 pcsPath
+func (cs *conflictSummary) addCollision(pcsPath, other string) {
+	cs.collided = append(cs.collided, fmt.Sprintf("%s (与 %s 相同)", pcsPath, other))
+}
+
+// String 输出处理结果
+func (cs *conflictSummary) String() string {
+	builder := &strings.Builder{}
+	if len(cs.collided) > 0 {
+		fmt.Fprintf(builder, "本地路径相同未下载的文件: %d 个\n", len(cs.collided))
+		for _, p := range cs.collided {
+			fmt.Fprintf(builder, "  %s\n", p)
+		}
+	}
+	if len(cs.skipped)+len(cs.replaced)+len(cs.renamed) == 0 {
+		return builder.String()
+	}
+
+	fmt.Fprintf(builder, "本地已存在的文件: 跳过 %d 个, 覆盖 %d 个, 重命名保存 %d 个\n", len(cs.skipped), len(cs.replaced), len(cs.renamed))
+	for _, p := range cs.skipped {
+		fmt.Fprintf(builder, "  跳过: %s\n", p)
+	}
+	for _, p := range cs.replaced {
+		fmt.Fprintf(builder, "  覆盖: %s\n", p)
+	}
+	for _, p := range cs.renamed {
+		fmt.Fprintf(builder, "  重命名保存: %s\n", p)
+	}
+	return builder.String()
+}
@@ -0,0 +1,103 @@
+package pcscommand
+
+import (
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/pcspath"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"path"
+	"strings"
+)
+
+// downloadFilter 目录下载的过滤规则
+type downloadFilter struct {
+	includes  []string // 只下载匹配的文件
+	excludes  []string // 排除匹配的文件和目录
+	minSize   int64    // 文件大小下限, 0 表示不限制
+	maxSize   int64    // 文件大小上限, 0 表示不限制
+	newerThan int64    // 只下载修改时间晚于此时间的文件, unix 时间戳, 0 表示不限制
+	olderThan int64    // 只下载修改时间早于此时间的文件, unix 时间戳, 0 表示不限制
+}
+
+// newDownloadFilter 解析 DownloadOptions 中的过滤规则
+func newDownloadFilter(options *DownloadOptions) (df *downloadFilter, err error) {
+	df = &downloadFilter{
+		includes: options.Includes,
+		excludes: options.Excludes,
+	}
+
+	if options.MinSize != "" {
+		df.minSize, err = pcsutil.ParseFileSize(options.MinSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.MaxSize != "" {
+		df.maxSize, err = pcsutil.ParseFileSize(options.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.NewerThan != "" {
+		t, err := pcsutil.ParseTime(options.NewerThan)
+		if err != nil {
+			return nil, err
+		}
+		df.newerThan = t.Unix()
+	}
+	if options.OlderThan != "" {
+		t, err := pcsutil.ParseTime(options.OlderThan)
+		if err != nil {
+			return nil, err
+		}
+		df.olderThan = t.Unix()
+	}
+	return df, nil
+}
+
+// isEmpty 是否没有任何过滤规则
+func (df *downloadFilter) isEmpty() bool {
+	return len(df.includes) == 0 && len(df.excludes) == 0 && df.minSize == 0 && df.maxSize == 0 && df.newerThan == 0 && df.olderThan == 0
+}
+
+// match 检测目录 root 下的文件或目录 fd 是否需要下载,
+// 被排除的目录, 不再获取其子文件和子目录
+func (df *downloadFilter) match(root string, fd *baidupcs.FileDirectory) bool {
+	prefix := path.Clean(root)
+	if prefix != "/" {
+		prefix += "/"
+	}
+	rel := strings.TrimPrefix(fd.Path, prefix)
+
+	for _, pattern := range df.excludes {
+		if pcspath.MatchRule(pattern, rel, fd.Isdir) {
+			return false
+		}
+	}
+
+	// 以下规则只针对文件
+	if fd.Isdir {
+		return true
+	}
+
+	if len(df.includes) != 0 {
+		included := false
+		for _, pattern := range df.includes {
+			if pcspath.MatchRule(pattern, rel, false) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	switch {
+	case df.minSize > 0 && fd.Size < df.minSize,
+		df.maxSize > 0 && fd.Size > df.maxSize,
+		df.newerThan > 0 && fd.Mtime < df.newerThan,
+		df.olderThan > 0 && fd.Mtime > df.olderThan:
+		return false
+	}
+	return true
+}
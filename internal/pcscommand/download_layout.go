@@ -0,0 +1,113 @@
+package pcscommand
+
+import (
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcspath"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// downloadLayout 决定网盘路径在本地的储存位置
+type downloadLayout struct {
+	saveDir         string // 储存目录
+	relativeTo      string // 网盘路径相对于此目录保存, 为空则保存完整的网盘路径
+	stripComponents int    // 去除网盘路径开头的层数
+	flat            bool   // 所有文件直接保存到储存目录, 不建立子目录
+
+	claimed map[string]string // 本次已使用的本地路径, 值为对应的网盘路径
+}
+
+// localPath 返回网盘路径 pcsPath 的本地储存路径
+func (dl *downloadLayout) localPath(pcsPath string) string {
+	rel, _ := dl.relPath(pcsPath)
+	return pcsconfig.GetSavePath(dl.saveDir, rel)
+}
+
+// keepDir 目录 pcsPath 在本地是否有对应的目录,
+// 使用 flat 或被 stripComponents 完全去除的目录, 不在本地建立
+func (dl *downloadLayout) keepDir(pcsPath string) bool {
+	if dl.flat {
+		return false
+	}
+	_, stripped := dl.relPath(pcsPath)
+	return !stripped
+}
+
+// claim 记录网盘文件 pcsPath 使用本地路径 localPath,
+// 已被其他网盘文件使用时, 返回该网盘文件的路径
+func (dl *downloadLayout) claim(pcsPath, localPath string) (other string) {
+	if dl.claimed == nil {
+		dl.claimed = map[string]string{}
+	}
+	if other = dl.claimed[localPath]; other != "" && other != pcsPath {
+		return other
+	}
+	dl.claimed[localPath] = pcsPath
+	return ""
+}
+
+// relPath 返回 pcsPath 相对于储存目录的路径, stripped 为 stripComponents 是否去除了 pcsPath 的全部层数
+func (dl *downloadLayout) relPath(pcsPath string) (rel string, stripped bool) {
+	rel = path.Clean(pcsPath)
+
+	if dl.flat {
+		return path.Base(rel), false
+	}
+
+	if dl.relativeTo != "" {
+		prefix := path.Clean(dl.relativeTo)
+		if prefix != "/" {
+			prefix += "/"
+		}
+
+		// 不在 relativeTo 目录内的, 保存完整的网盘路径
+		if strings.HasPrefix(rel, prefix) {
+			rel = strings.TrimPrefix(rel, prefix)
+		} else if rel+"/" == prefix {
+			rel = ""
+		}
+	}
+
+	if dl.stripComponents > 0 && rel != "" {
+		elem := pcspath.SplitAll("/" + strings.TrimPrefix(rel, "/"))
+		if len(elem) > dl.stripComponents {
+			rel = strings.Join(elem[dl.stripComponents:], "")
+		} else {
+			// 层数不足, 只保留文件名
+			rel, stripped = path.Base(rel), true
+		}
+	}
+	return
+}
+
+// dirMtime 下载完成后需要设置修改时间的本地目录
+type dirMtime struct {
+	localPath string
+	mtime     int64
+}
+
+// setDirsMtime 设置本地目录的修改时间, 先设置子目录, 再设置父目录,
+// 因为在目录内写入文件会改变目录的修改时间
+func setDirsMtime(dirs []dirMtime, saveDir string) {
+	root := pcsconfig.GetSavePath(saveDir, "")
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i].localPath) > len(dirs[j].localPath)
+	})
+
+	for _, d := range dirs {
+		if d.localPath == root {
+			continue
+		}
+
+		if fi, err := os.Stat(d.localPath); err != nil || !fi.IsDir() {
+			continue
+		}
+
+		t := time.Unix(d.mtime, 0)
+		os.Chtimes(d.localPath, t, t)
+	}
+}
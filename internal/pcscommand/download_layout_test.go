@@ -0,0 +1,41 @@
+package pcscommand
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadLayout(t *testing.T) {
+	saveDir := filepath.FromSlash("/save")
+	for _, tt := range []struct {
+		layout  downloadLayout
+		pcsPath string
+		want    string
+		keepDir bool
+	}{
+		{downloadLayout{saveDir: saveDir, relativeTo: "/apps"}, "/apps/a/1.mp4", "/save/a/1.mp4", true},
+		{downloadLayout{saveDir: saveDir, relativeTo: "/apps"}, "/other/1.mp4", "/save/other/1.mp4", true},
+		{downloadLayout{saveDir: saveDir, stripComponents: 1}, "/apps/a/1.mp4", "/save/a/1.mp4", true},
+		{downloadLayout{saveDir: saveDir, stripComponents: 2}, "/apps/a", "/save/a", false},
+		{downloadLayout{saveDir: saveDir, stripComponents: 3}, "/apps/a/1.mp4", "/save/1.mp4", false},
+		{downloadLayout{saveDir: saveDir, flat: true}, "/apps/a/1.mp4", "/save/1.mp4", false},
+	} {
+		if got := tt.layout.localPath(tt.pcsPath); got != filepath.FromSlash(tt.want) {
+			t.Errorf("%+v: localPath(%s) = %s, want %s", tt.layout, tt.pcsPath, got, tt.want)
+		}
+		if got := tt.layout.keepDir(tt.pcsPath); got != tt.keepDir {
+			t.Errorf("%+v: keepDir(%s) = %v, want %v", tt.layout, tt.pcsPath, got, tt.keepDir)
+		}
+	}
+
+	dl := &downloadLayout{saveDir: saveDir, flat: true}
+	if other := dl.claim("/a/1.mp4", dl.localPath("/a/1.mp4")); other != "" {
+		t.Errorf("claim: got %s, want empty", other)
+	}
+	if other := dl.claim("/a/1.mp4", dl.localPath("/a/1.mp4")); other != "" {
+		t.Errorf("claim again: got %s, want empty", other)
+	}
+	if other := dl.claim("/b/1.mp4", dl.localPath("/b/1.mp4")); other != "/a/1.mp4" {
+		t.Errorf("claim collision: got %s, want /a/1.mp4", other)
+	}
+}
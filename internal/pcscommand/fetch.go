@@ -0,0 +1,199 @@
+package pcscommand
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/downloader"
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/iikira/BaiduPCS-Go/requester"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ftask 网址下载任务
+type ftask struct {
+	ListTask
+	url string
+}
+
+// FetchOptions 网址下载可选参数
+type FetchOptions struct {
+	Parallel        int      // 最大下载并发量
+	IsFixedParallel bool     // 固定线程数, 不自适应调整
+	Output          string   // 保存的文件路径, 多个网址时为保存的目录
+	Headers         []string // 附加的请求头, 格式为 "Key: Value"
+	CookieFile      string   // Netscape 格式的 cookie 文件
+	Progress        string   // 进度的输出方式, text, json
+}
+
+// parseHeaders 解析 "Key: Value" 格式的请求头
+func parseHeaders(headers []string) (map[string]string, error) {
+	h := make(map[string]string, len(headers))
+	for _, header := range headers {
+		i := strings.Index(header, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("请求头格式错误: %s, 应为 \"Key: Value\"", header)
+		}
+		h[strings.TrimSpace(header[:i])] = strings.TrimSpace(header[i+1:])
+	}
+	return h, nil
+}
+
+// RunFetch 执行多线程下载网址, 支持断点续传
+func RunFetch(urls []string, options *FetchOptions) {
+	if options == nil {
+		options = &FetchOptions{}
+	}
+
+	header, err := parseHeaders(options.Headers)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	jar, _ := cookiejar.New(nil)
+	if options.CookieFile != "" {
+		err = requester.LoadCookieFile(jar, options.CookieFile)
+		if err != nil {
+			fmt.Printf("载入 cookie 文件错误, %s\n", err)
+			return
+		}
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	cfg := &downloader.Config{
+		FixedParallel: options.IsFixedParallel,
+		Parallel:      options.Parallel,
+		CacheSize:     pcsconfig.Config.CacheSize,
+		Header:        header,
+	}
+	if cfg.Parallel == 0 {
+		cfg.Parallel = pcsconfig.Config.MaxParallel
+	}
+
+	// 多个网址, 或者 Output 为目录时, Output 作为保存的目录
+	var savePath, saveDir string
+	if options.Output != "" {
+		fi, serr := os.Stat(options.Output)
+		if len(urls) > 1 || strings.HasSuffix(options.Output, "/") || (serr == nil && fi.IsDir()) {
+			saveDir = options.Output
+		} else {
+			savePath = options.Output
+		}
+	}
+
+	flist := list.New()
+	for k := range urls {
+		flist.PushBack(&ftask{
+			ListTask: ListTask{
+				ID:       k + 1,
+				MaxRetry: 3,
+			},
+			url: urls[k],
+		})
+		progress.printf("[%d] 加入下载队列: %s\n", k+1, urls[k])
+	}
+
+	var totalSize int64
+	for e := flist.Front(); e != nil; e = flist.Front() {
+		flist.Remove(e) // 载入任务后, 移除队列
+		task := e.Value.(*ftask)
+
+		taskCfg := *cfg
+		taskCfg.SavePath = savePath
+		taskCfg.SaveDir = saveDir
+
+		size, err := fetchURL(task.ID, task.url, &taskCfg, jar, progress)
+		if err == nil {
+			totalSize += size
+			continue
+		}
+
+		// 文件已存在, 不重试
+		if strings.Contains(err.Error(), "文件已存在") {
+			progress.printf("[%d] 下载文件错误, %s\n", task.ID, err)
+			progress.emitTask(pcsevent.Failed, pcsevent.KindDownload, &task.ListTask, task.url, "", err)
+			continue
+		}
+
+		progress.printf("[%d] 下载文件错误, %s, 重试 %d/%d\n", task.ID, err, task.retry, task.MaxRetry)
+
+		// 未达到失败重试最大次数, 将任务推送到队列末尾
+		if task.retry < task.MaxRetry {
+			task.retry++
+			flist.PushBack(task)
+			progress.emitTask(pcsevent.Retry, pcsevent.KindDownload, &task.ListTask, task.url, "", err)
+			time.Sleep(3 * time.Duration(task.retry) * time.Second)
+		} else {
+			progress.emitTask(pcsevent.Failed, pcsevent.KindDownload, &task.ListTask, task.url, "", err)
+		}
+	}
+
+	progress.printf("任务结束, 数据总量: %s\n", pcsutil.ConvertFileSize(totalSize))
+}
+
+// fetchURL 下载网址 u, 返回下载的数据量
+func fetchURL(id int, u string, cfg *downloader.Config, jar *cookiejar.Jar, progress *progressOutput) (size int64, err error) {
+	h := requester.NewHTTPClient()
+	if pcsconfig.Config.UserAgent != "" {
+		h.UserAgent = pcsconfig.Config.UserAgent
+	}
+	h.SetCookiejar(jar)
+	h.SetKeepAlive(true)
+	h.SetTimeout(10 * time.Minute)
+	cfg.Client = h
+
+	if cfg.SavePath == "" && cfg.SaveDir != "" {
+		if err = os.MkdirAll(cfg.SaveDir, 0777); err != nil {
+			return 0, err
+		}
+	}
+
+	download, err := downloader.NewDownloader(u, *cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	savePath := download.Config.SavePath
+	if abs, aerr := filepath.Abs(savePath); aerr == nil {
+		savePath = abs
+	}
+	progress.subscribe(&download.Events, id, u, savePath)
+
+	var ferr error
+	download.Events.Subscribe(func(e *pcsevent.Event) {
+		switch e.Type {
+		case pcsevent.Finished:
+			size = e.Transferred
+		case pcsevent.Failed:
+			ferr = fmt.Errorf("%s", e.Error)
+		}
+	})
+
+	progress.printf("[%d] 开始下载: %s\n", id, u)
+	done, err := download.Execute()
+	if err != nil {
+		return 0, err
+	}
+	<-done
+
+	if ferr != nil {
+		return 0, ferr
+	}
+
+	msg := fmt.Sprintf("[%d] 下载完成, 保存位置: %s\n", id, savePath)
+	pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+	progress.printf("%s", msg)
+	return size, nil
+}
@@ -0,0 +1,27 @@
+package pcscommand
+
+import (
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/pcscache"
+)
+
+// RunHashCachePrune 删除文件已不存在或已改变的摘要值缓存
+func RunHashCachePrune() {
+	total := pcscache.HashCache.Len()
+	removed, err := pcscache.HashCache.Prune()
+	if err != nil {
+		fmt.Printf("清理摘要值缓存失败, %s\n", err)
+		return
+	}
+	fmt.Printf("清理摘要值缓存完成, 删除 %d 条, 剩余 %d 条\n", removed, total-removed)
+}
+
+// RunHashCacheClear 清空摘要值缓存
+func RunHashCacheClear() {
+	err := pcscache.HashCache.Clear()
+	if err != nil {
+		fmt.Printf("清空摘要值缓存失败, %s\n", err)
+		return
+	}
+	fmt.Printf("已清空摘要值缓存\n")
+}
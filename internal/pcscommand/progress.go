@@ -0,0 +1,307 @@
+package pcscommand
+
+import (
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/json-iterator/go"
+	"github.com/mattn/go-runewidth"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// PlainProgressInterval 非终端环境下, 输出进度的间隔
+	PlainProgressInterval = 10 * time.Second
+)
+
+// progressMode 传输进度的输出方式
+type progressMode int
+
+const (
+	progressPlain progressMode = iota // 定期输出一行进度
+	progressTTY                       // 终端下, 每个传输任务一行, 原地刷新
+	progressJSON                      // 每行输出一个 json 格式的事件
+)
+
+// transferState 传输任务的进度
+type transferState struct {
+	id        int
+	kind      string
+	path      string
+	event     pcsevent.Event // 最近一次的事件
+	lastPrint time.Time      // 上次输出进度的时间
+}
+
+// progressOutput 输出传输进度
+type progressOutput struct {
+	mode  progressMode
+	w     io.Writer // 输出进度, json 事件
+	width int       // 终端宽度
+
+	transfers  map[int]*transferState // 进行中的传输任务
+	finished   int                    // 已结束的传输任务数
+	finishedDL int64                  // 已结束的下载任务的数据量
+	finishedUL int64                  // 已结束的上传任务的数据量
+	drawnLines int                    // 终端下已绘制的行数
+	mu         sync.Mutex
+}
+
+// newProgressOutput 解析 --progress 参数.
+// text: 标准输出为终端时, 原地刷新进度, 否则定期输出一行进度;
+// json: 每行输出一个 json 格式的事件到标准输出, 其他提示信息改为输出到标准错误
+func newProgressOutput(mode string) (*progressOutput, error) {
+	po := &progressOutput{
+		w:         os.Stdout,
+		width:     80,
+		transfers: map[int]*transferState{},
+	}
+
+	switch mode {
+	case "", "text":
+		if pcsutil.IsTerminal(os.Stdout) && !pcsutil.PipeInput {
+			po.mode = progressTTY
+		}
+	case "json":
+		po.mode = progressJSON
+		return po, nil
+	default:
+		return nil, fmt.Errorf("未知的进度输出方式: %s, 可选: text, json", mode)
+	}
+
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 20 {
+		po.width = columns
+	}
+	return po, nil
+}
+
+// subscribe 订阅任务 taskID 的传输事件, path 为网盘路径, localPath 为本地路径
+func (po *progressOutput) subscribe(em *pcsevent.Emitter, taskID int, path, localPath string) {
+	em.Subscribe(func(e *pcsevent.Event) {
+		ev := *e
+		ev.TaskID = taskID
+		ev.Path = path
+		if ev.LocalPath == "" {
+			ev.LocalPath = localPath
+		}
+		po.handle(&ev)
+	})
+}
+
+// handle 处理传输事件
+func (po *progressOutput) handle(e *pcsevent.Event) {
+	po.mu.Lock()
+	defer po.mu.Unlock()
+
+	if po.mode == progressJSON {
+		po.writeJSON(e)
+		return
+	}
+
+	ts := po.transfers[e.TaskID]
+	switch e.Type {
+	case pcsevent.Started:
+		po.transfers[e.TaskID] = &transferState{
+			id:        e.TaskID,
+			kind:      e.Kind,
+			path:      e.Path,
+			event:     *e,
+			lastPrint: time.Now(),
+		}
+	case pcsevent.Progress, pcsevent.Paused, pcsevent.Resumed, pcsevent.Retry:
+		if ts == nil {
+			return
+		}
+		ts.event = *e
+		if po.mode == progressPlain && e.Type == pcsevent.Progress && time.Since(ts.lastPrint) >= PlainProgressInterval {
+			ts.lastPrint = time.Now()
+			fmt.Fprintln(po.w, po.formatTransfer(ts))
+		}
+	case pcsevent.Finished, pcsevent.Failed:
+		if ts == nil {
+			return
+		}
+		delete(po.transfers, e.TaskID)
+		po.finished++
+		if e.Kind == pcsevent.KindUpload {
+			po.finishedUL += e.Transferred
+		} else {
+			po.finishedDL += e.Transferred
+		}
+	}
+
+	if po.mode == progressTTY {
+		po.redraw()
+	}
+}
+
+// emitTask 输出任务级别的事件, 如任务重试, 失败
+func (po *progressOutput) emitTask(typ pcsevent.Type, kind string, task *ListTask, path, localPath string, err error) {
+	if po.mode != progressJSON {
+		return
+	}
+
+	e := &pcsevent.Event{
+		Type:      typ,
+		Kind:      kind,
+		Time:      time.Now(),
+		TaskID:    task.ID,
+		Path:      path,
+		LocalPath: localPath,
+		Attempt:   task.retry,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	po.mu.Lock()
+	po.writeJSON(e)
+	po.mu.Unlock()
+}
+
+func (po *progressOutput) writeJSON(e *pcsevent.Event) {
+	byt, err := jsoniter.Marshal(e)
+	if err != nil {
+		return
+	}
+	po.w.Write(append(byt, '\n'))
+}
+
+// printf 输出提示信息, 终端下先清除进度, 输出后重新绘制,
+// json 模式下输出到标准错误, 标准输出只有 json 事件
+func (po *progressOutput) printf(format string, a ...interface{}) {
+	po.mu.Lock()
+	defer po.mu.Unlock()
+
+	switch po.mode {
+	case progressTTY:
+		po.clear()
+		fmt.Fprintf(po.w, format, a...)
+		po.redraw()
+	case progressJSON:
+		fmt.Fprintf(os.Stderr, format, a...)
+	default:
+		fmt.Printf(format, a...)
+	}
+}
+
+// clear 清除已绘制的进度
+func (po *progressOutput) clear() {
+	if po.drawnLines == 0 {
+		return
+	}
+	fmt.Fprintf(po.w, "\x1b[%dA\x1b[J", po.drawnLines)
+	po.drawnLines = 0
+}
+
+// redraw 重新绘制进度, 每个传输任务一行, 最后一行为总计
+func (po *progressOutput) redraw() {
+	po.clear()
+	if len(po.transfers) == 0 {
+		return
+	}
+
+	ids := make([]int, 0, len(po.transfers))
+	for id := range po.transfers {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	var (
+		builder = &strings.Builder{}
+		dl, ul  = po.finishedDL, po.finishedUL
+		speeds  int64
+	)
+	for _, id := range ids {
+		ts := po.transfers[id]
+		builder.WriteString(runewidth.Truncate(po.formatTransfer(ts), po.width-1, "..."))
+		builder.WriteByte('\n')
+
+		if ts.kind == pcsevent.KindUpload {
+			ul += ts.event.Transferred
+		} else {
+			dl += ts.event.Transferred
+		}
+		speeds += ts.event.Speed
+	}
+
+	totals := fmt.Sprintf("总计: 进行中 %d, 已结束 %d, ↓ %s, ↑ %s, %s/s",
+		len(po.transfers), po.finished,
+		pcsutil.ConvertFileSize(dl, 2),
+		pcsutil.ConvertFileSize(ul, 2),
+		pcsutil.ConvertFileSize(speeds, 2),
+	)
+	builder.WriteString(runewidth.Truncate(totals, po.width-1, "..."))
+	builder.WriteByte('\n')
+
+	io.WriteString(po.w, builder.String())
+	po.drawnLines = len(ids) + 1
+}
+
+// formatTransfer 格式化传输任务的进度,
+// 例如: [1] ↓ 45.20% 12.30MB/27.20MB 2.10MB/s (平均 1.90MB/s) 剩余 7s /我的资源/1.mp4
+func (po *progressOutput) formatTransfer(ts *transferState) string {
+	e := &ts.event
+
+	arrow := "↓"
+	if ts.kind == pcsevent.KindUpload {
+		arrow = "↑"
+	}
+
+	var (
+		percent string
+		avg     int64
+		eta     = "-"
+	)
+	if e.Total > 0 {
+		percent = fmt.Sprintf(" %.2f%%", float64(e.Transferred)*100/float64(e.Total))
+	}
+	if seconds := e.Elapsed.Seconds(); seconds > 0 {
+		avg = int64(float64(e.Transferred) / seconds)
+	}
+	if left := e.Total - e.Transferred; e.Total > 0 && left >= 0 {
+		speed := e.Speed
+		if speed <= 0 {
+			speed = avg
+		}
+		if speed > 0 {
+			eta = (time.Duration(left/speed) * time.Second).String()
+		}
+	}
+
+	state := ""
+	switch e.Type {
+	case pcsevent.Paused:
+		state = " [暂停]"
+	case pcsevent.Retry:
+		state = " [重试]"
+	}
+
+	total := pcsutil.ConvertFileSize(e.Total, 2)
+	if e.Total <= 0 && e.Transferred > 0 { // 数据流上传, 总大小未知
+		total = "?"
+	}
+
+	return fmt.Sprintf("[%d] %s%s %s/%s %s/s (平均 %s/s) 剩余 %s%s %s", ts.id, arrow, percent,
+		pcsutil.ConvertFileSize(e.Transferred, 2),
+		total,
+		pcsutil.ConvertFileSize(e.Speed, 2),
+		pcsutil.ConvertFileSize(avg, 2),
+		eta, state, ts.path,
+	)
+}
+
+// close 清除进度
+func (po *progressOutput) close() {
+	po.mu.Lock()
+	defer po.mu.Unlock()
+
+	if po.mode == progressTTY {
+		po.clear()
+	}
+}
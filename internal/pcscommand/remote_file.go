@@ -0,0 +1,65 @@
+package pcscommand
+
+import (
+	"bytes"
+	"errors"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/requester"
+	"github.com/iikira/BaiduPCS-Go/uploader"
+	"io"
+	"io/ioutil"
+	"net/http/cookiejar"
+)
+
+// pcsFileOperator 读写网盘小文件所需的操作, 由 *baidupcs.BaiduPCS 和 *cryptPCS 实现
+type pcsFileOperator interface {
+	DownloadFile(path string, downloadFunc baidupcs.DownloadFunc) error
+	UploadCreateSuperFile(targetPath string, blockList ...string) (*baidupcs.UploadedFile, error)
+}
+
+// uploadBytes 上传内存中的数据到网盘路径 savePath, 用于元数据等小文件
+func uploadBytes(pcs pcsFileOperator, savePath string, data []byte) error {
+	u := uploader.NewStreamUploader(bytes.NewReader(data), minUploadBlockSize, 1, &uploader.Options{
+		IsMultiPart: true,
+		Client:      requester.NewHTTPClient(),
+	})
+	u.OnExecute(func() {
+		for range u.UploadStatus {
+		}
+	})
+
+	blockIDs, err := executeUploadBlocks(u)
+	if err != nil {
+		return err
+	}
+
+	uploaded, err := pcs.UploadCreateSuperFile(savePath, blockIDs...)
+	if err != nil {
+		return err
+	}
+	return uploader.VerifySize(int64(len(data)), uploaded.Size)
+}
+
+// downloadBytes 下载网盘文件到内存, 最多读取 maxSize
+func downloadBytes(pcs pcsFileOperator, path string, maxSize int64) (data []byte, err error) {
+	err = pcs.DownloadFile(path, func(downloadURL string, jar *cookiejar.Jar) error {
+		h := requester.NewHTTPClient()
+		h.UserAgent = pcsconfig.Config.UserAgent
+		h.SetCookiejar(jar)
+
+		resp, err := h.Req("GET", downloadURL, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode/100 != 2 {
+			return errors.New(resp.Status)
+		}
+
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxSize))
+		return err
+	})
+	return
+}
@@ -0,0 +1,268 @@
+package pcscommand
+
+import (
+	"crypto/md5"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+const (
+	// sumBufSize 计算摘要值时每次读取的数据量
+	sumBufSize = 4 * pcsutil.MB
+)
+
+var (
+	sumBufPool = sync.Pool{
+		New: func() interface{} {
+			return make([]byte, sumBufSize)
+		},
+	}
+)
+
+// sum 读取一次文件, 同时计算 md5, 前 requiredSliceLen 切片的 md5, crc32
+func (lp *LocalPathInfo) sum(opt SumOption) error {
+	if lp.file == nil {
+		return nil
+	}
+
+	var (
+		md5w   hash.Hash
+		slicew hash.Hash
+		crc32w hash.Hash32
+		sliced int64 // 已计算切片 md5 的数据量
+	)
+	if opt.IsMD5Sum {
+		md5w = md5.New()
+	}
+	if opt.IsSliceMD5Sum {
+		slicew = md5.New()
+	}
+	if opt.IsCRC32Sum {
+		crc32w = crc32.NewIEEE()
+	}
+
+	buf := sumBufPool.Get().([]byte)
+	defer sumBufPool.Put(buf)
+
+	for offset := int64(0); ; {
+		n, err := lp.file.ReadAt(buf, offset)
+		if n > 0 {
+			data := buf[:n]
+			if md5w != nil {
+				md5w.Write(data)
+			}
+			if crc32w != nil {
+				crc32w.Write(data)
+			}
+			if slicew != nil && sliced < requiredSliceLen {
+				left := requiredSliceLen - sliced
+				if int64(n) < left {
+					left = int64(n)
+				}
+				slicew.Write(data[:left])
+				sliced += left
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// 只计算切片 md5 时, 不再读取剩余的数据
+		if md5w == nil && crc32w == nil && sliced >= requiredSliceLen {
+			break
+		}
+	}
+
+	if md5w != nil {
+		lp.MD5 = md5w.Sum(nil)
+	}
+	if slicew != nil {
+		lp.SliceMD5 = slicew.Sum(nil)
+	}
+	if crc32w != nil {
+		lp.CRC32 = crc32w.Sum32()
+	}
+	return nil
+}
+
+// sumJob 计算单个文件摘要值的任务
+type sumJob struct {
+	localPath string
+	opt       SumOption
+	lp        *LocalPathInfo
+	err       error
+	failed    bool   // 提交前已失败, 不需计算
+	release   func() // 取出结果后, 允许提交后面的任务
+	done      chan struct{}
+}
+
+// wait 等待计算完成, 取出结果, 只能由一个 goroutine 调用
+func (job *sumJob) wait() (*LocalPathInfo, error) {
+	<-job.done
+	if job.release != nil {
+		job.release()
+		job.release = nil
+	}
+	return job.lp, job.err
+}
+
+// isDone 是否已计算完成
+func (job *sumJob) isDone() bool {
+	select {
+	case <-job.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// sumPool 并发计算多个文件的摘要值
+type sumPool struct {
+	parallel int
+	jobs     chan *sumJob
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// defaultSumParallel 默认同时计算摘要值的文件数
+func defaultSumParallel() int {
+	n := runtime.NumCPU()
+	if n > 4 {
+		n = 4
+	}
+	return n
+}
+
+// newSumPool 启动 parallel 个计算摘要值的 goroutine, 结束时需调用 close
+func newSumPool(parallel int) *sumPool {
+	if parallel <= 0 {
+		parallel = defaultSumParallel()
+	}
+	sp := &sumPool{
+		parallel: parallel,
+		jobs:     make(chan *sumJob),
+		stop:     make(chan struct{}),
+	}
+	sp.wg.Add(parallel)
+	for i := 0; i < parallel; i++ {
+		go func() {
+			defer sp.wg.Done()
+			for {
+				select {
+				case job := <-sp.jobs:
+					job.lp, job.err = GetFileSum(job.localPath, &job.opt)
+					close(job.done)
+				case <-sp.stop:
+					return
+				}
+			}
+		}()
+	}
+	return sp
+}
+
+// submitAll 按顺序提交计算任务, 最多提前 ahead 个未取出结果的任务, ahead 为 0 表示不限制
+func (sp *sumPool) submitAll(jobs []*sumJob, ahead int) {
+	var tokens chan struct{}
+	if ahead > 0 {
+		tokens = make(chan struct{}, ahead)
+		for _, job := range jobs {
+			if !job.failed {
+				job.release = func() { <-tokens }
+			}
+		}
+	}
+
+	go func() {
+		for _, job := range jobs {
+			if job.failed {
+				continue
+			}
+			if tokens != nil {
+				select {
+				case tokens <- struct{}{}:
+				case <-sp.stop:
+					return
+				}
+			}
+			select {
+			case sp.jobs <- job:
+			case <-sp.stop:
+				return
+			}
+		}
+	}()
+}
+
+// close 不再提交新的任务, 等待进行中的任务结束, 未开始的任务不再计算
+func (sp *sumPool) close() {
+	close(sp.stop)
+	sp.wg.Wait()
+}
+
+// newSumJob 返回计算 localPath 摘要值的任务
+func newSumJob(localPath string, opt SumOption) *sumJob {
+	return &sumJob{
+		localPath: localPath,
+		opt:       opt,
+		done:      make(chan struct{}),
+	}
+}
+
+// failedSumJob 返回已失败的任务, 用于按顺序输出错误
+func failedSumJob(localPath string, err error) *sumJob {
+	job := newSumJob(localPath, SumOption{})
+	job.err, job.failed = err, true
+	close(job.done)
+	return job
+}
+
+// SumFilesOptions 计算多个文件摘要值的可选参数
+type SumFilesOptions struct {
+	Recursive bool // 递归计算目录下的文件
+	Parallel  int  // 同时计算的文件数, 0 为默认值
+}
+
+// SumFiles 并发计算多个本地文件的摘要值, 按 localPaths 的顺序调用 fn,
+// Recursive 时, 目录展开为其下的所有文件
+func SumFiles(localPaths []string, opt *SumOption, options *SumFilesOptions, fn func(localPath string, lp *LocalPathInfo, err error)) {
+	if options == nil {
+		options = &SumFilesOptions{}
+	}
+
+	var jobs []*sumJob
+	for _, localPath := range localPaths {
+		fi, err := os.Stat(localPath)
+		if err == nil && fi.IsDir() && options.Recursive {
+			files, err := pcsutil.WalkDir(localPath, "")
+			if err != nil {
+				jobs = append(jobs, failedSumJob(localPath, err))
+				continue
+			}
+			for _, file := range files {
+				jobs = append(jobs, newSumJob(file, *opt))
+			}
+			continue
+		}
+		jobs = append(jobs, newSumJob(localPath, *opt))
+	}
+
+	sp := newSumPool(options.Parallel)
+	defer sp.close()
+	sp.submitAll(jobs, 2*sp.parallel)
+
+	for _, job := range jobs {
+		lp, err := job.wait()
+		fn(filepath.Clean(job.localPath), lp, err)
+	}
+}
@@ -0,0 +1,248 @@
+package pcscommand
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/downloader"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// syncTrashDir sync --delete 删除的网盘文件, 移动到网盘同步目录下的此目录, 按时间分目录保存
+	syncTrashDir = ".pcstrash"
+
+	// syncTempSuffix 同步时下载的临时文件的后缀, 下载完成后重命名
+	syncTempSuffix = ".pcssync"
+
+	// errCodeRemoteNotExist 网盘文件或目录不存在的错误代码
+	errCodeRemoteNotExist = 31066
+)
+
+// SyncOptions 同步可选参数
+type SyncOptions struct {
+	UploadOptions // 遍历本地目录的规则, 计算摘要值的选项, 进度的输出方式
+
+	Delete    bool // 删除目标中多余的文件
+	DryRun    bool // 只输出要执行的操作, 不做任何修改
+	Checksum  bool // sync pull: 大小相同时比较 md5, 而不是修改时间
+	MaxDelete int  // sync pull, bisync: 要删除的文件超过此数量时中止, 0 为不限制
+
+	Conflict string // sync bisync: 两边都修改的文件的处理方式, newer, keep-both, prompt
+}
+
+// syncLocalFile 本地文件
+type syncLocalFile struct {
+	path  string // 本地路径
+	size  int64
+	mtime int64
+}
+
+// syncLocalTree 本地目录树, 以相对于同步目录的路径 (以 / 分隔) 为键
+type syncLocalTree struct {
+	files     map[string]*syncLocalFile
+	dirs      map[string]bool // 所有目录, 包括空目录
+	emptyDirs []string        // 空目录的相对路径
+}
+
+// walkSyncLocal 遍历本地目录 root, 使用 walker 的过滤规则, 警告通过 progress 输出
+func walkSyncLocal(root string, walker *uploadWalker, progress *progressOutput) (*syncLocalTree, error) {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s 不是目录", root)
+	}
+
+	walker.files, walker.emptyDirs = nil, nil
+	err = walker.walk(root)
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &syncLocalTree{
+		files: map[string]*syncLocalFile{},
+		dirs:  map[string]bool{},
+	}
+	addDirs := func(rel string) {
+		for ; rel != "."; rel = path.Dir(rel) {
+			tree.dirs[rel] = true
+		}
+	}
+
+	for _, name := range walker.files {
+		rel := syncRelPath(root, name)
+		// 跳过回收站和未完成的下载
+		if isSyncTrash(rel) || strings.HasSuffix(rel, syncTempSuffix) || strings.HasSuffix(rel, syncTempSuffix+downloader.DownloadingFileSuffix) {
+			continue
+		}
+
+		fi, err := os.Stat(name)
+		if err != nil {
+			progress.printf("警告: %s\n", err)
+			continue
+		}
+		tree.files[rel] = &syncLocalFile{
+			path:  name,
+			size:  fi.Size(),
+			mtime: fi.ModTime().Unix(),
+		}
+		addDirs(path.Dir(rel))
+	}
+	for _, name := range walker.emptyDirs {
+		rel := syncRelPath(root, name)
+		if isSyncTrash(rel) {
+			continue
+		}
+		tree.emptyDirs = append(tree.emptyDirs, rel)
+		addDirs(rel)
+	}
+	sort.Strings(tree.emptyDirs)
+	return tree, nil
+}
+
+// isSyncTrash rel 是否位于回收站中, 回收站不参与同步
+func isSyncTrash(rel string) bool {
+	return rel == syncTrashDir || strings.HasPrefix(rel, syncTrashDir+"/")
+}
+
+// syncLocalExists 本地目录 root 下是否存在 rel
+func syncLocalExists(root, rel string) bool {
+	_, err := os.Lstat(filepath.Join(root, filepath.FromSlash(rel)))
+	return err == nil
+}
+
+// syncRelPath 返回 name 相对于 root 的路径, 以 / 分隔
+func syncRelPath(root, name string) string {
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		rel = name
+	}
+	return filepath.ToSlash(rel)
+}
+
+// walkSyncRemote 逐层获取网盘目录 root 下的文件和目录, 以相对路径为键, 跳过 walker 排除的,
+// 获取任一目录失败都返回错误, 防止误判为文件已删除, root 不存在时, 可用 isRemoteNotExist 检测
+func walkSyncRemote(root string, walker *uploadWalker) (map[string]*baidupcs.FileDirectory, error) {
+	fd, err := info.FilesDirectoriesMeta(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fd.Isdir {
+		return nil, fmt.Errorf("网盘路径 %s 不是目录", root)
+	}
+
+	var (
+		tree   = map[string]*baidupcs.FileDirectory{}
+		prefix = strings.TrimSuffix(root, "/") + "/"
+		dirs   = []string{root}
+	)
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		fileList, err := info.FilesDirectoriesList(dir, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, fd := range fileList {
+			rel := strings.TrimPrefix(fd.Path, prefix)
+			if isSyncTrash(rel) || walker.excluded(rel, fd.Isdir) {
+				continue
+			}
+			tree[rel] = fd
+			if fd.Isdir {
+				dirs = append(dirs, fd.Path)
+			}
+		}
+	}
+	return tree, nil
+}
+
+// isRemoteNotExist 是否为网盘文件或目录不存在的错误
+func isRemoteNotExist(err error) bool {
+	errInfo, ok := err.(*baidupcs.ErrInfo)
+	return ok && errInfo.ErrType == baidupcs.ErrTypeRemoteError && errInfo.ErrCode == errCodeRemoteNotExist
+}
+
+// sortedKeys 返回按路径排序的相对路径
+func sortedKeys(tree map[string]*baidupcs.FileDirectory) []string {
+	keys := make([]string, 0, len(tree))
+	for rel := range tree {
+		keys = append(keys, rel)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// underAny rel 是否为 prefixes 中的路径, 或位于其中的目录下
+func underAny(rel string, prefixes map[string]bool) bool {
+	for ; rel != "."; rel = path.Dir(rel) {
+		if prefixes[rel] {
+			return true
+		}
+	}
+	return false
+}
+
+// moveToSyncTrash 将网盘同步目录 root 下的 rels 移动到回收站 <root>/.pcstrash/<时间>/,
+// 保留目录结构, 返回移动失败的路径
+func moveToSyncTrash(root string, rels []string, progress *progressOutput) (failed []string) {
+	trash := path.Join(root, syncTrashDir, time.Now().Format("20060102-150405"))
+	created := map[string]bool{}
+	for _, rel := range rels {
+		to := path.Join(trash, rel)
+		// 目录已存在时创建失败, 忽略错误, 移动失败时再报错
+		if dir := path.Dir(to); !created[dir] {
+			info.Mkdir(dir)
+			created[dir] = true
+		}
+
+		err := info.Move(&baidupcs.CpMvJSON{
+			From: path.Join(root, rel),
+			To:   to,
+		})
+		if err != nil {
+			msg := fmt.Sprintf("删除 %s 失败, %s\n", rel, err)
+			progress.printf("%s", msg)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			failed = append(failed, rel)
+			continue
+		}
+		msg := fmt.Sprintf("删除: %s, 移动到 %s\n", rel, to)
+		progress.printf("%s", msg)
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+	}
+	return
+}
+
+// syncMD5Changed 比较本地文件和网盘文件的 md5, 本地文件的 md5 使用摘要值缓存
+func syncMD5Changed(lf *syncLocalFile, fd *baidupcs.FileDirectory, rehash bool, progress *progressOutput) bool {
+	lp, err := GetFileSum(lf.path, &SumOption{
+		IsMD5Sum: true,
+		Rehash:   rehash,
+	})
+	if err != nil {
+		progress.printf("警告: 计算 %s 的 md5 失败, %s\n", lf.path, err)
+		return true
+	}
+	return hex.EncodeToString(lp.MD5) != strings.ToLower(fd.MD5)
+}
+
+// syncSummary 同步的统计
+type syncSummary struct {
+	added, updated, deleted, unchanged, skipped, failed int
+	size                                                int64 // 传输的数据量
+}
+
+func (ss *syncSummary) String() string {
+	return fmt.Sprintf("新增 %d, 更新 %d, 删除 %d, 未改变 %d, 跳过 %d, 失败 %d, 传输 %s",
+		ss.added, ss.updated, ss.deleted, ss.unchanged, ss.skipped, ss.failed, pcsutil.ConvertFileSize(ss.size))
+}
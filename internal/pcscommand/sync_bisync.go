@@ -0,0 +1,569 @@
+package pcscommand
+
+import (
+	"container/list"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/json-iterator/go"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bisyncPolicy 双向同步时, 两边都修改的文件的处理方式
+type bisyncPolicy int
+
+const (
+	// bisyncNewer 保留修改时间较新的
+	bisyncNewer bisyncPolicy = iota
+	// bisyncBoth 保留两者, 本地的版本加上后缀
+	bisyncBoth
+	// bisyncPrompt 询问
+	bisyncPrompt
+)
+
+// parseBisyncPolicy 解析 --conflict 参数
+func parseBisyncPolicy(s string) (bisyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "newer":
+		return bisyncNewer, nil
+	case "keep-both":
+		return bisyncBoth, nil
+	case "prompt":
+		return bisyncPrompt, nil
+	}
+	return bisyncNewer, fmt.Errorf("未知的冲突处理方式: %s, 可选: newer, keep-both, prompt", s)
+}
+
+// bisyncAction 双向同步对单个文件执行的操作
+type bisyncAction int
+
+const (
+	bisyncNone         bisyncAction = iota
+	bisyncUpload                    // 上传本地文件
+	bisyncDownload                  // 下载网盘文件
+	bisyncDeleteLocal               // 删除本地文件
+	bisyncDeleteRemote              // 将网盘文件移动到回收站
+	bisyncKeepBoth                  // 本地文件加上后缀, 再下载网盘文件, 两者都上传和保留
+	bisyncRecord                    // 两边相同, 只记录状态
+)
+
+// bisyncState 双向同步的状态, 记录上次同步后各文件在本地和网盘的版本,
+// 用于区分新增, 删除和修改的文件
+type bisyncState struct {
+	Local  string                       `json:"local"`
+	Remote string                       `json:"remote"`
+	Files  map[string]*bisyncStateEntry `json:"files"` // 以相对路径为键
+}
+
+// bisyncStateEntry 上次同步后文件的版本
+type bisyncStateEntry struct {
+	Size        int64 `json:"size"`
+	LocalMtime  int64 `json:"local_mtime"`
+	RemoteMtime int64 `json:"remote_mtime"`
+}
+
+// bisyncStatePath 返回本地目录和网盘目录对应的状态文件的路径, 不同帐号分开保存
+func bisyncStatePath(localDir, remoteDir string) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d\n%s\n%s", pcsconfig.Config.BaiduActiveUID, localDir, remoteDir)))
+	return pcsutil.ExecutableUserJoin(filepath.Join("pcs_bisync", hex.EncodeToString(sum[:8])+".json"))
+}
+
+// loadBisyncState 读取状态文件, 文件不存在时, 返回空的状态, first 为 true
+func loadBisyncState(filename string) (bs *bisyncState, first bool, err error) {
+	bs = &bisyncState{
+		Files: map[string]*bisyncStateEntry{},
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bs, true, nil
+		}
+		return nil, false, err
+	}
+	err = jsoniter.Unmarshal(data, bs)
+	if err != nil {
+		return nil, false, fmt.Errorf("解析同步状态文件 %s 失败, %s", filename, err)
+	}
+	if bs.Files == nil {
+		bs.Files = map[string]*bisyncStateEntry{}
+	}
+	return bs, false, nil
+}
+
+// save 保存状态文件, 先写入临时文件再替换
+func (bs *bisyncState) save(filename string) error {
+	data, err := jsoniter.Marshal(bs)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filename+".tmp", data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// bisyncPlan 双向同步要执行的操作
+type bisyncPlan struct {
+	rel    string
+	action bisyncAction
+	local  *syncLocalFile
+	remote *baidupcs.FileDirectory
+}
+
+// RunSyncBisync 双向同步本地目录 localDir 和网盘目录 remoteDir,
+// 根据上次同步的状态, 判断各文件在哪一边新增, 删除或修改, 两边都修改时按 options.Conflict 处理
+func RunSyncBisync(localDir, remoteDir string, options *SyncOptions) {
+	if options == nil {
+		options = &SyncOptions{}
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	policy, err := parseBisyncPolicy(options.Conflict)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	remoteDir, err = getAbsPath(remoteDir)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+	localDir, err = filepath.Abs(localDir)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	stateFile := bisyncStatePath(localDir, remoteDir)
+	state, first, err := loadBisyncState(stateFile)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+	state.Local, state.Remote = localDir, remoteDir
+	if first {
+		progress.printf("首次同步, 两边都有但内容不同的文件两者都保留, 不覆盖, 不删除任何文件\n")
+	}
+
+	walker, err := newUploadWalker(&options.UploadOptions)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	// 本地目录不存在时不创建, 防止例如移动硬盘未挂载时, 误判为全部文件已删除
+	local, err := walkSyncLocal(localDir, walker, progress)
+	if err != nil {
+		progress.printf("遍历本地目录失败, %s\n", err)
+		return
+	}
+
+	progress.printf("获取网盘目录 %s 的文件列表...\n", remoteDir)
+	remote, err := walkSyncRemote(remoteDir, walker)
+	if isRemoteNotExist(err) && len(state.Files) == 0 {
+		remote, err = map[string]*baidupcs.FileDirectory{}, nil
+	}
+	if err != nil {
+		progress.printf("获取网盘目录失败, %s\n", err)
+		return
+	}
+
+	plans, conflicts := planBisync(localDir, local, remote, state, policy, options, progress)
+
+	var deletes int
+	for _, p := range plans {
+		if p.action == bisyncDeleteLocal || p.action == bisyncDeleteRemote {
+			deletes++
+		}
+	}
+	if options.MaxDelete > 0 && deletes > options.MaxDelete {
+		progress.printf("\n错误: 要删除 %d 个文件, 超过上限 %d, 中止同步, 请检查后使用 --max-delete 调整上限\n", deletes, options.MaxDelete)
+		return
+	}
+
+	if options.DryRun {
+		progress.printf("\n模拟运行, 未做任何修改: 要执行 %d 个操作, 冲突 %d\n", len(plans), conflicts)
+		return
+	}
+
+	failed := executeBisync(localDir, remoteDir, plans, local, remote, state, options, progress)
+
+	err = state.save(stateFile)
+	if err != nil {
+		progress.printf("保存同步状态失败, %s\n", err)
+	}
+
+	progress.printf("\n同步完成: 执行 %d 个操作, 冲突 %d, 失败 %d\n", len(plans), conflicts, failed)
+}
+
+// planBisync 比较本地, 网盘和上次同步的状态, 返回要执行的操作和冲突数
+func planBisync(localDir string, local *syncLocalTree, remote map[string]*baidupcs.FileDirectory, state *bisyncState, policy bisyncPolicy, options *SyncOptions, progress *progressOutput) (plans []*bisyncPlan, conflicts int) {
+	rels := map[string]bool{}
+	for rel := range local.files {
+		rels[rel] = true
+	}
+	for rel, fd := range remote {
+		if !fd.Isdir {
+			rels[rel] = true
+		}
+	}
+	for rel := range state.Files {
+		rels[rel] = true
+	}
+	sorted := make([]string, 0, len(rels))
+	for rel := range rels {
+		sorted = append(sorted, rel)
+	}
+	sort.Strings(sorted)
+
+	for _, rel := range sorted {
+		var (
+			lf = local.files[rel]
+			fd = remote[rel]
+			st = state.Files[rel]
+		)
+		if fd != nil && fd.Isdir || lf == nil && local.dirs[rel] {
+			progress.printf("跳过 %s, 本地和网盘中的类型不同 (文件/目录)\n", rel)
+			continue
+		}
+		if lf == nil && fd != nil && syncLocalExists(localDir, rel) {
+			// 本地存在但被 .pcsignore 等规则忽略的, 不覆盖
+			continue
+		}
+
+		var (
+			localChanged  = lf != nil && (st == nil || lf.size != st.Size || lf.mtime != st.LocalMtime)
+			remoteChanged = fd != nil && (st == nil || fd.Size != st.Size || fd.Mtime != st.RemoteMtime)
+			action        bisyncAction
+			label         string
+		)
+		switch {
+		case lf == nil && fd == nil:
+			// 两边都已删除
+			delete(state.Files, rel)
+			continue
+		case fd == nil && st == nil:
+			action, label = bisyncUpload, "新增 ->"
+		case fd == nil && localChanged:
+			// 网盘中已删除, 本地已修改, 保留修改
+			action, label = bisyncUpload, "网盘中已删除, 本地已修改 ->"
+		case fd == nil:
+			action, label = bisyncDeleteLocal, "删除本地"
+		case lf == nil && st == nil:
+			action, label = bisyncDownload, "新增 <-"
+		case lf == nil && remoteChanged:
+			action, label = bisyncDownload, "本地已删除, 网盘中已修改 <-"
+		case lf == nil:
+			action, label = bisyncDeleteRemote, "删除网盘"
+		case !localChanged && !remoteChanged:
+			continue
+		case localChanged && !remoteChanged:
+			action, label = bisyncUpload, "更新 ->"
+		case !localChanged && remoteChanged:
+			action, label = bisyncDownload, "更新 <-"
+		case lf.size == fd.Size && !syncMD5Changed(lf, fd, options.Rehash, progress):
+			// 两边都改变, 但内容相同, 例如首次同步
+			action, label = bisyncRecord, "相同"
+		case st == nil:
+			// 没有上次同步的状态 (例如首次同步), 无法判断哪边较新, 两者都保留, 不覆盖
+			conflicts++
+			action, label = bisyncKeepBoth, "冲突, "+bisyncActionString(bisyncKeepBoth)
+		default:
+			conflicts++
+			action = policy.resolve(rel, lf, fd, options.DryRun, progress)
+			label = "冲突, " + bisyncActionString(action)
+		}
+
+		progress.printf("[%s] %s\n", label, rel)
+		if action == bisyncNone {
+			continue
+		}
+		plans = append(plans, &bisyncPlan{
+			rel:    rel,
+			action: action,
+			local:  lf,
+			remote: fd,
+		})
+	}
+	return
+}
+
+// resolve 根据 policy 决定如何处理两边都修改的文件, 模拟运行时不询问,
+// 询问通过 progress 输出, json 模式下输出到标准错误
+func (policy bisyncPolicy) resolve(rel string, lf *syncLocalFile, fd *baidupcs.FileDirectory, dryRun bool, progress *progressOutput) bisyncAction {
+	switch policy {
+	case bisyncBoth:
+		return bisyncKeepBoth
+	case bisyncPrompt:
+		if dryRun {
+			return bisyncNone
+		}
+		progress.printf("冲突: %s\n", rel)
+		progress.printf("  本地: %s, 修改于 %s\n", pcsutil.ConvertFileSize(lf.size, 2), time.Unix(lf.mtime, 0).Format("2006-01-02 15:04:05"))
+		progress.printf("  网盘: %s, 修改于 %s\n", pcsutil.ConvertFileSize(fd.Size, 2), time.Unix(fd.Mtime, 0).Format("2006-01-02 15:04:05"))
+		for {
+			var choice string
+			progress.printf("使用 [l]本地 [r]网盘 [b]保留两者 [s]跳过 > ")
+			_, err := fmt.Scanln(&choice)
+			if err != nil && err.Error() != "unexpected newline" {
+				return bisyncNone
+			}
+			switch strings.ToLower(choice) {
+			case "l":
+				return bisyncUpload
+			case "r":
+				return bisyncDownload
+			case "b":
+				return bisyncKeepBoth
+			case "s":
+				return bisyncNone
+			}
+		}
+	}
+
+	// 网盘文件的修改时间为上传时间
+	if lf.mtime > fd.Mtime {
+		return bisyncUpload
+	}
+	return bisyncDownload
+}
+
+func bisyncActionString(action bisyncAction) string {
+	switch action {
+	case bisyncUpload:
+		return "使用本地 ->"
+	case bisyncDownload:
+		return "使用网盘 <-"
+	case bisyncKeepBoth:
+		return "保留两者"
+	}
+	return "跳过"
+}
+
+// bisyncConflictName 返回冲突时本地版本的新文件名, 例如 a.txt -> a.conflict-20060102-150405.txt
+func bisyncConflictName(rel string) string {
+	ext := path.Ext(rel)
+	return strings.TrimSuffix(rel, ext) + ".conflict-" + time.Now().Format("20060102-150405") + ext
+}
+
+// executeBisync 执行同步操作, 更新成功的文件的状态, 返回失败的数量
+func executeBisync(localDir, remoteDir string, plans []*bisyncPlan, local *syncLocalTree, remote map[string]*baidupcs.FileDirectory, state *bisyncState, options *SyncOptions, progress *progressOutput) (failed int) {
+	var (
+		uploads       = map[string]*syncLocalFile{} // 以网盘路径为键
+		downloads     []*bisyncPlan
+		remoteDeletes []string
+		localPath     = func(rel string) string {
+			return filepath.Join(localDir, filepath.FromSlash(rel))
+		}
+	)
+
+	for _, p := range plans {
+		switch p.action {
+		case bisyncRecord:
+			state.Files[p.rel] = &bisyncStateEntry{
+				Size:        p.local.size,
+				LocalMtime:  p.local.mtime,
+				RemoteMtime: p.remote.Mtime,
+			}
+		case bisyncUpload:
+			uploads[path.Join(remoteDir, p.rel)] = p.local
+		case bisyncDownload:
+			downloads = append(downloads, p)
+		case bisyncDeleteRemote:
+			remoteDeletes = append(remoteDeletes, p.rel)
+		case bisyncDeleteLocal:
+			err := os.Remove(p.local.path)
+			if err != nil && !os.IsNotExist(err) {
+				progress.printf("删除本地文件 %s 失败, %s\n", p.rel, err)
+				failed++
+				continue
+			}
+			delete(state.Files, p.rel)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), fmt.Sprintf("删除本地文件: %s\n", p.local.path), false)
+			// 删除变为空的上层目录, 网盘中仍存在的目录除外
+			for dir := path.Dir(p.rel); dir != "."; dir = path.Dir(dir) {
+				if fd := remote[dir]; fd != nil && fd.Isdir || os.Remove(localPath(dir)) != nil {
+					break
+				}
+			}
+		case bisyncKeepBoth:
+			// 本地版本改名后上传, 再下载网盘版本
+			conflictRel := bisyncConflictName(p.rel)
+			err := os.Rename(p.local.path, localPath(conflictRel))
+			if err != nil {
+				progress.printf("重命名本地文件 %s 失败, %s\n", p.rel, err)
+				failed++
+				continue
+			}
+			progress.printf("本地版本保存为: %s\n", conflictRel)
+			uploads[path.Join(remoteDir, conflictRel)] = &syncLocalFile{
+				path:  localPath(conflictRel),
+				size:  p.local.size,
+				mtime: p.local.mtime,
+			}
+			downloads = append(downloads, p)
+		}
+	}
+
+	// 删除网盘文件, 移动到回收站
+	if len(remoteDeletes) > 0 {
+		trashRels := collapseRemoteDeletes(remoteDeletes, remote, local, localDir)
+		failedRels := moveToSyncTrash(remoteDir, trashRels, progress)
+		failedSet := map[string]bool{}
+		for _, rel := range failedRels {
+			failedSet[rel] = true
+		}
+		for _, rel := range remoteDeletes {
+			if underAny(rel, failedSet) {
+				failed++
+				continue
+			}
+			delete(state.Files, rel)
+		}
+	}
+
+	// 下载
+	for k, p := range downloads {
+		err := syncDownload(k+1, p.remote, localPath(p.rel), progress)
+		if err != nil {
+			msg := fmt.Sprintf("[%d] 下载 %s 失败, %s\n", k+1, p.remote.Path, err)
+			progress.printf("%s", msg)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			failed++
+			continue
+		}
+		// 下载时, 本地文件的修改时间设为网盘文件的修改时间
+		state.Files[p.rel] = &bisyncStateEntry{
+			Size:        p.remote.Size,
+			LocalMtime:  p.remote.Mtime,
+			RemoteMtime: p.remote.Mtime,
+		}
+	}
+
+	// 上传
+	if len(uploads) > 0 {
+		failed += executeBisyncUploads(remoteDir, uploads, state, options, progress)
+	}
+	return
+}
+
+// collapseRemoteDeletes 网盘目录下的文件全部要删除, 且本地已没有该目录时, 将整个目录移动到回收站
+func collapseRemoteDeletes(deletes []string, remote map[string]*baidupcs.FileDirectory, local *syncLocalTree, localDir string) (rels []string) {
+	var (
+		deleteSet = map[string]bool{}
+		total     = map[string]int{} // 各目录下的文件数
+		deleted   = map[string]int{} // 各目录下要删除的文件数
+	)
+	for _, rel := range deletes {
+		deleteSet[rel] = true
+	}
+	for rel, fd := range remote {
+		if fd.Isdir {
+			continue
+		}
+		for dir := path.Dir(rel); dir != "."; dir = path.Dir(dir) {
+			total[dir]++
+			if deleteSet[rel] {
+				deleted[dir]++
+			}
+		}
+	}
+
+	dirSet := map[string]bool{}
+	for _, rel := range sortedKeys(remote) {
+		fd := remote[rel]
+		if !fd.Isdir || underAny(path.Dir(rel), dirSet) || total[rel] == 0 || total[rel] != deleted[rel] {
+			continue
+		}
+		if local.dirs[rel] || syncLocalExists(localDir, rel) {
+			continue
+		}
+		dirSet[rel] = true
+		rels = append(rels, rel)
+	}
+	for _, rel := range deletes {
+		if !underAny(path.Dir(rel), dirSet) {
+			rels = append(rels, rel)
+		}
+	}
+	sort.Strings(rels)
+	return
+}
+
+// executeBisyncUploads 上传文件, 获取上传后网盘文件的信息, 更新状态, 返回失败的数量
+func executeBisyncUploads(remoteDir string, uploads map[string]*syncLocalFile, state *bisyncState, options *SyncOptions, progress *progressOutput) (failed int) {
+	savePaths := make([]string, 0, len(uploads))
+	for savePath := range uploads {
+		savePaths = append(savePaths, savePath)
+	}
+	sort.Strings(savePaths)
+
+	ulist := list.New()
+	for k, savePath := range savePaths {
+		ulist.PushBack(&utask{
+			ListTask: ListTask{
+				ID:       k + 1,
+				MaxRetry: 3,
+			},
+			uploadInfo: &LocalPathInfo{
+				Path: uploads[savePath].path,
+			},
+			savePath: savePath,
+		})
+	}
+
+	// Ctrl+C 取消, Ctrl+Z 暂停/恢复
+	control := newTaskControl(progress)
+	defer control.stop()
+
+	result := uploadTasks(ulist, nil, nil, &options.UploadOptions, progress, control)
+	failed = len(uploads) - len(result.succeeded)
+
+	// 获取失败时, 不记录状态, 下次同步时比较 md5
+	prefix := strings.TrimSuffix(remoteDir, "/") + "/"
+	for len(result.succeeded) > 0 {
+		n := len(result.succeeded)
+		if n > 100 {
+			n = 100
+		}
+		fds, err := info.FilesDirectoriesBatchMeta(result.succeeded[:n]...)
+		result.succeeded = result.succeeded[n:]
+		if err != nil {
+			progress.printf("获取上传的文件的信息失败, %s\n", err)
+			continue
+		}
+		for _, fd := range fds {
+			lf := uploads[fd.Path]
+			if lf == nil {
+				continue
+			}
+			state.Files[strings.TrimPrefix(fd.Path, prefix)] = &bisyncStateEntry{
+				Size:        lf.size,
+				LocalMtime:  lf.mtime,
+				RemoteMtime: fd.Mtime,
+			}
+		}
+	}
+	return
+}
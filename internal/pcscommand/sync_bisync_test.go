@@ -0,0 +1,145 @@
+package pcscommand
+
+import (
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlanBisync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bisync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	same := filepath.Join(dir, "same.txt")
+	ioutil.WriteFile(same, []byte("hello"), 0644)
+
+	var (
+		local = &syncLocalTree{
+			files: map[string]*syncLocalFile{
+				"new-local.txt":    {size: 1, mtime: 100},
+				"del-remote.txt":   {size: 1, mtime: 100},
+				"del-remote2.txt":  {size: 2, mtime: 200}, // 网盘中已删除, 本地已修改
+				"mod-local.txt":    {size: 2, mtime: 200},
+				"mod-remote.txt":   {size: 1, mtime: 100},
+				"unchanged.txt":    {size: 1, mtime: 100},
+				"conflict.txt":     {size: 2, mtime: 300},
+				"conflict-new.txt": {size: 2, mtime: 300}, // 没有状态, 本地较新
+				"conflict-old.txt": {size: 2, mtime: 100}, // 没有状态, 网盘较新
+				"same.txt":         {path: same, size: 5, mtime: 100},
+			},
+			dirs: map[string]bool{},
+		}
+		remote = map[string]*baidupcs.FileDirectory{
+			"new-remote.txt":   {Size: 1, Mtime: 100},
+			"del-local.txt":    {Size: 1, Mtime: 100},
+			"del-local2.txt":   {Size: 2, Mtime: 200}, // 本地已删除, 网盘中已修改
+			"mod-local.txt":    {Size: 1, Mtime: 100},
+			"mod-remote.txt":   {Size: 2, Mtime: 200},
+			"unchanged.txt":    {Size: 1, Mtime: 100},
+			"conflict.txt":     {Size: 3, Mtime: 200},
+			"conflict-new.txt": {Size: 3, Mtime: 200},
+			"conflict-old.txt": {Size: 3, Mtime: 200},
+			"same.txt":         {Size: 5, Mtime: 200, MD5: "5d41402abc4b2a76b9719d911017c592"},
+		}
+		state = &bisyncState{
+			Files: map[string]*bisyncStateEntry{
+				"del-remote.txt":  {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"del-remote2.txt": {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"del-local.txt":   {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"del-local2.txt":  {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"mod-local.txt":   {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"mod-remote.txt":  {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"unchanged.txt":   {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"conflict.txt":    {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+				"gone.txt":        {Size: 1, LocalMtime: 100, RemoteMtime: 100},
+			},
+		}
+	)
+
+	plans, conflicts := planBisync(dir, local, remote, state, bisyncNewer, &SyncOptions{}, &progressOutput{})
+
+	actions := map[string]bisyncAction{}
+	for _, plan := range plans {
+		actions[plan.rel] = plan.action
+	}
+	for _, tt := range []struct {
+		rel    string
+		action bisyncAction
+	}{
+		{"new-local.txt", bisyncUpload},
+		{"new-remote.txt", bisyncDownload},
+		{"del-remote.txt", bisyncDeleteLocal},
+		{"del-remote2.txt", bisyncUpload},
+		{"del-local.txt", bisyncDeleteRemote},
+		{"del-local2.txt", bisyncDownload},
+		{"mod-local.txt", bisyncUpload},
+		{"mod-remote.txt", bisyncDownload},
+		{"unchanged.txt", bisyncNone},
+		{"conflict.txt", bisyncUpload},       // 有状态, 按 newer 处理
+		{"conflict-new.txt", bisyncKeepBoth}, // 没有状态, 不论 policy 都保留两者
+		{"conflict-old.txt", bisyncKeepBoth},
+		{"same.txt", bisyncRecord},
+		{"gone.txt", bisyncNone},
+	} {
+		if actions[tt.rel] != tt.action {
+			t.Errorf("%s: action %d, want %d", tt.rel, actions[tt.rel], tt.action)
+		}
+	}
+	if conflicts != 3 {
+		t.Errorf("conflicts %d, want 3", conflicts)
+	}
+	if _, ok := state.Files["gone.txt"]; ok {
+		t.Errorf("gone.txt should be removed from state")
+	}
+}
+
+func TestCollapseRemoteDeletes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bisync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		remote = map[string]*baidupcs.FileDirectory{
+			"d":       {Isdir: true},
+			"d/a":     {},
+			"d/b":     {},
+			"e":       {Isdir: true},
+			"e/x":     {},
+			"e/y":     {},
+			"f":       {Isdir: true},
+			"f/z":     {},
+			"g":       {Isdir: true},
+			"g/h":     {Isdir: true},
+			"g/h/1":   {},
+			"top.txt": {},
+		}
+		local = &syncLocalTree{
+			files: map[string]*syncLocalFile{},
+			dirs:  map[string]bool{"f": true}, // 本地仍有此目录, 不删除整个目录
+		}
+	)
+
+	for _, tt := range []struct {
+		deletes []string
+		want    []string
+	}{
+		{[]string{"d/a"}, []string{"d/a"}},
+		{[]string{"e/x", "e/y"}, []string{"e"}},
+		{[]string{"f/z"}, []string{"f/z"}},
+		{[]string{"g/h/1"}, []string{"g"}},
+		{[]string{"d/a", "e/x", "e/y", "f/z", "g/h/1", "top.txt"}, []string{"d/a", "e", "f/z", "g", "top.txt"}},
+	} {
+		got := collapseRemoteDeletes(tt.deletes, remote, local, dir)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("collapseRemoteDeletes(%v) = %v, want %v", tt.deletes, got, tt.want)
+		}
+	}
+}
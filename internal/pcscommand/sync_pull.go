@@ -0,0 +1,248 @@
+package pcscommand
+
+import (
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/downloader"
+	"github.com/iikira/BaiduPCS-Go/internal/pcsconfig"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/iikira/BaiduPCS-Go/pcsverbose"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunSyncPull 将网盘目录 remoteDir 单向同步到本地目录 localDir, 只下载新增和改变的文件,
+// options.Delete 为 true 时, 删除网盘中不存在的本地文件, 超过 options.MaxDelete 时中止
+func RunSyncPull(remoteDir, localDir string, options *SyncOptions) {
+	if options == nil {
+		options = &SyncOptions{}
+	}
+
+	remoteDir, err := getAbsPath(remoteDir)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	walker, err := newUploadWalker(&options.UploadOptions)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	local := &syncLocalTree{
+		files: map[string]*syncLocalFile{},
+		dirs:  map[string]bool{},
+	}
+	if _, err = os.Stat(localDir); err == nil {
+		local, err = walkSyncLocal(localDir, walker, progress)
+		if err != nil {
+			progress.printf("遍历本地目录失败, %s\n", err)
+			return
+		}
+	}
+
+	progress.printf("获取网盘目录 %s 的文件列表...\n", remoteDir)
+	remote, err := walkSyncRemote(remoteDir, walker)
+	if err != nil {
+		progress.printf("获取网盘目录失败, %s\n", err)
+		return
+	}
+
+	var (
+		summary   syncSummary
+		downloads []string            // 要下载的文件
+		deletes   []string            // 要删除的本地文件
+		dirs      []string            // 要在本地创建的目录
+		blocked   = map[string]bool{} // 本地和网盘的类型不同, 且不删除时, 跳过的网盘目录
+	)
+	for _, rel := range sortedKeys(remote) {
+		fd := remote[rel]
+		if underAny(rel, blocked) {
+			if !fd.Isdir {
+				summary.skipped++
+			}
+			continue
+		}
+
+		if fd.Isdir {
+			switch {
+			case local.files[rel] != nil && options.Delete:
+				deletes = append(deletes, rel)
+			case local.files[rel] != nil:
+				blocked[rel] = true
+				progress.printf("跳过 %s, 本地和网盘中的类型不同 (文件/目录), 使用 --delete 替换本地的\n", rel)
+				continue
+			case local.dirs[rel]:
+				continue
+			}
+			dirs = append(dirs, rel)
+			continue
+		}
+
+		lf := local.files[rel]
+		switch {
+		case local.dirs[rel]:
+			// 不删除本地目录
+			summary.skipped++
+			progress.printf("跳过 %s, 本地为目录\n", rel)
+			continue
+		case lf == nil && syncLocalExists(localDir, rel):
+			// 本地存在但被 .pcsignore 等规则忽略的, 不覆盖
+			summary.skipped++
+			continue
+		case lf == nil:
+			summary.added++
+			progress.printf("[新增] %s\n", rel)
+		case syncPullChanged(lf, fd, options.Checksum, options.Rehash, progress):
+			summary.updated++
+			progress.printf("[更新] %s\n", rel)
+		default:
+			summary.unchanged++
+			continue
+		}
+		downloads = append(downloads, rel)
+	}
+
+	if options.Delete {
+		for rel := range local.files {
+			if remote[rel] == nil {
+				deletes = append(deletes, rel)
+			}
+		}
+		sort.Strings(deletes)
+		for _, rel := range deletes {
+			progress.printf("[删除] %s\n", rel)
+		}
+	}
+
+	if options.MaxDelete > 0 && len(deletes) > options.MaxDelete {
+		progress.printf("\n错误: 要删除 %d 个本地文件, 超过上限 %d, 中止同步, 请检查后使用 --max-delete 调整上限\n", len(deletes), options.MaxDelete)
+		return
+	}
+
+	if options.DryRun {
+		summary.deleted = len(deletes)
+		progress.printf("\n模拟运行, 未做任何修改: %s\n", summary.String())
+		return
+	}
+
+	// 先删除, 网盘中为目录的本地文件删除后才能创建目录
+	for _, rel := range deletes {
+		err = os.Remove(filepath.Join(localDir, filepath.FromSlash(rel)))
+		if err != nil {
+			progress.printf("删除 %s 失败, %s\n", rel, err)
+			summary.failed++
+			continue
+		}
+		summary.deleted++
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), fmt.Sprintf("删除本地文件: %s\n", rel), false)
+	}
+	if options.Delete {
+		removeSyncEmptyDirs(localDir, local, remote)
+	}
+
+	for _, rel := range dirs {
+		err = os.MkdirAll(filepath.Join(localDir, filepath.FromSlash(rel)), 0777)
+		if err != nil {
+			progress.printf("创建目录 %s 失败, %s\n", rel, err)
+			summary.failed++
+		}
+	}
+
+	for k, rel := range downloads {
+		fd := remote[rel]
+		err = syncDownload(k+1, fd, filepath.Join(localDir, filepath.FromSlash(rel)), progress)
+		if err != nil {
+			msg := fmt.Sprintf("[%d] 下载 %s 失败, %s\n", k+1, fd.Path, err)
+			progress.printf("%s", msg)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			summary.failed++
+			continue
+		}
+		summary.size += fd.Size
+	}
+
+	progress.printf("\n同步完成: %s\n", summary.String())
+}
+
+// syncPullChanged 比较网盘文件和本地文件, 默认比较大小和修改时间, 下载时保留了网盘文件的修改时间,
+// checksum 为 true 时, 大小相同则比较 md5
+func syncPullChanged(lf *syncLocalFile, fd *baidupcs.FileDirectory, checksum, rehash bool, progress *progressOutput) bool {
+	if lf.size != fd.Size {
+		return true
+	}
+	if !checksum {
+		return lf.mtime != fd.Mtime
+	}
+	return syncMD5Changed(lf, fd, rehash, progress)
+}
+
+// removeSyncEmptyDirs 删除网盘中不存在的本地目录, 只删除空目录, 从下层开始
+func removeSyncEmptyDirs(localDir string, local *syncLocalTree, remote map[string]*baidupcs.FileDirectory) {
+	dirs := make([]string, 0, len(local.dirs))
+	for rel := range local.dirs {
+		if fd := remote[rel]; fd == nil || !fd.Isdir {
+			dirs = append(dirs, rel)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+	for _, rel := range dirs {
+		if os.Remove(filepath.Join(localDir, filepath.FromSlash(rel))) == nil {
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), fmt.Sprintf("删除本地目录: %s\n", rel), false)
+		}
+	}
+}
+
+// syncDownload 下载网盘文件 fd 到 localPath, 保留网盘文件的修改时间, 失败重试,
+// 先下载到临时文件, 完成后替换已有的文件, 下载失败时不损坏已有的文件
+func syncDownload(id int, fd *baidupcs.FileDirectory, localPath string, progress *progressOutput) (err error) {
+	cfg := &downloader.Config{
+		Parallel:    pcsconfig.Config.MaxParallel,
+		CacheSize:   pcsconfig.Config.CacheSize,
+		IsOverwrite: true,
+	}
+
+	// 获取各下载服务器的链接, 分散到多个服务器下载
+	cfg.Mirrors, err = info.LocateDownload(fd.Path)
+	if err != nil {
+		pcsverbose.Verbosef("[%d] 获取下载链接失败, 只从默认服务器下载, %s\n", id, err)
+	}
+
+	const maxRetry = 3
+	tmpPath := localPath + syncTempSuffix
+	for retry := 0; ; retry++ {
+		msg := fmt.Sprintf("[%d] 准备下载: %s\n", id, fd.Path)
+		progress.printf("%s", msg)
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+
+		err = info.DownloadFile(fd.Path, getDownloadFunc(id, fd.Path, tmpPath, cfg, nil, progress))
+		if err == nil {
+			break
+		}
+		if retry >= maxRetry {
+			return err
+		}
+		progress.printf("[%d] 下载文件错误, %s, 重试 %d/%d\n", id, err, retry+1, maxRetry)
+		time.Sleep(3 * time.Duration(retry+1) * time.Second)
+	}
+
+	mtime := time.Unix(fd.Mtime, 0)
+	err = os.Chtimes(tmpPath, mtime, mtime)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, localPath)
+}
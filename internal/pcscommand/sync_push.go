@@ -0,0 +1,173 @@
+package pcscommand
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"path"
+	"sort"
+)
+
+// RunSyncPush 将本地目录 localDir 单向同步到网盘目录 remoteDir, 只上传新增和改变的文件,
+// options.Delete 为 true 时, 将网盘中本地不存在的文件和目录移动到回收站
+func RunSyncPush(localDir, remoteDir string, options *SyncOptions) {
+	if options == nil {
+		options = &SyncOptions{}
+	}
+
+	remoteDir, err := getAbsPath(remoteDir)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	walker, err := newUploadWalker(&options.UploadOptions)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	local, err := walkSyncLocal(localDir, walker, progress)
+	if err != nil {
+		progress.printf("遍历本地目录失败, %s\n", err)
+		return
+	}
+
+	progress.printf("获取网盘目录 %s 的文件列表...\n", remoteDir)
+	remote, err := walkSyncRemote(remoteDir, walker)
+	if isRemoteNotExist(err) {
+		remote, err = map[string]*baidupcs.FileDirectory{}, nil
+	}
+	if err != nil {
+		progress.printf("获取网盘目录失败, %s\n", err)
+		return
+	}
+
+	var (
+		summary  syncSummary
+		extras   []string // 网盘中多余的文件和目录, 只包含最上层
+		extraSet = map[string]bool{}
+		blocked  = map[string]bool{} // 本地和网盘的类型不同, 且不删除时, 跳过的本地路径
+		ulist    = list.New()
+		lastID   int
+		dirs     []string // 要在网盘创建的空目录
+	)
+	for _, rel := range sortedKeys(remote) {
+		if underAny(path.Dir(rel), extraSet) {
+			continue
+		}
+		fd := remote[rel]
+		if fd.Isdir && local.dirs[rel] || !fd.Isdir && local.files[rel] != nil {
+			continue
+		}
+		// 本地存在但被 .pcsignore 等规则忽略的, 不删除
+		if !local.dirs[rel] && local.files[rel] == nil && syncLocalExists(localDir, rel) {
+			continue
+		}
+		extraSet[rel] = true
+		extras = append(extras, rel)
+
+		if !options.Delete && (local.dirs[rel] || local.files[rel] != nil) {
+			blocked[rel] = true
+			progress.printf("跳过 %s, 本地和网盘中的类型不同 (文件/目录), 使用 --delete 替换网盘中的\n", rel)
+		}
+	}
+
+	if options.Delete {
+		for _, rel := range extras {
+			progress.printf("[删除] %s\n", rel)
+		}
+	} else if n := len(extras) - len(blocked); n > 0 {
+		progress.printf("网盘中有 %d 个本地不存在的文件或目录, 使用 --delete 删除\n", n)
+	}
+
+	files := make([]string, 0, len(local.files))
+	for rel := range local.files {
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+
+	for _, rel := range files {
+		if underAny(rel, blocked) {
+			summary.skipped++
+			continue
+		}
+
+		lf, fd := local.files[rel], remote[rel]
+		switch {
+		case fd == nil || fd.Isdir:
+			summary.added++
+			progress.printf("[新增] %s\n", rel)
+		case syncFileChanged(lf, fd, options.Rehash, progress):
+			summary.updated++
+			progress.printf("[更新] %s\n", rel)
+		default:
+			summary.unchanged++
+			continue
+		}
+
+		lastID++
+		ulist.PushBack(&utask{
+			ListTask: ListTask{
+				ID:       lastID,
+				MaxRetry: 3,
+			},
+			uploadInfo: &LocalPathInfo{
+				Path: lf.path,
+			},
+			savePath: path.Join(remoteDir, rel),
+		})
+	}
+
+	for _, rel := range local.emptyDirs {
+		if underAny(rel, blocked) {
+			continue
+		}
+		if fd := remote[rel]; fd != nil && fd.Isdir {
+			continue
+		}
+		progress.printf("[新增目录] %s\n", rel)
+		dirs = append(dirs, path.Join(remoteDir, rel))
+	}
+
+	if options.DryRun {
+		if options.Delete {
+			summary.deleted = len(extras)
+		}
+		progress.printf("\n模拟运行, 未做任何修改: %s\n", summary.String())
+		return
+	}
+
+	if options.Delete && len(extras) > 0 {
+		failed := len(moveToSyncTrash(remoteDir, extras, progress))
+		summary.deleted, summary.failed = len(extras)-failed, failed
+	}
+
+	if ulist.Len() > 0 || len(dirs) > 0 {
+		// Ctrl+C 取消, Ctrl+Z 暂停/恢复
+		control := newTaskControl(progress)
+		defer control.stop()
+
+		result := uploadTasks(ulist, dirs, nil, &options.UploadOptions, progress, control)
+		summary.failed += result.failed
+		summary.size = result.totalSize
+	}
+
+	progress.printf("\n同步完成: %s\n", summary.String())
+}
+
+// syncFileChanged 比较本地文件和网盘文件, 大小不同则已改变, 否则比较 md5.
+// 网盘文件的修改时间为上传时间, 不能用于判断本地文件是否已修改
+func syncFileChanged(lf *syncLocalFile, fd *baidupcs.FileDirectory, rehash bool, progress *progressOutput) bool {
+	if lf.size != fd.Size {
+		return true
+	}
+	return syncMD5Changed(lf, fd, rehash, progress)
+}
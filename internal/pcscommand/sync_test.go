@@ -0,0 +1,88 @@
+package pcscommand
+
+import (
+	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const helloMD5 = "5d41402abc4b2a76b9719d911017c592" // md5("hello")
+
+func TestSyncFileChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(name, []byte("hello"), 0644)
+
+	for _, tt := range []struct {
+		desc    string
+		lf      *syncLocalFile
+		fd      *baidupcs.FileDirectory
+		changed bool
+	}{
+		{"大小不同", &syncLocalFile{path: name, size: 5, mtime: 100}, &baidupcs.FileDirectory{Size: 6, Mtime: 200, MD5: helloMD5}, true},
+		{"md5 相同", &syncLocalFile{path: name, size: 5, mtime: 300}, &baidupcs.FileDirectory{Size: 5, Mtime: 200, MD5: helloMD5}, false},
+		// 本地文件的修改时间早于上传时间, 但内容不同, 例如从备份中恢复的旧版本
+		{"修改时间较早, md5 不同", &syncLocalFile{path: name, size: 5, mtime: 100}, &baidupcs.FileDirectory{Size: 5, Mtime: 200, MD5: "00000000000000000000000000000000"}, true},
+		{"修改时间较晚, md5 不同", &syncLocalFile{path: name, size: 5, mtime: 300}, &baidupcs.FileDirectory{Size: 5, Mtime: 200, MD5: "00000000000000000000000000000000"}, true},
+	} {
+		if got := syncFileChanged(tt.lf, tt.fd, false, &progressOutput{}); got != tt.changed {
+			t.Errorf("%s: syncFileChanged = %v, want %v", tt.desc, got, tt.changed)
+		}
+	}
+}
+
+func TestSyncPullChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(name, []byte("hello"), 0644)
+
+	for _, tt := range []struct {
+		desc     string
+		lf       *syncLocalFile
+		fd       *baidupcs.FileDirectory
+		checksum bool
+		changed  bool
+	}{
+		{"大小不同", &syncLocalFile{path: name, size: 5, mtime: 100}, &baidupcs.FileDirectory{Size: 6, Mtime: 100, MD5: helloMD5}, false, true},
+		{"修改时间相同", &syncLocalFile{path: name, size: 5, mtime: 100}, &baidupcs.FileDirectory{Size: 5, Mtime: 100}, false, false},
+		{"修改时间不同", &syncLocalFile{path: name, size: 5, mtime: 100}, &baidupcs.FileDirectory{Size: 5, Mtime: 200, MD5: helloMD5}, false, true},
+		{"checksum, md5 相同", &syncLocalFile{path: name, size: 5, mtime: 100}, &baidupcs.FileDirectory{Size: 5, Mtime: 200, MD5: helloMD5}, true, false},
+		{"checksum, md5 不同", &syncLocalFile{path: name, size: 5, mtime: 100}, &baidupcs.FileDirectory{Size: 5, Mtime: 100, MD5: "00000000000000000000000000000000"}, true, true},
+	} {
+		if got := syncPullChanged(tt.lf, tt.fd, tt.checksum, false, &progressOutput{}); got != tt.changed {
+			t.Errorf("%s: syncPullChanged = %v, want %v", tt.desc, got, tt.changed)
+		}
+	}
+}
+
+func TestUnderAny(t *testing.T) {
+	prefixes := map[string]bool{"a": true, "b/c": true}
+	for _, tt := range []struct {
+		rel  string
+		want bool
+	}{
+		{"a", true},
+		{"a/x/y", true},
+		{"ab", false},
+		{"b", false},
+		{"b/c/d", true},
+		{"b/cd", false},
+		{".", false},
+	} {
+		if got := underAny(tt.rel, prefixes); got != tt.want {
+			t.Errorf("underAny(%s) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package pcscommand
+
+import (
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// transfer 可暂停, 恢复, 取消的传输任务
+type transfer interface {
+	Pause()
+	Resume()
+	Cancel()
+}
+
+// taskControl 在控制台中控制正在进行的传输任务:
+// Ctrl+C 取消当前任务, 连续两次 Ctrl+C 取消全部任务;
+// Ctrl+Z 暂停/恢复当前任务 (windows 不支持).
+// 只在终端中交互运行时接收信号, 否则 Ctrl+C 按默认方式结束程序
+type taskControl struct {
+	current    transfer
+	id         int
+	paused     bool
+	abortAll   bool      // 已取消全部任务
+	lastCancel time.Time // 上次取消的时间
+	progress   *progressOutput
+	sigs       chan os.Signal
+	mu         sync.Mutex
+}
+
+// newTaskControl 开始接收控制信号, 结束时需调用 stop.
+// 标准输入和进度输出都是终端时才接收, 脚本, 管道中运行或输出 json 进度时不改变信号的处理
+func newTaskControl(progress *progressOutput) *taskControl {
+	tc := &taskControl{
+		progress: progress,
+	}
+	if progress.mode != progressTTY || !pcsutil.IsTerminal(os.Stdin) {
+		return tc
+	}
+
+	tc.sigs = make(chan os.Signal, 1)
+	signal.Notify(tc.sigs, controlSignals...)
+	go tc.run()
+	return tc
+}
+
+func (tc *taskControl) run() {
+	for sig := range tc.sigs {
+		tc.mu.Lock()
+		switch {
+		case pauseSignal != nil && sig == pauseSignal:
+			if tc.current == nil {
+				break
+			}
+			if tc.paused {
+				tc.paused = false
+				tc.current.Resume()
+				tc.progress.printf("[%d] 已恢复\n", tc.id)
+			} else {
+				tc.paused = true
+				tc.current.Pause()
+				tc.progress.printf("[%d] 已暂停, 再次按 Ctrl+Z 恢复\n", tc.id)
+			}
+		default: // 取消
+			if time.Since(tc.lastCancel) < 2*time.Second {
+				tc.abortAll = true
+			}
+			tc.lastCancel = time.Now()
+
+			switch {
+			case tc.abortAll:
+				tc.progress.printf("已取消全部任务\n")
+			case tc.current != nil:
+				tc.progress.printf("[%d] 已取消, 2 秒内再次按 Ctrl+C 取消全部任务\n", tc.id)
+			default:
+				tc.progress.printf("2 秒内再次按 Ctrl+C 取消全部任务\n")
+			}
+			if tc.current != nil {
+				tc.current.Cancel()
+				tc.current = nil
+			}
+		}
+		tc.mu.Unlock()
+	}
+}
+
+// set 设置正在进行的传输任务, t 为 nil 表示任务已结束
+func (tc *taskControl) set(id int, t transfer) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	tc.id = id
+	tc.current = t
+	tc.paused = false
+	if t != nil && tc.abortAll {
+		t.Cancel()
+	}
+}
+
+// aborted 是否已取消全部任务
+func (tc *taskControl) aborted() bool {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return tc.abortAll
+}
+
+// interactive 是否接收控制信号
+func (tc *taskControl) interactive() bool {
+	return tc.sigs != nil
+}
+
+// stop 停止接收控制信号, 恢复默认的信号处理
+func (tc *taskControl) stop() {
+	if tc.sigs == nil {
+		return
+	}
+	signal.Stop(tc.sigs)
+	close(tc.sigs)
+}
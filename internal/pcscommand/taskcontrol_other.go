@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package pcscommand
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	// controlSignals 控制传输任务的信号, Ctrl+C 取消, Ctrl+Z 暂停/恢复
+	controlSignals = []os.Signal{os.Interrupt, syscall.SIGTSTP}
+
+	// pauseSignal 暂停/恢复传输任务的信号
+	pauseSignal os.Signal = syscall.SIGTSTP
+)
@@ -0,0 +1,13 @@
+package pcscommand
+
+import (
+	"os"
+)
+
+var (
+	// controlSignals 控制传输任务的信号, Ctrl+C 取消, windows 不支持暂停
+	controlSignals = []os.Signal{os.Interrupt}
+
+	// pauseSignal 暂停/恢复传输任务的信号
+	pauseSignal os.Signal
+)
@@ -3,18 +3,17 @@ package pcscommand
 import (
 	"bytes"
 	"container/list"
-	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"github.com/iikira/BaiduPCS-Go/baidupcs"
-	"github.com/iikira/BaiduPCS-Go/downloader/cachepool"
 	"github.com/iikira/BaiduPCS-Go/pcscache"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
 	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/iikira/BaiduPCS-Go/pcsverbose"
 	"github.com/iikira/BaiduPCS-Go/requester"
 	"github.com/iikira/BaiduPCS-Go/requester/multipartreader"
 	"github.com/iikira/BaiduPCS-Go/uploader"
-	"hash"
-	"hash/crc32"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
@@ -25,12 +24,20 @@ import (
 	"time"
 )
 
-const requiredSliceLen = 256 * pcsutil.KB // 256 KB
+const (
+	requiredSliceLen = 256 * pcsutil.KB // 256 KB
+
+	// minUploadBlockSize 分片上传的最小分片大小, 大于此值的文件使用分片上传
+	minUploadBlockSize = 32 * pcsutil.MB
+	// maxUploadBlockNum 合并分片文件的最大分片数
+	maxUploadBlockNum = 1024
+)
 
 type utask struct {
 	ListTask
 	uploadInfo *LocalPathInfo // 要上传的本地文件详情
 	savePath   string
+	sum        *sumJob // 预先计算摘要值的任务, 取出结果后为 nil
 }
 
 // SumOption 计算文件摘要值配置
@@ -38,6 +45,7 @@ type SumOption struct {
 	IsMD5Sum      bool
 	IsSliceMD5Sum bool
 	IsCRC32Sum    bool
+	Rehash        bool // 忽略摘要值缓存, 重新计算
 }
 
 // LocalPathInfo 本地文件详情
@@ -49,7 +57,6 @@ type LocalPathInfo struct {
 	MD5      []byte // 文件的 md5
 	CRC32    uint32 // 文件的 crc32
 
-	buf  []byte
 	file *os.File // 文件
 }
 
@@ -79,72 +86,76 @@ func (lp *LocalPathInfo) Close() error {
 	return lp.file.Close()
 }
 
-func (lp *LocalPathInfo) repeatRead(ws ...io.Writer) {
-	if lp.file == nil {
-		return
+// Sum 计算文件摘要值, 优先使用摘要值缓存 (pcscache.HashCache), 计算后保存到缓存
+func (lp *LocalPathInfo) Sum(opt SumOption) {
+	err := lp.sumCached(opt)
+	if err != nil {
+		fmt.Printf("%s\n", err)
 	}
+}
 
-	if lp.buf == nil {
-		lp.buf = cachepool.SetIfNotExist(0, int(requiredSliceLen))
+// sumCached 计算文件摘要值, 优先使用摘要值缓存, 返回读取文件的错误
+func (lp *LocalPathInfo) sumCached(opt SumOption) error {
+	if lp.file == nil {
+		return nil
 	}
 
-	var (
-		begin int64
-		n     int
-		err   error
-	)
-
-	handle := func() {
-		begin += int64(n)
-		for k := range ws {
-			ws[k].Write(lp.buf[:n])
-		}
+	fi, err := lp.file.Stat()
+	if err != nil {
+		return lp.sum(opt)
 	}
 
-	// 读文件
-	for {
-		n, err = lp.file.ReadAt(lp.buf, begin)
-		if err != nil {
-			if err == io.EOF {
-				handle()
-			} else {
-				fmt.Printf("%s\n", err)
-			}
-			break
+	if !opt.Rehash {
+		if he := pcscache.HashCache.Get(lp.Path, fi); he != nil {
+			opt = lp.useHashEntry(he, opt)
 		}
-
-		handle()
 	}
-}
+	if !opt.IsMD5Sum && !opt.IsSliceMD5Sum && !opt.IsCRC32Sum {
+		return nil
+	}
 
-// Sum 计算文件摘要值
-func (lp *LocalPathInfo) Sum(opt SumOption) {
-	var (
-		md5w   hash.Hash
-		crc32w hash.Hash32
-	)
+	err = lp.sum(opt)
+	if err != nil {
+		return err
+	}
 
-	ws := make([]io.Writer, 0, 2)
+	he := pcscache.HashEntry{}
 	if opt.IsMD5Sum {
-		md5w = md5.New()
-		ws = append(ws, md5w)
+		he.MD5 = hex.EncodeToString(lp.MD5)
+	}
+	if opt.IsSliceMD5Sum {
+		he.SliceMD5 = hex.EncodeToString(lp.SliceMD5)
 	}
 	if opt.IsCRC32Sum {
-		crc32w = crc32.NewIEEE()
-		ws = append(ws, crc32w)
+		crc32 := lp.CRC32
+		he.CRC32 = &crc32
 	}
-	if opt.IsSliceMD5Sum {
-		lp.SliceMD5Sum()
+	err = pcscache.HashCache.Put(lp.Path, fi, he)
+	if err != nil {
+		pcsverbose.Verbosef("DEBUG: 保存摘要值缓存失败, %s\n", err)
 	}
+	return nil
+}
 
-	lp.repeatRead(ws...)
-
-	if opt.IsMD5Sum {
-		lp.MD5 = md5w.Sum(nil)
+// useHashEntry 使用缓存中已有的摘要值, 返回仍需计算的摘要值
+func (lp *LocalPathInfo) useHashEntry(he *pcscache.HashEntry, opt SumOption) SumOption {
+	if opt.IsMD5Sum && he.MD5 != "" {
+		if md5sum, err := hex.DecodeString(he.MD5); err == nil {
+			lp.MD5 = md5sum
+			opt.IsMD5Sum = false
+		}
 	}
-	if opt.IsCRC32Sum {
-		lp.CRC32 = crc32w.Sum32()
+	if opt.IsSliceMD5Sum && he.SliceMD5 != "" {
+		if sliceMD5, err := hex.DecodeString(he.SliceMD5); err == nil {
+			lp.SliceMD5 = sliceMD5
+			opt.IsSliceMD5Sum = false
+		}
+	}
+	if opt.IsCRC32Sum && he.CRC32 != nil {
+		lp.CRC32 = *he.CRC32
+		opt.IsCRC32Sum = false
 	}
+	return opt
 }
 
 // Md5Sum 获取文件的 md5 值
@@ -156,29 +167,9 @@ func (lp *LocalPathInfo) Md5Sum() {
 
 // SliceMD5Sum 获取文件前 requiredSliceLen (256KB) 切片的 md5 值
 func (lp *LocalPathInfo) SliceMD5Sum() {
-	if lp.file == nil {
-		return
-	}
-
-	// 获取前 256KB 文件切片的 md5
-	if lp.buf == nil {
-		lp.buf = cachepool.SetIfNotExist(0, int(requiredSliceLen))
-	}
-
-	m := md5.New()
-	n, err := lp.file.ReadAt(lp.buf, 0)
-	if err != nil {
-		if err == io.EOF {
-			goto md5sum
-		} else {
-			fmt.Printf("SliceMD5Sum: %s\n", err)
-			return
-		}
-	}
-
-md5sum:
-	m.Write(lp.buf[:n])
-	lp.SliceMD5 = m.Sum(nil)
+	lp.Sum(SumOption{
+		IsSliceMD5Sum: true,
+	})
 }
 
 // Crc32Sum 获取文件的 crc32 值
@@ -209,51 +200,105 @@ func RunRapidUpload(targetPath, contentMD5, sliceMD5, crc32 string, length int64
 	return
 }
 
+// UploadOptions 上传可选参数
+type UploadOptions struct {
+	Progress       string   // 进度的输出方式, text, json
+	Includes       []string // 上传目录时, 只上传匹配的文件
+	Excludes       []string // 上传目录时, 排除匹配的文件和目录
+	FollowSymlinks bool     // 跟随符号链接, 包括指向目录的
+	SkipSymlinks   bool     // 跳过全部符号链接
+	Rehash         bool     // 忽略摘要值缓存, 重新计算文件的 md5
+	HashParallel   int      // 同时计算摘要值的文件数, 0 为默认值
+
+	// 上传时加密, 使用 download --decrypt 下载
+	Encrypt bool
+	CryptKeyOptions
+}
+
 // RunUpload 执行文件上传
-func RunUpload(localPaths []string, savePath string) {
+func RunUpload(localPaths []string, savePath string, options *UploadOptions) {
+	if options == nil {
+		options = &UploadOptions{}
+	}
+
 	absSavePath, err := getAbsPath(savePath)
 	if err != nil {
 		fmt.Printf("警告: 上传文件, 获取网盘路径 %s 错误, %s\n", savePath, err)
 	}
 
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	// Ctrl+C 取消, Ctrl+Z 暂停/恢复
+	control := newTaskControl(progress)
+	defer control.stop()
+
 	switch len(localPaths) {
 	case 0:
-		fmt.Printf("本地路径为空\n")
+		progress.printf("本地路径为空\n")
+		return
+	}
+
+	// 加密上传, 同一次上传的文件使用同一个派生的密钥, 各文件的初始向量不同
+	var cryptKey *pcscrypto.Key
+	if options.Encrypt {
+		passphrase, err := options.Passphrase()
+		if err != nil {
+			progress.printf("%s\n", err)
+			return
+		}
+		cryptKey, err = pcscrypto.NewKey(passphrase)
+		if err != nil {
+			progress.printf("%s\n", err)
+			return
+		}
+	}
+
+	// 从标准输入上传
+	for _, localPath := range localPaths {
+		if localPath != uploadStdin {
+			continue
+		}
+		if len(localPaths) != 1 {
+			progress.printf("从标准输入上传时, 不能同时指定其他本地路径\n")
+			return
+		}
+		uploadStream(os.Stdin, "标准输入", absSavePath, cryptKey, progress, control)
+		return
+	}
+
+	walker, err := newUploadWalker(options)
+	if err != nil {
+		progress.printf("%s\n", err)
 		return
 	}
 
 	var (
-		ulist         = list.New()
-		lastID        int
-		globedPathDir string
-		subSavePath   string
+		ulist     = list.New()
+		lastID    int
+		emptyDirs []string // 要在网盘创建的空目录
 	)
 
 	for k := range localPaths {
 		globedPaths, err := filepath.Glob(localPaths[k])
 		if err != nil {
-			fmt.Printf("上传文件, 匹配本地路径失败, %s\n", err)
+			progress.printf("上传文件, 匹配本地路径失败, %s\n", err)
 			continue
 		}
 
 		for k2 := range globedPaths {
-			walkedFiles, err := pcsutil.WalkDir(globedPaths[k2], "")
+			walker.files, walker.emptyDirs = nil, nil
+			err = walker.walk(globedPaths[k2])
 			if err != nil {
-				fmt.Printf("警告: %s\n", err)
+				progress.printf("警告: %s\n", err)
 				continue
 			}
 
-			for k3 := range walkedFiles {
-				// 针对 windows 的目录处理
-				if os.PathSeparator == '\\' {
-					walkedFiles[k3] = pcsutil.ConvertToUnixPathSeparator(walkedFiles[k3])
-					globedPathDir = pcsutil.ConvertToUnixPathSeparator(filepath.Dir(globedPaths[k2]))
-				} else {
-					globedPathDir = filepath.Dir(globedPaths[k2])
-				}
-
-				subSavePath = strings.TrimPrefix(walkedFiles[k3], globedPathDir)
-
+			for _, walkedFile := range walker.files {
 				lastID++
 				ulist.PushBack(&utask{
 					ListTask: ListTask{
@@ -261,25 +306,76 @@ func RunUpload(localPaths []string, savePath string) {
 						MaxRetry: 3,
 					},
 					uploadInfo: &LocalPathInfo{
-						Path: walkedFiles[k3],
+						Path: walkedFile,
 					},
-					savePath: path.Clean(absSavePath + "/" + subSavePath),
+					savePath: uploadSavePath(absSavePath, globedPaths[k2], walkedFile),
 				})
 
-				fmt.Printf("[%d] 加入上传队列: %s\n", lastID, walkedFiles[k3])
+				progress.printf("[%d] 加入上传队列: %s\n", lastID, walkedFile)
+			}
+
+			for _, dir := range walker.emptyDirs {
+				emptyDirs = append(emptyDirs, uploadSavePath(absSavePath, globedPaths[k2], dir))
 			}
 		}
 	}
 
-	if lastID == 0 {
-		fmt.Printf("未检测到上传的文件, 请检查文件路径或通配符是否正确.\n")
+	if lastID == 0 && len(emptyDirs) == 0 {
+		progress.printf("未检测到上传的文件, 请检查文件路径或通配符是否正确.\n")
 		return
 	}
 
+	result := uploadTasks(ulist, emptyDirs, cryptKey, options, progress, control)
+
+	progress.printf("\n")
+	progress.printf("全部上传完毕, 总大小: %s\n", pcsutil.ConvertFileSize(result.totalSize))
+}
+
+// uploadResult 上传任务的统计
+type uploadResult struct {
+	totalSize int64    // 上传成功 (包括秒传) 的文件的总大小
+	failed    int      // 失败的任务数, 包括创建空目录失败
+	succeeded []string // 上传成功或网盘中已存在相同文件的网盘路径
+}
+
+// uploadTasks 创建空目录, 执行队列 ulist 中的上传任务,
+// 先检测秒传, 失败再上传, cryptKey 不为 nil 时加密上传
+func uploadTasks(ulist *list.List, emptyDirs []string, cryptKey *pcscrypto.Key, options *UploadOptions, progress *progressOutput, control *taskControl) (result uploadResult) {
+	// 在上传的同时, 按队列顺序预先计算后面的文件的摘要值, 加密上传时不需要
+	var sumJobs []*sumJob
+	for e := ulist.Front(); e != nil && cryptKey == nil; e = e.Next() {
+		task := e.Value.(*utask)
+		task.sum = newSumJob(task.uploadInfo.Path, SumOption{
+			IsMD5Sum:      true,
+			IsSliceMD5Sum: true,
+			Rehash:        options.Rehash,
+		})
+		sumJobs = append(sumJobs, task.sum)
+	}
+	sums := newSumPool(options.HashParallel)
+	defer sums.close()
+	sums.submitAll(sumJobs, 2*sums.parallel)
+
+	// 创建空目录
+	for _, dir := range emptyDirs {
+		if fd, err := info.FilesDirectoriesMeta(dir); err == nil && fd.Isdir {
+			continue
+		}
+		err := info.Mkdir(dir)
+		if err != nil {
+			msg := fmt.Sprintf("创建空目录 %s 失败, %s\n", dir, err)
+			progress.printf("%s", msg)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			result.failed++
+			continue
+		}
+		progress.printf("创建空目录: %s\n", dir)
+	}
+
 	var (
 		e             *list.Element
 		task          *utask
-		msg			  string
+		msg           string
 		handleTaskErr = func(task *utask, errManifest string, err error) {
 			if task == nil {
 				panic("task is nil")
@@ -291,31 +387,64 @@ func RunUpload(localPaths []string, savePath string) {
 
 			// 不重试的情况
 			switch {
-			case strings.Contains(err.Error(), baidupcs.StrRemoteError):
+			case strings.Contains(err.Error(), baidupcs.StrRemoteError), strings.Contains(err.Error(), uploader.ErrCanceled.Error()):
 				msg = fmt.Sprintf("[%d] %s, %s\n", task.ID, errManifest, err)
-				fmt.Print(msg)
+				progress.printf("%s", msg)
 				pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+				progress.emitTask(pcsevent.Failed, pcsevent.KindUpload, &task.ListTask, task.savePath, task.uploadInfo.Path, err)
+				result.failed++
 				return
 			}
 			msg = fmt.Sprintf("[%d] %s, %s, 重试 %d/%d\n", task.ID, errManifest, err, task.retry, task.MaxRetry)
-			fmt.Print(msg)
+			progress.printf("%s", msg)
 			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
 
 			// 未达到失败重试最大次数, 将任务推送到队列末尾
 			if task.retry < task.MaxRetry {
 				task.retry++
 				ulist.PushBack(task)
+				progress.emitTask(pcsevent.Retry, pcsevent.KindUpload, &task.ListTask, task.savePath, task.uploadInfo.Path, err)
 				time.Sleep(3 * time.Duration(task.retry) * time.Second)
 			} else {
+				progress.emitTask(pcsevent.Failed, pcsevent.KindUpload, &task.ListTask, task.savePath, task.uploadInfo.Path, err)
 				task.uploadInfo.Close() // 关闭文件
+				result.failed++
 			}
 		}
-		totalSize int64
+		finishTask = func(task *utask, err error) {
+			if _, ok := err.(*uploader.VerifyError); ok {
+				// 上传的数据与本地文件不一致, 重新上传.
+				// 本地文件可能在上传时被修改, 或缓存的摘要值已过期, 重试前忽略缓存重新计算
+				if cryptKey == nil && task.retry < task.MaxRetry {
+					task.uploadInfo.OpenPath() // 重新打开文件, 打开失败时在重试时跳过
+					task.uploadInfo.MD5, task.uploadInfo.SliceMD5 = nil, nil
+					task.sum = newSumJob(task.uploadInfo.Path, SumOption{
+						IsMD5Sum:      true,
+						IsSliceMD5Sum: true,
+						Rehash:        true,
+					})
+					sums.submitAll([]*sumJob{task.sum}, 0)
+				}
+				handleTaskErr(task, "警告: 上传的文件与本地文件不一致", err)
+				return
+			}
+			if err != nil {
+				handleTaskErr(task, "上传文件失败", err)
+				return
+			}
+
+			msg = fmt.Sprintf("[%d] 上传文件成功, 保存到网盘路径: %s\n", task.ID, task.savePath)
+			progress.printf("%s", msg)
+			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			task.uploadInfo.Close() // 关闭文件
+			result.totalSize += task.uploadInfo.Length
+			result.succeeded = append(result.succeeded, task.savePath)
+		}
 	)
 
 	for {
 		e = ulist.Front()
-		if e == nil { // 结束
+		if e == nil || control.aborted() { // 结束
 			break
 		}
 
@@ -327,14 +456,42 @@ func RunUpload(localPaths []string, savePath string) {
 		}
 
 		msg = fmt.Sprintf("[%d] 准备上传: %s\n", task.ID, task.uploadInfo.Path)
-		fmt.Print(msg)
+		progress.printf("%s", msg)
 		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
 
-		if !task.uploadInfo.OpenPath() {
+		// 取出预先计算的摘要值, 重试的任务已取出, 校验失败重试的任务重新计算
+		if task.sum != nil {
+			job := task.sum
+			task.sum = nil
+			if !job.isDone() {
+				msg = fmt.Sprintf("[%d] 检测秒传中, 请稍候...\n", task.ID)
+				progress.printf("%s", msg)
+				pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+			}
+			lp, err := job.wait()
+			if err != nil {
+				msg = fmt.Sprintf("[%d] 计算文件摘要值失败, %s, 跳过...\n", task.ID, err)
+				progress.printf("%s", msg)
+				pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+				result.failed++
+				continue
+			}
+			task.uploadInfo.MD5, task.uploadInfo.SliceMD5 = lp.MD5, lp.SliceMD5
+		}
+
+		// 重试的任务, 文件仍然打开, 不重新打开
+		if task.uploadInfo.file == nil && !task.uploadInfo.OpenPath() {
 			msg = fmt.Sprintf("[%d] 文件不可读, 跳过...\n", task.ID)
-			fmt.Print(msg)
+			progress.printf("%s", msg)
 			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
 			task.uploadInfo.Close()
+			result.failed++
+			continue
+		}
+
+		// 加密上传, 网盘文件与本地文件的 md5 不同, 不检测秒传
+		if cryptKey != nil {
+			finishTask(task, uploadEncrypted(task, cryptKey, progress, control))
 			continue
 		}
 
@@ -348,22 +505,15 @@ func RunUpload(localPaths []string, savePath string) {
 			}
 		}
 
-		if task.uploadInfo.Length >= 128*pcsutil.MB {
-			msg = fmt.Sprintf("[%d] 检测秒传中, 请稍候...\n", task.ID)
-			fmt.Print(msg)
-			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
-		}
-
-		task.uploadInfo.Md5Sum()
-
 		// 检测缓存, 通过文件的md5值判断本地文件和网盘文件是否一样
 		fd := pcscache.DirCache.FindFileDirectory(panDir, panFile)
 		if fd != nil {
 			decodedMD5, _ := hex.DecodeString(fd.MD5)
 			if bytes.Compare(decodedMD5, task.uploadInfo.MD5) == 0 {
 				msg = fmt.Sprintf("[%d] 目标文件, %s, 已存在, 跳过...\n", task.ID, task.savePath)
-				fmt.Print(msg)
+				progress.printf("%s", msg)
 				pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+				result.succeeded = append(result.succeeded, task.savePath)
 				continue
 			}
 		}
@@ -375,7 +525,6 @@ func RunUpload(localPaths []string, savePath string) {
 		}
 
 		// 经过测试, 秒传文件并非需要前256kb切片的md5值, 只需格式符合即可
-		task.uploadInfo.SliceMD5Sum()
 
 		// 经测试, 文件的 crc32 值并非秒传文件所必需
 		// task.uploadInfo.crc32Sum()
@@ -383,89 +532,169 @@ func RunUpload(localPaths []string, savePath string) {
 		err := info.RapidUpload(task.savePath, hex.EncodeToString(task.uploadInfo.MD5), hex.EncodeToString(task.uploadInfo.SliceMD5), fmt.Sprint(task.uploadInfo.CRC32), task.uploadInfo.Length)
 		if err == nil {
 			msg = fmt.Sprintf("[%d] 秒传成功, 保存到网盘路径: %s\n", task.ID, task.savePath)
-			fmt.Print(msg)
+			progress.printf("%s", msg)
 			pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
 
 			task.uploadInfo.Close() // 关闭文件
-			totalSize += task.uploadInfo.Length
+			result.totalSize += task.uploadInfo.Length
+			result.succeeded = append(result.succeeded, task.savePath)
 			continue
 		}
 
 		msg = fmt.Sprintf("[%d] 秒传失败, 开始上传文件...\n", task.ID)
-		fmt.Print(msg)
+		progress.printf("%s", msg)
 		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
 
-		// 秒传失败, 开始上传文件
-		err = info.Upload(task.savePath, func(uploadURL string, jar *cookiejar.Jar) (resp *http.Response, uperr error) {
-			h := requester.NewHTTPClient()
-			h.SetCookiejar(jar)
+		// 秒传失败, 开始上传文件, 大文件使用分片上传
+		if task.uploadInfo.Length > minUploadBlockSize {
+			err = uploadBlocks(task, task.uploadInfo.file, task.uploadInfo.Length, progress, control)
+		} else {
+			err = uploadSingle(task, progress, control)
+		}
+		finishTask(task, err)
+	}
 
-			u := uploader.NewUploader(uploadURL, multipartreader.NewFileReadedLen64(task.uploadInfo.file), &uploader.Options{
-				IsMultiPart: true,
-				Client:      h,
-			})
+	return
+}
 
-			exit := make(chan struct{})
+// uploadSavePath 返回本地文件或目录 localPath 上传到网盘的路径,
+// globedPath 为其所在的, 用户指定的本地路径, 在网盘 absSavePath 下保留其目录结构
+func uploadSavePath(absSavePath, globedPath, localPath string) string {
+	globedPathDir := filepath.Dir(globedPath)
+	// 针对 windows 的目录处理
+	if os.PathSeparator == '\\' {
+		localPath = pcsutil.ConvertToUnixPathSeparator(localPath)
+		globedPathDir = pcsutil.ConvertToUnixPathSeparator(globedPathDir)
+	}
+	return path.Clean(absSavePath + "/" + strings.TrimPrefix(localPath, globedPathDir))
+}
 
-			u.OnExecute(func() {
-				ulog := fmt.Sprintf("%s/%d.log", pcsutil.CheckLogPath(), task.ID)
-				msg = fmt.Sprintf("[%d] %s\n", task.ID, ulog)
-				fmt.Print(msg)
-				pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
-				for {
-					select {
-					case v, ok := <-u.UploadStatus:
-						if !ok {
-							return
-						}
-
-						if v.Length == 0 {
-							fmt.Printf("\r[%d] Prepareing upload...", task.ID)
-							continue
-						}
-
-						msg = fmt.Sprintf("\r[%d] ↑ %s/%s %s/s in %s ............", task.ID,
-							pcsutil.ConvertFileSize(v.Uploaded, 2),
-							pcsutil.ConvertFileSize(v.Length, 2),
-							pcsutil.ConvertFileSize(v.Speed, 2),
-							v.TimeElapsed,
-						)
-						fmt.Print(msg)
-						pcsutil.WriteLog(ulog, msg, true)
-					}
+// uploadBlockSize 返回分片上传的分片大小, 分片数不超过 maxUploadBlockNum
+func uploadBlockSize(length int64) int64 {
+	size := int64(minUploadBlockSize)
+	for length > size*maxUploadBlockNum {
+		size *= 2
+	}
+	return size
+}
+
+// watchUploadStatus 上传开始时, 输出日志路径, 并将上传状态写入日志
+func watchUploadStatus(u *uploader.Uploader, task *utask, progress *progressOutput) {
+	u.OnExecute(func() {
+		ulog := fmt.Sprintf("%s/%d.log", pcsutil.CheckLogPath(), task.ID)
+		msg := fmt.Sprintf("[%d] %s\n", task.ID, ulog)
+		progress.printf("%s", msg)
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+		for {
+			select {
+			case v, ok := <-u.UploadStatus:
+				if !ok {
+					return
 				}
-			})
 
-			u.OnFinish(func() {
-				exit <- struct{}{}
-			})
+				if v.Length == 0 && v.Uploaded == 0 {
+					continue
+				}
 
-			<-u.Execute(func(upresp *http.Response, err error) {
-				resp = upresp
-				uperr = err
-			})
+				msg = fmt.Sprintf("[%d] ↑ %s/%s %s/s in %s ............\n", task.ID,
+					pcsutil.ConvertFileSize(v.Uploaded, 2),
+					pcsutil.ConvertFileSize(v.Length, 2),
+					pcsutil.ConvertFileSize(v.Speed, 2),
+					v.TimeElapsed,
+				)
+				pcsutil.WriteLog(ulog, msg, true)
+			}
+		}
+	})
+}
 
-			<-exit
-			close(exit)
-			return
+// uploadSingle 上传单个文件, 一次请求上传整个文件, 上传完成后校验 md5 和大小
+func uploadSingle(task *utask, progress *progressOutput, control *taskControl) error {
+	uploaded, err := info.Upload(task.savePath, func(uploadURL string, jar *cookiejar.Jar) (resp *http.Response, uperr error) {
+		h := requester.NewHTTPClient()
+		h.SetCookiejar(jar)
+
+		u := uploader.NewUploader(uploadURL, multipartreader.NewFileReadedLen64(task.uploadInfo.file), &uploader.Options{
+			IsMultiPart: true,
+			Client:      h,
 		})
+		progress.subscribe(&u.Events, task.ID, task.savePath, task.uploadInfo.Path)
+		watchUploadStatus(u, task, progress)
 
-		fmt.Printf("\n")
+		control.set(task.ID, u)
+		defer control.set(task.ID, nil)
 
-		if err != nil {
-			handleTaskErr(task, "上传文件失败", err)
-			continue
-		}
+		<-u.Execute(func(upresp *http.Response, err error) {
+			resp = upresp
+			uperr = err
+		})
+		return
+	})
+	if err != nil {
+		return err
+	}
 
-		msg = fmt.Sprintf("[%d] 上传文件成功, 保存到网盘路径: %s\n", task.ID, task.savePath)
-		fmt.Print(msg)
-		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
-		task.uploadInfo.Close() // 关闭文件
-		totalSize += task.uploadInfo.Length
+	err = uploader.VerifySize(task.uploadInfo.Length, uploaded.Size)
+	if err != nil {
+		return err
+	}
+	return uploader.VerifyMD5("md5", hex.EncodeToString(task.uploadInfo.MD5), uploaded.MD5)
+}
+
+// uploadBlocks 分片上传 r, length 为 r 的长度, 上传各分片后合并,
+// 各分片上传后校验分片的 md5, 不一致则重新上传该分片, 合并后校验大小
+func uploadBlocks(task *utask, r io.ReaderAt, length int64, progress *progressOutput, control *taskControl) (err error) {
+	h := requester.NewHTTPClient()
+	u := uploader.NewBlockUploader(r, length, uploadBlockSize(length), &uploader.Options{
+		IsMultiPart: true,
+		Client:      h,
+	})
+	progress.subscribe(&u.Events, task.ID, task.savePath, task.uploadInfo.Path)
+	watchUploadStatus(u, task, progress)
+
+	control.set(task.ID, u)
+	blockIDs, err := executeUploadBlocks(u)
+	control.set(task.ID, nil)
+	if err != nil {
+		return err
+	}
+
+	uploaded, err := info.UploadCreateSuperFile(task.savePath, blockIDs...)
+	if err != nil {
+		return err
+	}
+	return uploader.VerifySize(length, uploaded.Size)
+}
+
+// uploadEncrypted 加密上传文件, 网盘文件为头部加上密文, 使用分片上传以校验各分片,
+// 每次上传使用新的初始向量
+func uploadEncrypted(task *utask, key *pcscrypto.Key, progress *progressOutput, control *taskControl) error {
+	header, c, err := key.NewHeader()
+	if err != nil {
+		return err
 	}
+	er := pcscrypto.NewEncryptReaderAt(task.uploadInfo.file, task.uploadInfo.Length, header, c)
+	return uploadBlocks(task, er, er.Len(), progress, control)
+}
+
+// executeUploadBlocks 执行分片上传, 各分片上传后校验分片的 md5, 不一致则重新上传该分片
+func executeUploadBlocks(u *uploader.Uploader) (blockIDs []string, err error) {
+	<-u.ExecuteBlocks(func(id int, upload uploader.UploadFunc) (string, error) {
+		blockMD5, err := info.UploadTmpFile(baidupcs.UploadFunc(upload))
+		if err != nil {
+			return "", err
+		}
 
-	fmt.Printf("\n")
-	fmt.Printf("全部上传完毕, 总大小: %s\n", pcsutil.ConvertFileSize(totalSize))
+		localMD5, err := u.BlockMD5(id)
+		if err != nil {
+			return "", err
+		}
+		return blockMD5, uploader.VerifyMD5(fmt.Sprintf("分片 %d 的 md5", id), localMD5, blockMD5)
+	}, func(ids []string, uperr error) {
+		blockIDs = ids
+		err = uperr
+	})
+	return
 }
 
 // GetFileSum 获取文件的大小, md5, 前256KB切片的 md5, crc32
@@ -491,7 +720,10 @@ func GetFileSum(localPath string, opt *SumOption) (lp *LocalPathInfo, err error)
 		Length: fileStat.Size(),
 	}
 
-	lp.Sum(*opt)
+	err = lp.sumCached(*opt)
+	if err != nil {
+		return nil, err
+	}
 
 	return lp, nil
 }
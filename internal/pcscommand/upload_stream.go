@@ -0,0 +1,104 @@
+package pcscommand
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/iikira/BaiduPCS-Go/requester"
+	"github.com/iikira/BaiduPCS-Go/uploader"
+	"io"
+)
+
+const (
+	// uploadStdin 本地路径为 "-" 时, 从标准输入上传
+	uploadStdin = "-"
+)
+
+// countWriter 统计写入的数据量
+type countWriter struct {
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
+
+// uploadStream 上传长度未知的数据流, 例如标准输入, savePath 为网盘文件路径.
+// 数据流每次读取 minUploadBlockSize 暂存到内存, 作为一个分片上传, 全部上传后合并;
+// 数据流不可重新读取, 只重试失败的分片, 不检测秒传; key 不为 nil 时, 加密上传
+func uploadStream(r io.Reader, name, savePath string, key *pcscrypto.Key, progress *progressOutput, control *taskControl) {
+	task := &utask{
+		ListTask: ListTask{
+			ID: 1,
+		},
+		uploadInfo: &LocalPathInfo{
+			Path: name,
+		},
+		savePath: savePath,
+	}
+
+	// 数据读取后无法重新上传, 先检查网盘路径
+	if fd, err := info.FilesDirectoriesMeta(savePath); err == nil && fd.Isdir {
+		progress.printf("网盘路径 %s 是目录, 从标准输入上传时, 请指定保存的文件路径\n", savePath)
+		return
+	}
+
+	var (
+		m  = md5.New()
+		cw = &countWriter{}
+	)
+
+	r = io.TeeReader(r, m)
+	if key != nil {
+		header, c, err := key.NewHeader()
+		if err != nil {
+			progress.printf("[%d] 加密失败, %s\n", task.ID, err)
+			return
+		}
+		r = pcscrypto.NewEncryptReader(r, header, c)
+	}
+
+	u := uploader.NewStreamUploader(io.TeeReader(r, cw), minUploadBlockSize, maxUploadBlockNum, &uploader.Options{
+		IsMultiPart: true,
+		Client:      requester.NewHTTPClient(),
+	})
+	progress.subscribe(&u.Events, task.ID, task.savePath, task.uploadInfo.Path)
+	watchUploadStatus(u, task, progress)
+
+	msg := fmt.Sprintf("[%d] 开始上传: %s\n", task.ID, name)
+	progress.printf("%s", msg)
+	pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+
+	control.set(task.ID, u)
+	blockIDs, err := executeUploadBlocks(u)
+	control.set(task.ID, nil)
+	if err == uploader.ErrTooManyBlocks {
+		err = fmt.Errorf("数据超过 %s, %s", pcsutil.ConvertFileSize(minUploadBlockSize*maxUploadBlockNum), err)
+	}
+
+	if err == nil {
+		err = createStreamFile(task.savePath, blockIDs, cw.n)
+	}
+	if err != nil {
+		msg = fmt.Sprintf("[%d] 上传文件失败, %s\n", task.ID, err)
+		progress.printf("%s", msg)
+		pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+		return
+	}
+
+	msg = fmt.Sprintf("[%d] 上传文件成功, 保存到网盘路径: %s, 大小: %s, md5: %s\n", task.ID, task.savePath, pcsutil.ConvertFileSize(cw.n), hex.EncodeToString(m.Sum(nil)))
+	progress.printf("%s", msg)
+	pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+}
+
+// createStreamFile 合并数据流的分片, 校验合并后的大小
+func createStreamFile(savePath string, blockIDs []string, length int64) error {
+	uploaded, err := info.UploadCreateSuperFile(savePath, blockIDs...)
+	if err != nil {
+		return err
+	}
+	return uploader.VerifySize(length, uploaded.Size)
+}
@@ -0,0 +1,209 @@
+package pcscommand
+
+import (
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/pcspath"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+const (
+	// uploadIgnoreFile 上传目录时读取的忽略规则文件, gitignore 格式
+	uploadIgnoreFile = ".pcsignore"
+)
+
+// 符号链接的处理方式
+const (
+	symlinksDefault = iota // 上传指向文件的符号链接, 跳过指向目录的符号链接
+	symlinksFollow         // 跟随符号链接, 包括指向目录的
+	symlinksSkip           // 跳过全部符号链接
+)
+
+// uploadWalker 遍历要上传的本地目录
+type uploadWalker struct {
+	includes []string // 只上传匹配的文件
+	excludes []string // 排除匹配的文件和目录
+	symlinks int      // 符号链接的处理方式
+
+	files     []string        // 要上传的文件
+	emptyDirs []string        // 空目录, 需要在网盘创建
+	ancestors map[string]bool // 正在遍历的各层目录的真实路径, 用于检测符号链接造成的循环
+}
+
+// ignoreLevel 某一层目录的 .pcsignore 规则
+type ignoreLevel struct {
+	rel   string // 该目录相对于遍历起点的路径
+	rules *pcspath.IgnoreRules
+}
+
+// newUploadWalker 解析 UploadOptions 中的过滤规则
+func newUploadWalker(options *UploadOptions) (uw *uploadWalker, err error) {
+	uw = &uploadWalker{
+		includes: options.Includes,
+		excludes: options.Excludes,
+	}
+	switch {
+	case options.FollowSymlinks && options.SkipSymlinks:
+		return nil, fmt.Errorf("--follow-symlinks 和 --skip-symlinks 不能同时使用")
+	case options.FollowSymlinks:
+		uw.symlinks = symlinksFollow
+	case options.SkipSymlinks:
+		uw.symlinks = symlinksSkip
+	}
+	return uw, nil
+}
+
+// walk 遍历 root, 将要上传的文件和空目录加入 files 和 emptyDirs,
+// root 为文件时, 直接加入, 不检测过滤规则
+func (uw *uploadWalker) walk(root string) error {
+	fi, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		uw.files = append(uw.files, root)
+		return nil
+	}
+
+	uw.ancestors = map[string]bool{}
+	_, err = uw.walkDir(root, "", nil)
+	return err
+}
+
+// walkDir 遍历目录 dir, rel 为其相对于遍历起点的路径, 返回目录下是否有要上传的文件或目录
+func (uw *uploadWalker) walkDir(dir, rel string, levels []ignoreLevel) (nonEmpty bool, err error) {
+	dirReal := realPath(dir)
+	uw.ancestors[dirReal] = true
+	defer delete(uw.ancestors, dirReal)
+
+	rules, err := readIgnoreFile(filepath.Join(dir, uploadIgnoreFile))
+	if err != nil {
+		fmt.Printf("警告: 读取 %s 失败, %s\n", filepath.Join(dir, uploadIgnoreFile), err)
+	}
+	if rules != nil {
+		levels = append(levels[:len(levels):len(levels)], ignoreLevel{
+			rel:   rel,
+			rules: rules,
+		})
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	for _, fi := range fis {
+		var (
+			name     = filepath.Join(dir, fi.Name())
+			childRel = path.Join(rel, fi.Name())
+		)
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if uw.symlinks == symlinksSkip {
+				continue
+			}
+			target, err := os.Stat(name)
+			if err != nil {
+				fmt.Printf("警告: 跳过无效的符号链接 %s, %s\n", name, err)
+				continue
+			}
+			if target.IsDir() && uw.symlinks != symlinksFollow {
+				fmt.Printf("警告: 跳过指向目录的符号链接 %s, 使用 --follow-symlinks 上传\n", name)
+				continue
+			}
+			fi = target
+		}
+
+		if uw.ignored(childRel, fi.IsDir(), levels) {
+			continue
+		}
+		nonEmpty = true
+
+		if !fi.IsDir() {
+			if uw.included(childRel) {
+				uw.files = append(uw.files, name)
+			}
+			continue
+		}
+
+		// 防止符号链接造成循环
+		if uw.ancestors[realPath(name)] {
+			fmt.Printf("警告: 跳过循环的符号链接 %s\n", name)
+			continue
+		}
+
+		childNonEmpty, err := uw.walkDir(name, childRel, levels)
+		if err != nil {
+			fmt.Printf("警告: %s\n", err)
+			continue
+		}
+		if !childNonEmpty {
+			uw.emptyDirs = append(uw.emptyDirs, name)
+		}
+	}
+	return nonEmpty, nil
+}
+
+// realPath 返回解析符号链接后的绝对路径
+func realPath(dir string) string {
+	p, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		p = dir
+	}
+	p, _ = filepath.Abs(p)
+	return p
+}
+
+// ignored 检测 --exclude 和各层目录的 .pcsignore 规则, 下层目录的规则优先
+func (uw *uploadWalker) ignored(rel string, isdir bool, levels []ignoreLevel) bool {
+	for _, pattern := range uw.excludes {
+		if pcspath.MatchRule(pattern, rel, isdir) {
+			return true
+		}
+	}
+
+	ignored := false
+	for _, level := range levels {
+		levelRel := rel
+		if level.rel != "" {
+			levelRel = rel[len(level.rel)+1:]
+		}
+		if ig, matched := level.rules.Match(levelRel, isdir); matched {
+			ignored = ig
+		}
+	}
+	return ignored
+}
+
+// included 检测 --include 规则, 只针对文件
+func (uw *uploadWalker) included(rel string) bool {
+	if len(uw.includes) == 0 {
+		return true
+	}
+	for _, pattern := range uw.includes {
+		if pcspath.MatchRule(pattern, rel, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded 检测 --exclude 和 --include 规则, 不读取 .pcsignore, 用于同步时过滤网盘中的文件和目录
+func (uw *uploadWalker) excluded(rel string, isdir bool) bool {
+	return uw.ignored(rel, isdir, nil) || !isdir && !uw.included(rel)
+}
+
+// readIgnoreFile 读取忽略规则文件, 文件不存在时返回 nil
+func readIgnoreFile(filename string) (*pcspath.IgnoreRules, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return pcspath.ParseIgnoreRules(f)
+}
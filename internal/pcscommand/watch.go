@@ -0,0 +1,316 @@
+package pcscommand
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/iikira/BaiduPCS-Go/pcscache"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
+	"github.com/iikira/BaiduPCS-Go/pcsverbose"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// watchNotifyDelay 收到文件系统通知后, 等待一段时间再扫描, 合并连续的通知
+	watchNotifyDelay = time.Second
+
+	// watchMaxBackoff 上传失败后重试的最长等待时间
+	watchMaxBackoff = 10 * time.Minute
+)
+
+// WatchOptions 监视目录的可选参数
+type WatchOptions struct {
+	UploadOptions // 遍历本地目录的规则, 计算摘要值的选项, 进度的输出方式
+
+	Interval  time.Duration // 扫描本地目录的间隔
+	Settle    time.Duration // 文件的大小和修改时间在此时间内不变, 才上传
+	NoInotify bool          // 不使用文件系统通知, 只定时扫描
+}
+
+// watchFile 监视的本地文件
+type watchFile struct {
+	path        string
+	size, mtime int64     // 最近一次扫描到的大小和修改时间
+	changed     time.Time // 最近一次发现改变的时间
+	uploaded    bool      // 当前的大小和修改时间已上传
+	failures    int       // 连续上传失败的次数
+	retryAt     time.Time // 上传失败后, 下次重试的时间
+}
+
+// RunWatch 监视本地目录 localDir, 将新增和改变的文件上传到网盘目录 remoteDir, 保留目录结构.
+// 定时扫描本地目录, 支持时 (linux) 使用 inotify 及时发现改变, 文件在 options.Settle 内不再改变才上传,
+// 先检测秒传, 失败再上传, 失败的文件稍后重试. 不删除网盘中的文件, 不创建空目录.
+// 持续运行, 连续两次 Ctrl+C 结束
+func RunWatch(localDir, remoteDir string, options *WatchOptions) {
+	if options == nil {
+		options = &WatchOptions{}
+	}
+	if options.Interval <= 0 {
+		options.Interval = 10 * time.Second
+	}
+	if options.Settle < 0 {
+		options.Settle = 0
+	}
+
+	progress, err := newProgressOutput(options.Progress)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer progress.close()
+
+	remoteDir, err = getAbsPath(remoteDir)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	localDir, err = filepath.Abs(localDir)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	walker, err := newUploadWalker(&options.UploadOptions)
+	if err != nil {
+		progress.printf("%s\n", err)
+		return
+	}
+
+	// 检查本地目录
+	_, err = walkSyncLocal(localDir, walker, progress)
+	if err != nil {
+		progress.printf("遍历本地目录失败, %s\n", err)
+		return
+	}
+
+	// Ctrl+C 取消当前上传, 连续两次 Ctrl+C 结束监视, 不在终端中运行时 Ctrl+C 直接结束
+	control := newTaskControl(progress)
+	defer control.stop()
+
+	var notifier *dirNotifier
+	if !options.NoInotify {
+		notifier, err = newDirNotifier()
+		if err != nil {
+			pcsverbose.Verbosef("DEBUG: 不使用文件系统通知, 只定时扫描, %s\n", err)
+		} else {
+			defer notifier.close()
+		}
+	}
+
+	w := &watcher{
+		localDir:  localDir,
+		remoteDir: remoteDir,
+		options:   options,
+		walker:    walker,
+		notifier:  notifier,
+		files:     map[string]*watchFile{},
+	}
+
+	watchLog(progress, fmt.Sprintf("开始监视 %s, 上传到 %s, 扫描间隔 %s, 文件 %s 内不再改变后上传\n", localDir, remoteDir, options.Interval, options.Settle))
+	if notifier != nil {
+		progress.printf("使用 inotify 监视文件系统的改变\n")
+	}
+	if control.interactive() {
+		progress.printf("连续两次按 Ctrl+C 结束监视\n")
+	}
+
+	w.run(progress, control)
+	watchLog(progress, fmt.Sprintf("结束监视 %s\n", localDir))
+}
+
+// watcher 监视本地目录的状态
+type watcher struct {
+	localDir, remoteDir string
+	options             *WatchOptions
+	walker              *uploadWalker
+	notifier            *dirNotifier
+	files               map[string]*watchFile // 以相对于 localDir 的路径 (以 / 分隔) 为键
+	lastID              int
+	scanned             bool // 已完成首次扫描
+}
+
+func (w *watcher) run(progress *progressOutput, control *taskControl) {
+	var (
+		ticker   = time.NewTicker(time.Second)
+		nextScan time.Time // 下次扫描的时间, 零值为立即扫描
+		notify   <-chan struct{}
+	)
+	defer ticker.Stop()
+	if w.notifier != nil {
+		notify = w.notifier.events()
+	}
+
+	for !control.aborted() {
+		select {
+		case <-notify:
+			if soon := time.Now().Add(watchNotifyDelay); soon.Before(nextScan) {
+				nextScan = soon
+			}
+			continue
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		if now.Before(nextScan) && !w.due(now) {
+			continue
+		}
+
+		if w.scan(now, progress) {
+			w.upload(now, progress, control)
+		}
+		nextScan = time.Now().Add(w.options.Interval)
+	}
+}
+
+// due 是否有文件已到上传或重试的时间, 需要扫描确认
+func (w *watcher) due(now time.Time) bool {
+	for _, wf := range w.files {
+		if w.ready(wf, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// ready 文件是否可以上传
+func (w *watcher) ready(wf *watchFile, now time.Time) bool {
+	return !wf.uploaded && now.Sub(wf.changed) >= w.options.Settle && !now.Before(wf.retryAt)
+}
+
+// scan 扫描本地目录, 更新文件的状态, 返回是否有可以上传的文件
+func (w *watcher) scan(now time.Time, progress *progressOutput) (ready bool) {
+	local, err := walkSyncLocal(w.localDir, w.walker, progress)
+	if err != nil {
+		watchLog(progress, fmt.Sprintf("扫描本地目录失败, %s, 稍后重试\n", err))
+		return false
+	}
+
+	if w.notifier != nil {
+		dirs := []string{w.localDir}
+		for rel := range local.dirs {
+			dirs = append(dirs, filepath.Join(w.localDir, filepath.FromSlash(rel)))
+		}
+		w.notifier.watch(dirs)
+	}
+
+	for rel, lf := range local.files {
+		wf := w.files[rel]
+		switch {
+		case wf == nil:
+			wf = &watchFile{
+				path:    lf.path,
+				size:    lf.size,
+				mtime:   lf.mtime,
+				changed: now,
+			}
+			w.files[rel] = wf
+			if !w.scanned {
+				// 开始监视前已存在的文件, 从修改时间起计算是否不再改变
+				if mtime := time.Unix(lf.mtime, 0); mtime.Before(now) {
+					wf.changed = mtime
+				}
+				break
+			}
+			watchLog(progress, fmt.Sprintf("[新增] %s\n", rel))
+		case wf.size != lf.size || wf.mtime != lf.mtime:
+			if wf.uploaded {
+				watchLog(progress, fmt.Sprintf("[改变] %s\n", rel))
+			}
+			wf.size, wf.mtime, wf.changed, wf.uploaded = lf.size, lf.mtime, now, false
+			wf.failures, wf.retryAt = 0, time.Time{}
+		}
+	}
+
+	if !w.scanned {
+		w.scanned = true
+		watchLog(progress, fmt.Sprintf("本地目录中已有 %d 个文件, 上传网盘中不存在或不同的文件\n", len(w.files)))
+	}
+
+	for rel, wf := range w.files {
+		if local.files[rel] != nil {
+			if w.ready(wf, now) {
+				ready = true
+			}
+			continue
+		}
+		// 不删除网盘中的文件
+		delete(w.files, rel)
+		if !wf.uploaded {
+			watchLog(progress, fmt.Sprintf("[已删除] %s, 未上传\n", rel))
+		}
+	}
+	return
+}
+
+// upload 上传可以上传的文件, 先检测秒传, 网盘中已存在相同文件的跳过
+func (w *watcher) upload(now time.Time, progress *progressOutput, control *taskControl) {
+	rels := make([]string, 0, len(w.files))
+	for rel, wf := range w.files {
+		if w.ready(wf, now) {
+			rels = append(rels, rel)
+		}
+	}
+	sort.Strings(rels)
+
+	var (
+		ulist  = list.New()
+		queued = make(map[string]string, len(rels)) // 网盘路径: 相对路径
+	)
+	for _, rel := range rels {
+		wf := w.files[rel]
+		savePath := path.Join(w.remoteDir, rel)
+		w.lastID++
+		ulist.PushBack(&utask{
+			ListTask: ListTask{
+				ID:       w.lastID,
+				MaxRetry: 3,
+			},
+			uploadInfo: &LocalPathInfo{
+				Path: wf.path,
+			},
+			savePath: savePath,
+		})
+		queued[savePath] = rel
+	}
+
+	// 网盘中的文件可能已被修改, 不使用之前缓存的目录列表
+	pcscache.DirCache.DelAll()
+	result := uploadTasks(ulist, nil, nil, &w.options.UploadOptions, progress, control)
+
+	for _, savePath := range result.succeeded {
+		rel := queued[savePath]
+		delete(queued, savePath)
+		// 上传期间文件可能被修改, 下次扫描时发现, 重新上传
+		if wf := w.files[rel]; wf != nil {
+			wf.uploaded, wf.failures, wf.retryAt = true, 0, time.Time{}
+		}
+	}
+
+	for _, rel := range queued {
+		wf := w.files[rel]
+		if wf == nil {
+			continue
+		}
+		wf.failures++
+		backoff := w.options.Interval << uint(wf.failures-1)
+		if backoff > watchMaxBackoff || backoff <= 0 {
+			backoff = watchMaxBackoff
+		}
+		wf.retryAt = time.Now().Add(backoff)
+		watchLog(progress, fmt.Sprintf("上传 %s 失败 %d 次, %s 后重试\n", rel, wf.failures, backoff))
+	}
+
+	if len(rels) > 0 {
+		watchLog(progress, fmt.Sprintf("本轮上传完毕: 成功 %d, 失败 %d, 总大小: %s\n", len(rels)-len(queued), len(queued), pcsutil.ConvertFileSize(result.totalSize)))
+	}
+}
+
+// watchLog 通过 progress 输出带时间的消息, 并写入日志
+func watchLog(progress *progressOutput, msg string) {
+	msg = time.Now().Format("2006-01-02 15:04:05") + " " + msg
+	progress.printf("%s", msg)
+	pcsutil.WriteLog(pcsutil.CheckBaiduLog(), msg, false)
+}
@@ -0,0 +1,168 @@
+//go:build linux
+// +build linux
+
+package pcscommand
+
+import (
+	"github.com/iikira/BaiduPCS-Go/pcsverbose"
+	"sync"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE
+
+// dirNotifier 使用 inotify 监视目录中的改变, 只通知有改变, 不区分具体的文件
+type dirNotifier struct {
+	fd     int
+	epfd   int
+	wake   [2]int         // 用于唤醒读取线程的管道
+	wds    map[string]int // 已监视的目录及其监视描述符
+	notify chan struct{}
+	done   chan struct{} // 读取线程已结束
+	once   sync.Once
+}
+
+// newDirNotifier 创建 inotify 实例, 使用 epoll 等待事件, 以便 close 时唤醒读取线程
+func newDirNotifier() (*dirNotifier, error) {
+	var err error
+	dn := &dirNotifier{
+		fd:     -1,
+		epfd:   -1,
+		wake:   [2]int{-1, -1},
+		wds:    map[string]int{},
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	defer func() {
+		if err != nil {
+			dn.closeFds()
+		}
+	}()
+
+	dn.fd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Pipe2(dn.wake[:], syscall.O_CLOEXEC|syscall.O_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	dn.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	for _, fd := range []int{dn.fd, dn.wake[0]} {
+		err = syscall.EpollCtl(dn.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+			Events: syscall.EPOLLIN,
+			Fd:     int32(fd),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	go dn.read()
+	return dn, nil
+}
+
+func (dn *dirNotifier) read() {
+	defer func() {
+		dn.closeFds()
+		close(dn.done)
+	}()
+
+	var (
+		events = make([]syscall.EpollEvent, 2)
+		buf    = make([]byte, 64*1024)
+	)
+	for {
+		n, err := syscall.EpollWait(dn.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		for _, ev := range events[:n] {
+			if int(ev.Fd) == dn.wake[0] { // close
+				return
+			}
+		}
+
+		// 读取全部已有的事件, 直到 EAGAIN
+		changed := false
+		for {
+			n, err = syscall.Read(dn.fd, buf)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err == syscall.EAGAIN {
+				break
+			}
+			if err != nil || n <= 0 {
+				return
+			}
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		select {
+		case dn.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// watch 监视目录 dirs, 移除已不在其中的目录的监视.
+// 已监视的目录再次添加时返回相同的监视描述符, 被删除后重新创建的同名目录得到新的描述符
+func (dn *dirNotifier) watch(dirs []string) {
+	var (
+		current = make(map[string]int, len(dirs))
+		inUse   = make(map[int]bool, len(dirs))
+	)
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(dn.fd, dir, inotifyMask)
+		if err != nil {
+			// 例如超过 max_user_watches, 该目录仍会定时扫描
+			pcsverbose.Verbosef("DEBUG: inotify 监视 %s 失败, %s\n", dir, err)
+			continue
+		}
+		current[dir] = wd
+		inUse[wd] = true
+	}
+
+	for dir, wd := range dn.wds {
+		// 重命名的目录, 监视描述符不变, 仍在使用
+		if _, ok := current[dir]; ok || inUse[wd] {
+			continue
+		}
+		// 已删除的目录, 内核已自动移除监视, 返回 EINVAL
+		if _, err := syscall.InotifyRmWatch(dn.fd, uint32(wd)); err != nil && err != syscall.EINVAL {
+			pcsverbose.Verbosef("DEBUG: inotify 移除 %s 的监视失败, %s\n", dir, err)
+		}
+	}
+	dn.wds = current
+}
+
+// events 有改变时收到通知
+func (dn *dirNotifier) events() <-chan struct{} {
+	return dn.notify
+}
+
+// close 唤醒读取线程, 等待其结束后关闭 inotify 实例
+func (dn *dirNotifier) close() {
+	dn.once.Do(func() {
+		syscall.Write(dn.wake[1], []byte{0})
+		<-dn.done
+	})
+}
+
+func (dn *dirNotifier) closeFds() {
+	for _, fd := range []int{dn.epfd, dn.fd, dn.wake[0], dn.wake[1]} {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package pcscommand
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0777)
+
+	dn, err := newDirNotifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dn.watch([]string{dir, sub})
+	if len(dn.wds) != 2 {
+		t.Fatalf("watched %d dirs, want 2", len(dn.wds))
+	}
+
+	ioutil.WriteFile(filepath.Join(sub, "a"), []byte("a"), 0644)
+	select {
+	case <-dn.events():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+
+	// 不在目录树中的目录移除监视
+	dn.watch([]string{dir})
+	if _, ok := dn.wds[sub]; ok || len(dn.wds) != 1 {
+		t.Fatalf("wds = %v, want only %s", dn.wds, dir)
+	}
+
+	// close 唤醒阻塞的读取线程
+	closed := make(chan struct{})
+	go func() {
+		dn.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked")
+	}
+}
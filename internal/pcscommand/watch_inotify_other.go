@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package pcscommand
+
+import (
+	"errors"
+)
+
+// dirNotifier 不支持 inotify 的系统, 只定时扫描
+type dirNotifier struct{}
+
+func newDirNotifier() (*dirNotifier, error) {
+	return nil, errors.New("inotify 仅支持 linux")
+}
+
+func (dn *dirNotifier) watch(dirs []string) {}
+
+func (dn *dirNotifier) events() <-chan struct{} {
+	return nil
+}
+
+func (dn *dirNotifier) close() {}
@@ -19,6 +19,18 @@ type Baidu struct {
 	STOKEN string `json:"stoken"`
 
 	Workdir string `json:"workdir"` // 工作目录
+
+	CryptRoots []*CryptRoot `json:"crypt_roots,omitempty"` // 文件名加密的根目录
+}
+
+// CryptRoot 文件名加密的根目录, 其下的文件名和目录名均加密保存,
+// 密钥派生的参数同网盘中根目录下的元数据文件
+type CryptRoot struct {
+	Path       string `json:"path"`               // 网盘路径
+	Salt       string `json:"salt"`               // 密钥派生的盐, hex 编码
+	Iterations int    `json:"iterations"`         // 密钥派生的迭代次数
+	Check      string `json:"check"`              // 口令的校验值, hex 编码
+	KeyFile    string `json:"key_file,omitempty"` // 口令文件, 为空则从环境变量读取
 }
 
 // BaiduUserList 百度帐号列表
@@ -59,6 +59,9 @@ func (c *PCSConfig) CheckValid() error {
 	if c.MaxParallel <= 0 {
 		return fmt.Errorf("invalid max parallel: %d", c.MaxParallel)
 	}
+	if c.MaxCacheMemory < 0 {
+		return fmt.Errorf("invalid max cache memory: %d", c.MaxCacheMemory)
+	}
 	return nil
 }
 
@@ -24,8 +24,9 @@ type PCSConfig struct {
 
 	AppID int `json:"appid"` // appid
 
-	CacheSize   int `json:"cache_size"`   // 下载缓存
-	MaxParallel int `json:"max_parallel"` // 最大下载并发量
+	CacheSize      int `json:"cache_size"`       // 下载缓存
+	MaxParallel    int `json:"max_parallel"`     // 最大下载并发量
+	MaxCacheMemory int `json:"max_cache_memory"` // 缓存总内存上限, 单位: MB, 0 表示不限制
 
 	UserAgent string `json:"user_agent"` // 浏览器标识
 	SaveDir   string `json:"savedir"`    // 下载储存路径
@@ -38,6 +39,7 @@ func NewConfig() *PCSConfig {
 		AppID:          defaultAppID,
 		CacheSize:      1024,
 		MaxParallel:    100,
+		MaxCacheMemory: 128,
 		SaveDir:        pcsutil.ExecutablePathJoin("BaiduDownload"),
 	}
 }
@@ -87,6 +89,9 @@ func loadConfig() error {
 		setUserAgent(Config.UserAgent)
 	}
 
+	// 设置缓存总内存上限
+	setMaxCacheMemory(Config.MaxCacheMemory)
+
 	return nil
 }
 
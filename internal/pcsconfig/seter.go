@@ -1,6 +1,7 @@
 package pcsconfig
 
 import (
+	"github.com/iikira/BaiduPCS-Go/downloader/cachepool"
 	"github.com/iikira/BaiduPCS-Go/requester"
 )
 
@@ -26,3 +27,8 @@ func setUserAgent(ua string) {
 	Config.UserAgent = ua
 	requester.UserAgent = ua
 }
+
+func setMaxCacheMemory(mb int) {
+	Config.MaxCacheMemory = mb
+	cachepool.SetMaxMemory(int64(mb) << 20)
+}
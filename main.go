@@ -13,6 +13,7 @@ import (
 	"github.com/iikira/BaiduPCS-Go/pcsverbose"
 	"github.com/iikira/BaiduPCS-Go/requester"
 	"github.com/iikira/args"
+	"github.com/json-iterator/go"
 	"github.com/olekukonko/tablewriter"
 	"github.com/urfave/cli"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 var (
@@ -189,6 +191,65 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:      "fetch",
+			Usage:     "多线程下载网址",
+			UsageText: fmt.Sprintf("%s fetch [command options] <网址1> <网址2> ...", app.Name),
+			Description: `使用多线程下载任意网址, 支持断点续传.
+	服务器不支持 HEAD 请求时, 使用 Range: bytes=0-0 探测文件大小.
+	默认保存到当前目录, 文件名取自服务器或网址.
+
+	示例:
+		BaiduPCS-Go fetch https://example.com/1.iso
+		BaiduPCS-Go fetch -o 2.iso -p 16 https://example.com/1.iso
+		BaiduPCS-Go fetch --header "Referer: https://example.com" --cookie-file cookies.txt https://example.com/1.iso
+		BaiduPCS-Go fetch -o download/ https://example.com/1.iso https://example.com/2.iso`,
+			Category: "其他",
+			Before:   reloadFn,
+			Action: func(c *cli.Context) error {
+				if c.NArg() == 0 {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					return nil
+				}
+
+				pcscommand.RunFetch(c.Args(), &pcscommand.FetchOptions{
+					Parallel:        c.Int("p"),
+					IsFixedParallel: c.Bool("fixed"),
+					Output:          c.String("output"),
+					Headers:         c.StringSlice("header"),
+					CookieFile:      c.String("cookie-file"),
+					Progress:        c.String("progress"),
+				})
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "保存的文件路径, 多个网址或以 / 结尾时为保存的目录",
+				},
+				cli.StringSliceFlag{
+					Name:  "header, H",
+					Usage: "附加的请求头, 可重复指定, 例如 --header \"Referer: https://example.com\"",
+				},
+				cli.StringFlag{
+					Name:  "cookie-file",
+					Usage: "载入 Netscape 格式的 cookie 文件 (cookies.txt)",
+				},
+				cli.IntFlag{
+					Name:  "p",
+					Usage: "指定下载线程数 (上限), 程序会根据下载速度自动调整",
+				},
+				cli.BoolFlag{
+					Name:  "fixed",
+					Usage: "固定使用 -p 指定的线程数, 不自动调整",
+				},
+				cli.StringFlag{
+					Name:  "progress",
+					Usage: "进度的输出方式: text, json (每行输出一个 json 格式的事件到标准输出)",
+					Value: "text",
+				},
+			},
+		},
 		{
 			Name:      "login",
 			Usage:     "登录百度账号",
@@ -530,7 +591,7 @@ func main() {
 	通过 BaiduPCS-Go config set -savedir <savedir>, 自定义保存的目录.
 	已支持目录下载.
 	已支持多个文件或目录下载.
-	自动跳过下载重名的文件!`,
+	默认跳过下载重名的文件, 可通过 --on-conflict 修改.`,
 			Category: "百度网盘",
 			Before:   reloadFn,
 			Action: func(c *cli.Context) error {
@@ -539,7 +600,32 @@ func main() {
 					return nil
 				}
 
-				pcscommand.RunDownload(c.Bool("test"), c.Int("p"), c.String("savedir"), c.Args())
+				pcscommand.RunDownload(c.Args(), &pcscommand.DownloadOptions{
+					IsTest:          c.Bool("test"),
+					IsFixedParallel: c.Bool("fixed"),
+					Parallel:        c.Int("p"),
+					SaveTo:          c.String("savedir"),
+					OnConflict:      c.String("on-conflict"),
+					NoMtime:         c.Bool("no-mtime"),
+					IsPreallocate:   c.Bool("prealloc"),
+					SyncInterval:    c.Duration("sync-interval"),
+					Progress:        c.String("progress"),
+					NoMirrors:       c.Bool("no-mirrors"),
+					IsFlat:          c.Bool("flat"),
+					StripComponents: c.Int("strip-components"),
+					RelativeTo:      c.String("relative-to"),
+					Includes:        c.StringSlice("include"),
+					Excludes:        c.StringSlice("exclude"),
+					MinSize:         c.String("min-size"),
+					MaxSize:         c.String("max-size"),
+					NewerThan:       c.String("newer-than"),
+					OlderThan:       c.String("older-than"),
+					Decrypt:         c.Bool("decrypt"),
+					CryptKeyOptions: pcscommand.CryptKeyOptions{
+						Key:     c.String("key"),
+						KeyFile: c.String("key-file"),
+					},
+				})
 				return nil
 			},
 			Flags: []cli.Flag{
@@ -549,12 +635,90 @@ func main() {
 				},
 				cli.IntFlag{
 					Name:  "p",
-					Usage: "指定下载线程数",
+					Usage: "指定下载线程数 (上限), 程序会根据下载速度自动调整",
+				},
+				cli.BoolFlag{
+					Name:  "fixed",
+					Usage: "固定使用 -p 指定的线程数, 不自动调整",
 				},
 				cli.StringFlag{
 					Name:  "savedir",
 					Usage: "指定存储目录",
 				},
+				cli.StringFlag{
+					Name:  "on-conflict",
+					Usage: "本地文件已存在时的处理方式: skip (跳过), overwrite (覆盖), rename (重命名保存), newer (网盘文件较新时覆盖), md5 (md5 不一致时覆盖, 解密下载的加密文件总是覆盖)",
+					Value: "skip",
+				},
+				cli.BoolFlag{
+					Name:  "no-mtime",
+					Usage: "不保留网盘文件和目录的修改时间",
+				},
+				cli.BoolFlag{
+					Name:  "prealloc",
+					Usage: "下载前预分配硬盘空间, 减少磁盘碎片",
+				},
+				cli.DurationFlag{
+					Name:  "sync-interval",
+					Usage: "定期将已下载的数据同步到硬盘, 例如 30s, 默认不同步",
+				},
+				cli.StringFlag{
+					Name:  "progress",
+					Usage: "进度的输出方式: text, json (每行输出一个 json 格式的事件到标准输出)",
+					Value: "text",
+				},
+				cli.BoolFlag{
+					Name:  "no-mirrors",
+					Usage: "不获取其他下载服务器的链接, 只从默认服务器下载",
+				},
+				cli.BoolFlag{
+					Name:  "flat",
+					Usage: "所有文件直接保存到储存目录, 不建立子目录",
+				},
+				cli.IntFlag{
+					Name:  "strip-components",
+					Usage: "去除网盘路径开头的 N 层目录后保存, 例如 1: /apps/a/1.mp4 -> <savedir>/a/1.mp4",
+				},
+				cli.StringFlag{
+					Name:  "relative-to",
+					Usage: "网盘路径相对于此网盘目录保存, 例如 /apps: /apps/a/1.mp4 -> <savedir>/a/1.mp4",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "下载目录时, 只下载匹配的文件, 支持通配符 * ? **, 可重复指定, 例如 --include \"*.mkv\"",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "下载目录时, 排除匹配的文件或目录, 支持通配符 * ? **, 可重复指定, 例如 --exclude node_modules",
+				},
+				cli.StringFlag{
+					Name:  "min-size",
+					Usage: "下载目录时, 只下载不小于此大小的文件, 例如 100KB",
+				},
+				cli.StringFlag{
+					Name:  "max-size",
+					Usage: "下载目录时, 只下载不大于此大小的文件, 例如 1.5GB",
+				},
+				cli.StringFlag{
+					Name:  "newer-than",
+					Usage: "下载目录时, 只下载修改时间晚于此时间的文件, 例如 2018-01-02, 7d (7天内)",
+				},
+				cli.StringFlag{
+					Name:  "older-than",
+					Usage: "下载目录时, 只下载修改时间早于此时间的文件, 例如 2018-01-02 15:04:05, 30d (30天前)",
+				},
+				cli.BoolFlag{
+					Name:  "decrypt",
+					Usage: "解密使用 upload --encrypt 上传的文件, 未加密的文件按原样下载",
+				},
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "解密口令",
+				},
+				cli.StringFlag{
+					Name:  "key-file",
+					Usage: "从文件读取解密口令",
+				},
 			},
 		},
 		{
@@ -562,10 +726,12 @@ func main() {
 			Aliases:   []string{"u"},
 			Usage:     "上传文件或目录",
 			UsageText: fmt.Sprintf("%s upload <本地文件或目录的路径1> <文件或目录2> <文件或目录3> ... <网盘的目标目录>", app.Name),
-			Description: `上传的文件将会保存到, 网盘的目标目录.
+			Description: fmt.Sprintf(`上传的文件将会保存到, 网盘的目标目录.
 	遇到同名文件将会自动覆盖!!
 	当上传的文件名和网盘的目录名称相同时, 不会覆盖目录, 防止丢失数据.
-`,
+	上传目录时, 会在网盘创建空目录, 并读取各目录下的 .pcsignore 文件 (gitignore 格式), 忽略匹配的文件和目录.
+	本地路径为 - 时, 从标准输入上传, 网盘的目标目录为保存的文件路径, 例如: pg_dump mydb | %s upload - /backups/db.sql
+`, app.Name),
 			Category: "百度网盘",
 			Before:   reloadFn,
 			Action: func(c *cli.Context) error {
@@ -576,9 +742,573 @@ func main() {
 
 				subArgs := c.Args()
 
-				pcscommand.RunUpload(subArgs[:c.NArg()-1], subArgs[c.NArg()-1])
+				pcscommand.RunUpload(subArgs[:c.NArg()-1], subArgs[c.NArg()-1], &pcscommand.UploadOptions{
+					Progress:       c.String("progress"),
+					Includes:       c.StringSlice("include"),
+					Excludes:       c.StringSlice("exclude"),
+					FollowSymlinks: c.Bool("follow-symlinks"),
+					SkipSymlinks:   c.Bool("skip-symlinks"),
+					Rehash:         c.Bool("rehash"),
+					HashParallel:   c.Int("hash-parallel"),
+					Encrypt:        c.Bool("encrypt"),
+					CryptKeyOptions: pcscommand.CryptKeyOptions{
+						Key:     c.String("key"),
+						KeyFile: c.String("key-file"),
+					},
+				})
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "progress",
+					Usage: "进度的输出方式: text, json (每行输出一个 json 格式的事件到标准输出)",
+					Value: "text",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "上传目录时, 只上传匹配的文件, 支持通配符 * ? **, 可重复指定, 例如 --include \"*.jpg\"",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "上传目录时, 排除匹配的文件或目录, 支持通配符 * ? **, 可重复指定, 例如 --exclude .git/",
+				},
+				cli.BoolFlag{
+					Name:  "follow-symlinks",
+					Usage: "跟随符号链接, 上传指向目录的符号链接中的文件",
+				},
+				cli.BoolFlag{
+					Name:  "skip-symlinks",
+					Usage: "跳过全部符号链接",
+				},
+				cli.BoolFlag{
+					Name:  "rehash",
+					Usage: "忽略摘要值缓存, 重新计算文件的 md5",
+				},
+				cli.IntFlag{
+					Name:  "hash-parallel",
+					Usage: "同时计算摘要值的文件数, 在上传的同时计算后面的文件, 默认为 CPU 核数, 最多 4",
+				},
+				cli.BoolFlag{
+					Name:  "encrypt",
+					Usage: "加密上传 (aes-256-ctr), 使用 download --decrypt 下载, 加密上传不检测秒传",
+				},
+				cli.StringFlag{
+					Name:  "key",
+					Usage: "加密口令, 未指定 --key 和 --key-file 时, 读取环境变量 " + pcscommand.CryptKeyEnv,
+				},
+				cli.StringFlag{
+					Name:  "key-file",
+					Usage: "从文件读取加密口令",
+				},
+			},
+		},
+		{
+			Name:      "watch",
+			Usage:     "监视本地目录, 自动上传新增和改变的文件",
+			UsageText: fmt.Sprintf("%s watch <本地目录> <网盘目录>", app.Name),
+			Description: `持续监视本地目录, 将新增和改变的文件上传到网盘目录, 保留目录结构.
+	定时扫描本地目录, 在 linux 中同时使用 inotify 及时发现改变.
+	文件的大小和修改时间在 --settle 内不再改变后, 才上传, 避免上传未写完的文件.
+	先检测秒传, 失败再上传, 网盘中已存在相同文件的跳过, 上传失败的文件稍后重试.
+	本地删除的文件不会从网盘中删除, 不创建空目录.
+	遍历本地目录的规则与 upload 相同, 读取 .pcsignore 文件.
+	在终端中连续两次按 Ctrl+C 结束监视, 不在终端中运行时 Ctrl+C 直接结束.`,
+			Category: "百度网盘",
+			Before:   reloadFn,
+			Action: func(c *cli.Context) error {
+				if c.NArg() != 2 {
+					cli.ShowCommandHelp(c, c.Command.Name)
+					return nil
+				}
+
+				pcscommand.RunWatch(c.Args().Get(0), c.Args().Get(1), &pcscommand.WatchOptions{
+					UploadOptions: pcscommand.UploadOptions{
+						Progress:       c.String("progress"),
+						Includes:       c.StringSlice("include"),
+						Excludes:       c.StringSlice("exclude"),
+						FollowSymlinks: c.Bool("follow-symlinks"),
+						SkipSymlinks:   c.Bool("skip-symlinks"),
+						Rehash:         c.Bool("rehash"),
+						HashParallel:   c.Int("hash-parallel"),
+					},
+					Interval:  c.Duration("interval"),
+					Settle:    c.Duration("settle"),
+					NoInotify: c.Bool("no-inotify"),
+				})
+				return nil
+			},
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  "interval",
+					Usage: "扫描本地目录的间隔",
+					Value: 10 * time.Second,
+				},
+				cli.DurationFlag{
+					Name:  "settle",
+					Usage: "文件在此时间内不再改变后才上传",
+					Value: 30 * time.Second,
+				},
+				cli.BoolFlag{
+					Name:  "no-inotify",
+					Usage: "不使用 inotify, 只定时扫描",
+				},
+				cli.StringFlag{
+					Name:  "progress",
+					Usage: "进度的输出方式: text, json (每行输出一个 json 格式的事件到标准输出)",
+					Value: "text",
+				},
+				cli.StringSliceFlag{
+					Name:  "include",
+					Usage: "只上传匹配的文件, 支持通配符 * ? **, 可重复指定",
+				},
+				cli.StringSliceFlag{
+					Name:  "exclude",
+					Usage: "排除匹配的文件或目录, 支持通配符 * ? **, 可重复指定",
+				},
+				cli.BoolFlag{
+					Name:  "follow-symlinks",
+					Usage: "跟随符号链接",
+				},
+				cli.BoolFlag{
+					Name:  "skip-symlinks",
+					Usage: "跳过全部符号链接",
+				},
+				cli.BoolFlag{
+					Name:  "rehash",
+					Usage: "忽略摘要值缓存, 重新计算文件的 md5",
+				},
+				cli.IntFlag{
+					Name:  "hash-parallel",
+					Usage: "同时计算摘要值的文件数, 默认为 CPU 核数, 最多 4",
+				},
+			},
+		},
+		{
+			Name:  "sync",
+			Usage: "同步本地目录和网盘目录",
+			Description: `push: 将本地目录单向同步到网盘目录, 只上传新增和改变的文件.
+	比较文件的大小, 大小相同时比较 md5.
+	使用 --delete 时, 网盘中本地不存在的文件和目录会被移动到 <网盘目录>/.pcstrash/<时间>/, 不会直接删除.
+
+	pull: 将网盘目录单向同步到本地目录, 只下载新增和改变的文件.
+	默认比较文件的大小和修改时间, 使用 --checksum 时, 大小相同则比较 md5.
+	使用 --delete 时, 删除网盘中不存在的本地文件, 要删除的文件超过 --max-delete 时中止, 不做任何修改.
+
+	bisync: 双向同步本地目录和网盘目录, 记录每次同步后各文件的状态, 据此判断文件在哪一边新增, 删除或修改.
+	两边都修改的文件, 按 --conflict 处理: newer 保留修改时间较新的, keep-both 本地的版本加上 .conflict-<时间> 后缀后两者都保留, prompt 逐个询问.
+	一边修改, 另一边删除的文件, 保留修改. 首次同步时不删除任何文件, 两边都有但内容不同的文件两者都保留, 不覆盖.
+
+	遍历本地目录的规则与 upload 相同, 读取 .pcsignore 文件, 被忽略的本地文件不会被覆盖或删除.
+	--include 和 --exclude 对本地和网盘都有效.`,
+			Category: "百度网盘",
+			Before:   reloadFn,
+			Action: func(c *cli.Context) error {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			},
+			Subcommands: []cli.Command{
+				{
+					Name:      "push",
+					Usage:     "将本地目录同步到网盘",
+					UsageText: app.Name + " sync push [--delete] [--dry-run] <本地目录> <网盘目录>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 2 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+
+						pcscommand.RunSyncPush(c.Args().Get(0), c.Args().Get(1), &pcscommand.SyncOptions{
+							UploadOptions: pcscommand.UploadOptions{
+								Progress:       c.String("progress"),
+								Includes:       c.StringSlice("include"),
+								Excludes:       c.StringSlice("exclude"),
+								FollowSymlinks: c.Bool("follow-symlinks"),
+								SkipSymlinks:   c.Bool("skip-symlinks"),
+								Rehash:         c.Bool("rehash"),
+								HashParallel:   c.Int("hash-parallel"),
+							},
+							Delete: c.Bool("delete"),
+							DryRun: c.Bool("dry-run"),
+						})
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "delete",
+							Usage: "将网盘中本地不存在的文件和目录移动到回收站",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "只输出要执行的操作, 不做任何修改",
+						},
+						cli.StringFlag{
+							Name:  "progress",
+							Usage: "进度的输出方式: text, json",
+							Value: "text",
+						},
+						cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "只同步匹配的文件, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "排除匹配的文件或目录, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.BoolFlag{
+							Name:  "follow-symlinks",
+							Usage: "跟随符号链接, 同步指向目录的符号链接中的文件",
+						},
+						cli.BoolFlag{
+							Name:  "skip-symlinks",
+							Usage: "跳过全部符号链接",
+						},
+						cli.BoolFlag{
+							Name:  "rehash",
+							Usage: "忽略摘要值缓存, 重新计算文件的 md5",
+						},
+						cli.IntFlag{
+							Name:  "hash-parallel",
+							Usage: "同时计算摘要值的文件数, 默认为 CPU 核数, 最多 4",
+						},
+					},
+				},
+				{
+					Name:      "pull",
+					Usage:     "将网盘目录同步到本地",
+					UsageText: app.Name + " sync pull [--delete] [--checksum] [--dry-run] <网盘目录> <本地目录>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 2 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+
+						pcscommand.RunSyncPull(c.Args().Get(0), c.Args().Get(1), &pcscommand.SyncOptions{
+							UploadOptions: pcscommand.UploadOptions{
+								Progress:       c.String("progress"),
+								Includes:       c.StringSlice("include"),
+								Excludes:       c.StringSlice("exclude"),
+								FollowSymlinks: c.Bool("follow-symlinks"),
+								SkipSymlinks:   c.Bool("skip-symlinks"),
+								Rehash:         c.Bool("rehash"),
+							},
+							Delete:    c.Bool("delete"),
+							DryRun:    c.Bool("dry-run"),
+							Checksum:  c.Bool("checksum"),
+							MaxDelete: c.Int("max-delete"),
+						})
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  "delete",
+							Usage: "删除网盘中不存在的本地文件",
+						},
+						cli.IntFlag{
+							Name:  "max-delete",
+							Usage: "要删除的本地文件超过此数量时中止, 0 为不限制",
+							Value: 100,
+						},
+						cli.BoolFlag{
+							Name:  "checksum",
+							Usage: "文件大小相同时比较 md5, 而不是修改时间",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "只输出要执行的操作, 不做任何修改",
+						},
+						cli.StringFlag{
+							Name:  "progress",
+							Usage: "进度的输出方式: text, json",
+							Value: "text",
+						},
+						cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "只同步匹配的文件, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "排除匹配的文件或目录, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.BoolFlag{
+							Name:  "follow-symlinks",
+							Usage: "遍历本地目录时, 跟随指向目录的符号链接",
+						},
+						cli.BoolFlag{
+							Name:  "skip-symlinks",
+							Usage: "遍历本地目录时, 跳过全部符号链接",
+						},
+						cli.BoolFlag{
+							Name:  "rehash",
+							Usage: "忽略摘要值缓存, 重新计算文件的 md5",
+						},
+					},
+				},
+				{
+					Name:      "bisync",
+					Usage:     "双向同步本地目录和网盘目录",
+					UsageText: app.Name + " sync bisync [--conflict=newer|keep-both|prompt] [--dry-run] <本地目录> <网盘目录>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 2 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+
+						pcscommand.RunSyncBisync(c.Args().Get(0), c.Args().Get(1), &pcscommand.SyncOptions{
+							UploadOptions: pcscommand.UploadOptions{
+								Progress:       c.String("progress"),
+								Includes:       c.StringSlice("include"),
+								Excludes:       c.StringSlice("exclude"),
+								FollowSymlinks: c.Bool("follow-symlinks"),
+								SkipSymlinks:   c.Bool("skip-symlinks"),
+								Rehash:         c.Bool("rehash"),
+							},
+							DryRun:    c.Bool("dry-run"),
+							MaxDelete: c.Int("max-delete"),
+							Conflict:  c.String("conflict"),
+						})
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "conflict",
+							Usage: "两边都修改的文件的处理方式: newer, keep-both, prompt",
+							Value: "newer",
+						},
+						cli.IntFlag{
+							Name:  "max-delete",
+							Usage: "要删除的文件 (本地和网盘) 超过此数量时中止, 0 为不限制",
+							Value: 100,
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "只输出要执行的操作, 不做任何修改",
+						},
+						cli.StringFlag{
+							Name:  "progress",
+							Usage: "进度的输出方式: text, json",
+							Value: "text",
+						},
+						cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "只同步匹配的文件, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "排除匹配的文件或目录, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.BoolFlag{
+							Name:  "follow-symlinks",
+							Usage: "遍历本地目录时, 跟随指向目录的符号链接",
+						},
+						cli.BoolFlag{
+							Name:  "skip-symlinks",
+							Usage: "遍历本地目录时, 跳过全部符号链接",
+						},
+						cli.BoolFlag{
+							Name:  "rehash",
+							Usage: "忽略摘要值缓存, 重新计算文件的 md5",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "backup",
+			Usage: "备份本地目录, 保留多个版本的快照",
+			Description: `create: 将本地目录备份为新的快照, 上传到 <备份根目录>/<名称>/<时间>/, 时间格式为 20060102-150405.
+	先检测秒传, 未改变的文件不需重新上传. 完成后在快照目录中上传清单 .pcsbackup.json, 记录各文件的大小, 修改时间和 md5.
+	设置了保留策略时, 备份成功后删除过期的快照.
+
+	list: 列出备份根目录下的备份, 指定名称时, 列出该备份的快照.
+
+	restore: 从快照恢复文件到本地, 快照格式为 <名称>/<时间>, 时间为 latest 时使用最新的完整快照.
+	指定路径时, 只恢复快照中的这些文件或目录. 本地已有大小和修改时间相同的文件时跳过, 否则覆盖.
+
+	prune: 按保留策略删除过期的快照. 每项策略保留最近 N 个日, 周, 月中各自最新的完整快照, 最新的完整快照总是保留.
+	有文件备份失败的快照不计入保留策略. 删除的快照可在网盘文件回收站找回.`,
+			Category: "百度网盘",
+			Before:   reloadFn,
+			Action: func(c *cli.Context) error {
+				cli.ShowCommandHelp(c, c.Command.Name)
 				return nil
 			},
+			Subcommands: []cli.Command{
+				{
+					Name:      "create",
+					Usage:     "备份本地目录",
+					UsageText: fmt.Sprintf("%s backup create [command options] <本地目录>", app.Name),
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+
+						pcscommand.RunBackup(c.Args().Get(0), &pcscommand.BackupOptions{
+							UploadOptions: pcscommand.UploadOptions{
+								Progress:       c.String("progress"),
+								Includes:       c.StringSlice("include"),
+								Excludes:       c.StringSlice("exclude"),
+								FollowSymlinks: c.Bool("follow-symlinks"),
+								SkipSymlinks:   c.Bool("skip-symlinks"),
+								Rehash:         c.Bool("rehash"),
+								HashParallel:   c.Int("hash-parallel"),
+							},
+							BackupRetention: pcscommand.BackupRetention{
+								KeepDaily:   c.Int("keep-daily"),
+								KeepWeekly:  c.Int("keep-weekly"),
+								KeepMonthly: c.Int("keep-monthly"),
+							},
+							Root: c.String("root"),
+							Name: c.String("name"),
+						})
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "root",
+							Usage: "网盘中的备份根目录",
+							Value: "/backup",
+						},
+						cli.StringFlag{
+							Name:  "name",
+							Usage: "备份名称, 默认为本地目录名",
+						},
+						cli.IntFlag{
+							Name:  "keep-daily",
+							Usage: "备份成功后, 保留最近 N 天中每天最新的快照",
+						},
+						cli.IntFlag{
+							Name:  "keep-weekly",
+							Usage: "备份成功后, 保留最近 N 周中每周最新的快照",
+						},
+						cli.IntFlag{
+							Name:  "keep-monthly",
+							Usage: "备份成功后, 保留最近 N 个月中每月最新的快照",
+						},
+						cli.StringFlag{
+							Name:  "progress",
+							Usage: "进度的输出方式: text, json (每行输出一个 json 格式的事件到标准输出)",
+							Value: "text",
+						},
+						cli.StringSliceFlag{
+							Name:  "include",
+							Usage: "只备份匹配的文件, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.StringSliceFlag{
+							Name:  "exclude",
+							Usage: "排除匹配的文件或目录, 支持通配符 * ? **, 可重复指定",
+						},
+						cli.BoolFlag{
+							Name:  "follow-symlinks",
+							Usage: "跟随符号链接",
+						},
+						cli.BoolFlag{
+							Name:  "skip-symlinks",
+							Usage: "跳过全部符号链接",
+						},
+						cli.BoolFlag{
+							Name:  "rehash",
+							Usage: "忽略摘要值缓存, 重新计算文件的 md5",
+						},
+						cli.IntFlag{
+							Name:  "hash-parallel",
+							Usage: "同时计算摘要值的文件数, 默认为 CPU 核数, 最多 4",
+						},
+					},
+				},
+				{
+					Name:      "list",
+					Aliases:   []string{"ls"},
+					Usage:     "列出备份或快照",
+					UsageText: fmt.Sprintf("%s backup list [command options] [名称]", app.Name),
+					Action: func(c *cli.Context) error {
+						pcscommand.RunBackupList(c.String("root"), c.Args().Get(0))
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "root",
+							Usage: "网盘中的备份根目录",
+							Value: "/backup",
+						},
+					},
+				},
+				{
+					Name:      "restore",
+					Usage:     "从快照恢复文件",
+					UsageText: fmt.Sprintf("%s backup restore [command options] <名称>/<时间|latest> [快照中的路径1] [路径2] ...", app.Name),
+					Action: func(c *cli.Context) error {
+						if c.NArg() < 1 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+
+						pcscommand.RunBackupRestore(c.Args().Get(0), c.Args().Tail(), &pcscommand.BackupRestoreOptions{
+							Root:     c.String("root"),
+							To:       c.String("to"),
+							Progress: c.String("progress"),
+						})
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "root",
+							Usage: "网盘中的备份根目录",
+							Value: "/backup",
+						},
+						cli.StringFlag{
+							Name:  "to",
+							Usage: "恢复到的本地目录, 默认为当前目录下的 <名称>",
+						},
+						cli.StringFlag{
+							Name:  "progress",
+							Usage: "进度的输出方式: text, json (每行输出一个 json 格式的事件到标准输出)",
+							Value: "text",
+						},
+					},
+				},
+				{
+					Name:      "prune",
+					Usage:     "按保留策略删除过期的快照",
+					UsageText: fmt.Sprintf("%s backup prune [command options] <名称>", app.Name),
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+
+						pcscommand.RunBackupPrune(c.String("root"), c.Args().Get(0), &pcscommand.BackupRetention{
+							KeepDaily:   c.Int("keep-daily"),
+							KeepWeekly:  c.Int("keep-weekly"),
+							KeepMonthly: c.Int("keep-monthly"),
+						}, c.Bool("dry-run"))
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "root",
+							Usage: "网盘中的备份根目录",
+							Value: "/backup",
+						},
+						cli.IntFlag{
+							Name:  "keep-daily",
+							Usage: "保留最近 N 天中每天最新的快照",
+						},
+						cli.IntFlag{
+							Name:  "keep-weekly",
+							Usage: "保留最近 N 周中每周最新的快照",
+						},
+						cli.IntFlag{
+							Name:  "keep-monthly",
+							Usage: "保留最近 N 个月中每月最新的快照",
+						},
+						cli.BoolFlag{
+							Name:  "dry-run",
+							Usage: "只输出要删除的快照, 不做任何修改",
+						},
+					},
+				},
+			},
 		},
 		{
 			Name:        "rapidupload",
@@ -620,8 +1350,8 @@ func main() {
 			Name:        "sumfile",
 			Aliases:     []string{"sf"},
 			Usage:       "获取文件的秒传信息",
-			UsageText:   app.Name + " sumfile <本地文件的路径1> <本地文件的路径2> ...",
-			Description: "获取文件的大小, md5, 前256KB切片的md5, crc32, 可用于秒传文件.",
+			UsageText:   app.Name + " sumfile <本地文件或目录的路径1> <本地文件或目录的路径2> ...",
+			Description: "获取文件的大小, md5, 前256KB切片的md5, crc32, 可用于秒传文件.\n   多个文件同时计算, 按输入的顺序输出.",
 			Category:    "其他",
 			Before:      reloadFn,
 			Action: func(c *cli.Context) error {
@@ -632,20 +1362,44 @@ func main() {
 
 				var (
 					fileName, strLength, strMd5, strSliceMd5, strCrc32 string
+					k                                                  int
+					isJSON                                             = c.Bool("json")
 				)
 
-				for k, filePath := range c.Args() {
-					lp, err := pcscommand.GetFileSum(filePath, &pcscommand.SumOption{
-						IsMD5Sum:      true,
-						IsCRC32Sum:    true,
-						IsSliceMD5Sum: true,
-					})
+				pcscommand.SumFiles(c.Args(), &pcscommand.SumOption{
+					IsMD5Sum:      true,
+					IsCRC32Sum:    true,
+					IsSliceMD5Sum: true,
+					Rehash:        c.Bool("rehash"),
+				}, &pcscommand.SumFilesOptions{
+					Recursive: c.Bool("r"),
+					Parallel:  c.Int("p"),
+				}, func(filePath string, lp *pcscommand.LocalPathInfo, err error) {
+					k++
+					if isJSON {
+						// 每行输出一个 json
+						result := map[string]interface{}{
+							"path": filePath,
+						}
+						if err != nil {
+							result["error"] = err.Error()
+						} else {
+							result["length"] = lp.Length
+							result["md5"] = hex.EncodeToString(lp.MD5)
+							result["slice_md5"] = hex.EncodeToString(lp.SliceMD5)
+							result["crc32"] = lp.CRC32
+						}
+						data, _ := jsoniter.Marshal(result)
+						fmt.Printf("%s\n", data)
+						return
+					}
+
 					if err != nil {
-						fmt.Printf("[%d] %s\n", k+1, err)
-						continue
+						fmt.Printf("[%d] %s\n", k, err)
+						return
 					}
 
-					fmt.Printf("[%d] - [%s]:\n", k+1, filePath)
+					fmt.Printf("[%d] - [%s]:\n", k, filePath)
 
 					strLength, strMd5, strSliceMd5, strCrc32 = strconv.FormatInt(lp.Length, 10), hex.EncodeToString(lp.MD5), hex.EncodeToString(lp.SliceMD5), strconv.FormatUint(uint64(lp.CRC32), 10)
 					fileName = filepath.Base(filePath)
@@ -662,10 +1416,126 @@ func main() {
 					})
 					tb.Render()
 					fmt.Printf("\n")
-				}
+				})
 
 				return nil
 			},
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "rehash",
+					Usage: "忽略摘要值缓存, 重新计算",
+				},
+				cli.BoolFlag{
+					Name:  "r",
+					Usage: "递归计算目录下的所有文件",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "每行输出一个 json 格式的结果",
+				},
+				cli.IntFlag{
+					Name:  "p",
+					Usage: "同时计算的文件数, 默认为 CPU 核数, 最多 4",
+				},
+			},
+		},
+		{
+			Name:        "hashcache",
+			Usage:       "本地文件摘要值缓存",
+			Description: "上传文件和 sumfile 计算的 md5 等摘要值会被缓存, 文件的大小, 修改时间, inode 未改变时, 不再重复计算.",
+			Category:    "其他",
+			Before:      reloadFn,
+			Action: func(c *cli.Context) error {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			},
+			Subcommands: []cli.Command{
+				{
+					Name:      "prune",
+					Usage:     "删除文件已不存在或已改变的缓存",
+					UsageText: app.Name + " hashcache prune",
+					Action: func(c *cli.Context) error {
+						pcscommand.RunHashCachePrune()
+						return nil
+					},
+				},
+				{
+					Name:      "clear",
+					Usage:     "清空缓存",
+					UsageText: app.Name + " hashcache clear",
+					Action: func(c *cli.Context) error {
+						pcscommand.RunHashCacheClear()
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "crypt-root",
+			Usage: "加密根目录, 加密目录下的文件名和目录名",
+			Description: `将网盘目录设为加密根目录后, 目录下的文件名和目录名在网盘中加密保存,
+	ls, cd, meta, upload, download, mv, cp, rm 等命令仍使用明文路径.
+	元数据保存在网盘的 <加密根目录>/.pcscrypt 中, 不含口令, 在其他设备对同一目录执行 init 即可使用.
+	口令从 init 时指定的 --key-file 或环境变量 ` + pcscommand.CryptKeyEnv + ` 读取, 不保存在配置中.
+	文件内容的加密请使用 upload --encrypt.`,
+			Category: "百度网盘",
+			Before:   reloadFn,
+			Action: func(c *cli.Context) error {
+				cli.ShowCommandHelp(c, c.Command.Name)
+				return nil
+			},
+			Subcommands: []cli.Command{
+				{
+					Name:      "init",
+					Usage:     "初始化或添加加密根目录",
+					UsageText: app.Name + " crypt-root init [--key-file=<file>] <网盘目录>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+						pcscommand.RunCryptRootInit(c.Args().Get(0), &pcscommand.CryptKeyOptions{
+							Key:     c.String("key"),
+							KeyFile: c.String("key-file"),
+						})
+						return nil
+					},
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "key",
+							Usage: "加密口令, 未指定 --key 和 --key-file 时, 读取环境变量 " + pcscommand.CryptKeyEnv,
+						},
+						cli.StringFlag{
+							Name:  "key-file",
+							Usage: "从文件读取加密口令, 之后也从此文件读取",
+						},
+					},
+				},
+				{
+					Name:      "list",
+					Aliases:   []string{"ls"},
+					Usage:     "列出加密根目录",
+					UsageText: app.Name + " crypt-root list",
+					Action: func(c *cli.Context) error {
+						pcscommand.RunCryptRootList()
+						return nil
+					},
+				},
+				{
+					Name:      "remove",
+					Aliases:   []string{"rm"},
+					Usage:     "从本地配置中移除加密根目录, 不修改网盘中的文件",
+					UsageText: app.Name + " crypt-root remove <网盘目录>",
+					Action: func(c *cli.Context) error {
+						if c.NArg() != 1 {
+							cli.ShowCommandHelp(c, c.Command.Name)
+							return nil
+						}
+						pcscommand.RunCryptRootRemove(c.Args().Get(0))
+						return nil
+					},
+				},
+			},
 		},
 		{
 			Name:        "offlinedl",
@@ -811,6 +1681,7 @@ func main() {
 					[]string{"user_agent", pcsconfig.Config.UserAgent, "", "浏览器标识"},
 					[]string{"cache_size", strconv.Itoa(pcsconfig.Config.CacheSize), "1024 ~ 262144", "下载缓存, 如果硬盘占用高或下载速度慢, 请尝试调大此值"},
 					[]string{"max_parallel", strconv.Itoa(pcsconfig.Config.MaxParallel), "50 ~ 500", "下载最大并发量"},
+					[]string{"max_cache_memory", strconv.Itoa(pcsconfig.Config.MaxCacheMemory), "64 ~ 1024", "缓存总内存上限, 单位: MB, 0 表示不限制"},
 					[]string{"savedir", pcsconfig.Config.SaveDir, "", "下载文件的储存目录"},
 				})
 				tb.Render()
@@ -867,6 +1738,12 @@ func main() {
 							Value:       pcsconfig.Config.MaxParallel,
 							Destination: &pcsconfig.Config.MaxParallel,
 						},
+						cli.IntFlag{
+							Name:        "max_cache_memory",
+							Usage:       "缓存总内存上限, 单位: MB",
+							Value:       pcsconfig.Config.MaxCacheMemory,
+							Destination: &pcsconfig.Config.MaxCacheMemory,
+						},
 						cli.StringFlag{
 							Name:        "savedir",
 							Usage:       "下载文件的储存目录",
@@ -889,14 +1766,14 @@ func main() {
 					Name:  "showtime",
 					Usage: "显示当前时间(北京时间)",
 					Action: func(c *cli.Context) error {
-						fmt.Printf(pcsutil.BeijingTimeOption("printLog"))
+						fmt.Print(pcsutil.BeijingTimeOption("printLog"))
 						return nil
 					},
 				},
 				{
 					Name:        "enc",
 					Usage:       "加密文件",
-					UsageText:   app.Name + " enc -method=<method> -key=<key> [files...]",
+					UsageText:   app.Name + " enc -key=<key> [files...]",
 					Description: cryptoDescription,
 					Action: func(c *cli.Context) error {
 						if c.NArg() <= 0 {
@@ -904,10 +1781,23 @@ func main() {
 							return nil
 						}
 
+						if c.IsSet("method") {
+							fmt.Printf("警告: -method 已弃用, 加密固定使用 aes-256-gcm, 忽略 -method=%s\n", c.String("method"))
+						}
+
+						key, err := (&pcscommand.CryptKeyOptions{
+							Key:     c.String("key"),
+							KeyFile: c.String("key-file"),
+						}).Passphrase()
+						if err != nil {
+							fmt.Println(err)
+							return nil
+						}
+
 						for _, filePath := range c.Args() {
-							encryptedFilePath, err := pcsutil.EncryptFile(c.String("method"), []byte(c.String("key")), filePath, !c.Bool("disable-gzip"))
+							encryptedFilePath, err := pcsutil.EncryptFile(key, filePath, !c.Bool("disable-gzip"))
 							if err != nil {
-								fmt.Printf("%s\n", err)
+								fmt.Printf("加密 %s 失败, %s\n", filePath, err)
 								continue
 							}
 
@@ -918,14 +1808,17 @@ func main() {
 					},
 					Flags: []cli.Flag{
 						cli.StringFlag{
-							Name:  "method",
-							Usage: "加密方法",
-							Value: "aes-128-ctr",
+							Name:   "method",
+							Usage:  "已弃用, 固定使用 aes-256-gcm",
+							Hidden: true,
 						},
 						cli.StringFlag{
 							Name:  "key",
-							Usage: "加密密钥",
-							Value: app.Name,
+							Usage: "加密口令, 未指定 --key 和 --key-file 时, 读取环境变量 " + pcscommand.CryptKeyEnv,
+						},
+						cli.StringFlag{
+							Name:  "key-file",
+							Usage: "从文件读取加密口令",
 						},
 						cli.BoolFlag{
 							Name:  "disable-gzip",
@@ -936,7 +1829,7 @@ func main() {
 				{
 					Name:        "dec",
 					Usage:       "解密文件",
-					UsageText:   app.Name + " dec -method=<method> -key=<key> [files...]",
+					UsageText:   app.Name + " dec -key=<key> [files...]",
 					Description: cryptoDescription,
 					Action: func(c *cli.Context) error {
 						if c.NArg() <= 0 {
@@ -944,10 +1837,19 @@ func main() {
 							return nil
 						}
 
+						key, err := (&pcscommand.CryptKeyOptions{
+							Key:     c.String("key"),
+							KeyFile: c.String("key-file"),
+						}).Passphrase()
+						if err != nil {
+							// 旧版本的默认密钥
+							key = []byte(app.Name)
+						}
+
 						for _, filePath := range c.Args() {
-							decryptedFilePath, err := pcsutil.DecryptFile(c.String("method"), []byte(c.String("key")), filePath, !c.Bool("disable-gzip"))
+							decryptedFilePath, err := pcsutil.DecryptFile(c.String("method"), key, filePath, !c.Bool("disable-gzip"))
 							if err != nil {
-								fmt.Printf("%s\n", err)
+								fmt.Printf("解密 %s 失败, %s\n", filePath, err)
 								continue
 							}
 
@@ -959,17 +1861,20 @@ func main() {
 					Flags: []cli.Flag{
 						cli.StringFlag{
 							Name:  "method",
-							Usage: "加密方法",
+							Usage: "旧格式文件的加密方法",
 							Value: "aes-128-ctr",
 						},
 						cli.StringFlag{
 							Name:  "key",
-							Usage: "加密密钥",
-							Value: app.Name,
+							Usage: "解密口令, 未指定 --key 和 --key-file 时, 读取环境变量 " + pcscommand.CryptKeyEnv + ", 都未指定时使用旧版本的默认密钥",
+						},
+						cli.StringFlag{
+							Name:  "key-file",
+							Usage: "从文件读取解密口令",
 						},
 						cli.BoolFlag{
 							Name:  "disable-gzip",
-							Usage: "不启用GZIP",
+							Usage: "旧格式文件加密时未启用GZIP",
 						},
 					},
 				},
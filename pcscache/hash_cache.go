@@ -0,0 +1,201 @@
+package pcscache
+
+import (
+	"bufio"
+	"github.com/json-iterator/go"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HashEntry 本地文件的摘要值缓存, 文件的大小, 修改时间, inode 任一改变则失效
+type HashEntry struct {
+	Path     string  `json:"path"`  // 绝对路径
+	Size     int64   `json:"size"`  // 文件大小
+	ModTime  int64   `json:"mtime"` // 修改时间, unix 纳秒
+	Inode    uint64  `json:"inode"` // windows 为 0
+	MD5      string  `json:"md5,omitempty"`
+	SliceMD5 string  `json:"slice_md5,omitempty"`
+	CRC32    *uint32 `json:"crc32,omitempty"`
+}
+
+// matches 检测缓存是否对应文件的当前状态
+func (he *HashEntry) matches(fi os.FileInfo) bool {
+	return he.Size == fi.Size() && he.ModTime == fi.ModTime().UnixNano() && he.Inode == inode(fi)
+}
+
+// hashCache 本地文件摘要值的缓存, 保存到文件, 每行一个 json 格式的 HashEntry,
+// 新的记录追加到文件末尾, 同一路径以最后的记录为准
+type hashCache struct {
+	filename string
+	entries  map[string]*HashEntry
+	lines    int // 文件的记录数, 包括已被覆盖的
+	mu       sync.Mutex
+}
+
+// newHashCache 返回保存到 filename 的摘要值缓存, 首次使用时读取
+func newHashCache(filename string) *hashCache {
+	return &hashCache{
+		filename: filename,
+	}
+}
+
+// load 读取缓存文件, 忽略无法解析的记录
+func (hc *hashCache) load() {
+	if hc.entries != nil {
+		return
+	}
+	hc.entries = map[string]*HashEntry{}
+
+	f, err := os.Open(hc.filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		he := &HashEntry{}
+		if jsoniter.Unmarshal(scanner.Bytes(), he) != nil || he.Path == "" {
+			continue
+		}
+		hc.entries[he.Path] = he
+		hc.lines++
+	}
+
+	// 已被覆盖的记录过多时, 重写缓存文件
+	if hc.lines > 2*len(hc.entries)+1000 {
+		f.Close()
+		hc.rewrite()
+	}
+}
+
+// Get 返回文件 path 的摘要值缓存, fi 为文件的当前状态, 缓存不存在或已失效返回 nil
+func (hc *hashCache) Get(path string, fi os.FileInfo) *HashEntry {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.load()
+
+	he := hc.entries[path]
+	if he == nil || !he.matches(fi) {
+		return nil
+	}
+	copied := *he
+	return &copied
+}
+
+// Put 保存文件 path 的摘要值, 与未失效的缓存合并, he 中为空的摘要值不覆盖已有的
+func (hc *hashCache) Put(path string, fi os.FileInfo, he HashEntry) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.load()
+
+	he.Path, he.Size, he.ModTime, he.Inode = path, fi.Size(), fi.ModTime().UnixNano(), inode(fi)
+	if old := hc.entries[path]; old != nil && old.matches(fi) {
+		if he.MD5 == "" {
+			he.MD5 = old.MD5
+		}
+		if he.SliceMD5 == "" {
+			he.SliceMD5 = old.SliceMD5
+		}
+		if he.CRC32 == nil {
+			he.CRC32 = old.CRC32
+		}
+	}
+	hc.entries[path] = &he
+
+	data, err := jsoniter.Marshal(&he)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(hc.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	hc.lines++
+	return nil
+}
+
+// Len 返回缓存的文件数
+func (hc *hashCache) Len() int {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.load()
+	return len(hc.entries)
+}
+
+// Prune 删除文件已不存在或已改变的缓存, 重写缓存文件, 返回删除的记录数
+func (hc *hashCache) Prune() (removed int, err error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.load()
+
+	for path, he := range hc.entries {
+		fi, err := os.Stat(path)
+		if err != nil || !he.matches(fi) {
+			delete(hc.entries, path)
+			removed++
+		}
+	}
+
+	return removed, hc.rewrite()
+}
+
+// Clear 清空缓存
+func (hc *hashCache) Clear() error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.entries = map[string]*HashEntry{}
+	return hc.rewrite()
+}
+
+// rewrite 重写缓存文件, 去除已被覆盖的记录
+func (hc *hashCache) rewrite() error {
+	tmpname := hc.filename + ".tmp"
+	f, err := os.Create(tmpname)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, he := range hc.entries {
+		data, err := jsoniter.Marshal(he)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Close()
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return err
+	}
+
+	hc.lines = len(hc.entries)
+	return os.Rename(tmpname, hc.filename)
+}
@@ -0,0 +1,55 @@
+package pcscache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		cacheFile = filepath.Join(dir, "cache.jsonl")
+		a         = filepath.Join(dir, "a")
+		b         = filepath.Join(dir, "b")
+		crc32     = uint32(123)
+	)
+	ioutil.WriteFile(a, []byte("aaa"), 0644)
+	ioutil.WriteFile(b, []byte("bbb"), 0644)
+	fa, _ := os.Stat(a)
+	fb, _ := os.Stat(b)
+
+	hc := newHashCache(cacheFile)
+	hc.Put(a, fa, HashEntry{MD5: "md5a"})
+	hc.Put(a, fa, HashEntry{CRC32: &crc32}) // 合并
+	hc.Put(b, fb, HashEntry{MD5: "md5b"})
+
+	// 重新读取
+	hc = newHashCache(cacheFile)
+	he := hc.Get(a, fa)
+	if he == nil || he.MD5 != "md5a" || he.CRC32 == nil || *he.CRC32 != crc32 {
+		t.Fatalf("got %+v", he)
+	}
+
+	// 文件改变后失效
+	ioutil.WriteFile(b, []byte("bbbb"), 0644)
+	fb, _ = os.Stat(b)
+	if he = hc.Get(b, fb); he != nil {
+		t.Fatalf("got %+v, want nil", he)
+	}
+
+	os.Remove(a)
+	removed, err := hc.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 || hc.Len() != 0 {
+		t.Fatalf("removed %d, left %d", removed, hc.Len())
+	}
+}
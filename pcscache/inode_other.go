@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package pcscache
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode 返回文件的 inode
+func inode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
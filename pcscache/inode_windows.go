@@ -0,0 +1,10 @@
+package pcscache
+
+import (
+	"os"
+)
+
+// inode windows 不支持, 只通过文件大小和修改时间判断文件是否改变
+func inode(fi os.FileInfo) uint64 {
+	return 0
+}
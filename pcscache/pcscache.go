@@ -2,6 +2,7 @@ package pcscache
 
 import (
 	"github.com/iikira/BaiduPCS-Go/baidupcs"
+	"github.com/iikira/BaiduPCS-Go/pcsutil"
 	"time"
 )
 
@@ -11,4 +12,7 @@ var (
 		fdl:      map[string]*baidupcs.FileDirectoryList{},
 		lifeTime: 1 * time.Hour,
 	}
+
+	// HashCache 本地文件摘要值缓存, 避免重复计算未改变的文件
+	HashCache = newHashCache(pcsutil.ExecutableUserJoin("pcs_hashcache.jsonl"))
 )
@@ -0,0 +1,171 @@
+package pcscrypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"sync"
+)
+
+var (
+	xorBufPool = sync.Pool{
+		New: func() interface{} {
+			return make([]byte, 32*1024)
+		},
+	}
+)
+
+// Cipher aes-ctr 加密解密, 可从任意偏移量开始, 用于并发的分片上传和多线程下载
+type Cipher struct {
+	block cipher.Block
+	iv    [aes.BlockSize]byte
+}
+
+func newCipher(key []byte, iv [aes.BlockSize]byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{
+		block: block,
+		iv:    iv,
+	}, nil
+}
+
+// XORKeyStreamAt 将 src 与偏移量 offset 处的密钥流异或, 写入 dst
+func (c *Cipher) XORKeyStreamAt(dst, src []byte, offset int64) {
+	iv := c.iv
+	addCounter(&iv, uint64(offset/aes.BlockSize))
+	stream := cipher.NewCTR(c.block, iv[:])
+	if skip := int(offset % aes.BlockSize); skip > 0 {
+		var tmp [aes.BlockSize]byte
+		stream.XORKeyStream(tmp[:skip], tmp[:skip])
+	}
+	stream.XORKeyStream(dst, src)
+}
+
+// addCounter 将初始向量作为大端序的计数器, 加上 n
+func addCounter(iv *[aes.BlockSize]byte, n uint64) {
+	for i := len(iv) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(iv[i]) + n&0xff
+		iv[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+}
+
+// EncryptReaderAt 读取时加密, 数据为头部加上密文
+type EncryptReaderAt struct {
+	r      io.ReaderAt
+	header []byte
+	length int64
+	c      *Cipher
+}
+
+// NewEncryptReaderAt 返回加密 r 的 EncryptReaderAt, length 为 r 的长度
+func NewEncryptReaderAt(r io.ReaderAt, length int64, h *Header, c *Cipher) *EncryptReaderAt {
+	return &EncryptReaderAt{
+		r:      r,
+		header: h.Bytes(),
+		length: length,
+		c:      c,
+	}
+}
+
+// Len 返回加密后的长度
+func (er *EncryptReaderAt) Len() int64 {
+	return int64(len(er.header)) + er.length
+}
+
+// ReadAt 实现 io.ReaderAt
+func (er *EncryptReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < int64(len(er.header)) {
+		n = copy(p, er.header[off:])
+		if n == len(p) {
+			return n, nil
+		}
+	}
+
+	plainOff := off + int64(n) - int64(len(er.header))
+	m, err := er.r.ReadAt(p[n:], plainOff)
+	er.c.XORKeyStreamAt(p[n:n+m], p[n:n+m], plainOff)
+	return n + m, err
+}
+
+// NewEncryptReader 返回顺序读取时加密的 io.Reader, 数据为头部加上密文
+func NewEncryptReader(r io.Reader, h *Header, c *Cipher) io.Reader {
+	iv := c.iv
+	return io.MultiReader(bytes.NewReader(h.Bytes()), &cipher.StreamReader{
+		S: cipher.NewCTR(c.block, iv[:]),
+		R: r,
+	})
+}
+
+// Writer 支持随机写入的 io.WriteCloser, 同 downloader.Writer
+type Writer interface {
+	io.WriteCloser
+	io.WriterAt
+}
+
+// decryptWriter 写入时去除头部并解密
+type decryptWriter struct {
+	w      Writer
+	c      *Cipher
+	offset int64 // 顺序写入的偏移量
+}
+
+// NewDecryptWriter 返回写入时解密的 Writer, 写入的数据为头部加上密文,
+// 偏移量从头部的开始计算, 头部被丢弃, 解密后写入 w
+func NewDecryptWriter(w Writer, c *Cipher) Writer {
+	return &decryptWriter{
+		w: w,
+		c: c,
+	}
+}
+
+func (dw *decryptWriter) Write(p []byte) (n int, err error) {
+	n, err = dw.WriteAt(p, dw.offset)
+	dw.offset += int64(n)
+	return
+}
+
+func (dw *decryptWriter) WriteAt(p []byte, off int64) (n int, err error) {
+	// 丢弃头部
+	if skip := int64(HeaderSize) - off; skip > 0 {
+		if skip >= int64(len(p)) {
+			return len(p), nil
+		}
+		n = int(skip)
+	}
+
+	buf := xorBufPool.Get().([]byte)
+	defer xorBufPool.Put(buf)
+
+	for n < len(p) {
+		chunk := p[n:]
+		if len(chunk) > len(buf) {
+			chunk = chunk[:len(buf)]
+		}
+		plainOff := off + int64(n) - int64(HeaderSize)
+		dw.c.XORKeyStreamAt(buf[:len(chunk)], chunk, plainOff)
+
+		m, err := dw.w.WriteAt(buf[:len(chunk)], plainOff)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Sync 同步到硬盘, w 不支持时不做处理
+func (dw *decryptWriter) Sync() error {
+	if s, ok := dw.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+func (dw *decryptWriter) Close() error {
+	return dw.w.Close()
+}
@@ -0,0 +1,376 @@
+package pcscrypto
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// 分块认证加密的容器格式, 用于加密本地文件:
+//
+//	magic(6) version(1)=2 method(1) flags(1) iterations(4) salt(16) nonce(7) check(8) chunkSize(4)
+//
+// 头部之后为各数据块, 每块最多 chunkSize 字节的明文, 使用 aes-256-gcm 加密, 附加 16 字节的认证标签,
+// 第 n 块的 nonce 为 nonce(7) counter(4) last(1), 最后一块的 last 为 1, 可以为空,
+// 头部作为各块的附加数据, 以检测头部被修改, 数据块被截断, 调换或删除
+const (
+	// MethodAES256GCM aes-256-gcm 分块加密
+	MethodAES256GCM byte = 2
+
+	// FlagGzip 加密前使用 gzip 压缩
+	FlagGzip byte = 1 << 0
+
+	// DefaultChunkSize 默认的数据块大小
+	DefaultChunkSize = 64 * 1024
+
+	containerVersion    = 2
+	containerHeaderSize = len(magic) + 1 + 1 + 1 + 4 + saltSize + noncePrefixSize + checkSize + 4
+	noncePrefixSize     = 7
+	maxChunkSize        = 16 * 1024 * 1024
+)
+
+var (
+	// ErrAuthFailed 认证失败, 数据已损坏或被篡改
+	ErrAuthFailed = errors.New("authentication failed, data is corrupted or tampered")
+)
+
+// containerHeader 容器格式的头部
+type containerHeader struct {
+	flags       byte
+	iterations  uint32
+	salt        [saltSize]byte
+	noncePrefix [noncePrefixSize]byte
+	check       [checkSize]byte
+	chunkSize   uint32
+}
+
+func (ch *containerHeader) bytes() []byte {
+	b := make([]byte, 0, containerHeaderSize)
+	b = append(b, magic...)
+	b = append(b, containerVersion, MethodAES256GCM, ch.flags)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], ch.iterations)
+	b = append(b, ch.salt[:]...)
+	b = append(b, ch.noncePrefix[:]...)
+	b = append(b, ch.check[:]...)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], ch.chunkSize)
+	return b
+}
+
+func parseContainerHeader(b []byte) (*containerHeader, error) {
+	b = b[len(magic)+1:]
+	if b[0] != MethodAES256GCM {
+		return nil, ErrUnsupported
+	}
+
+	ch := &containerHeader{
+		flags:      b[1],
+		iterations: binary.BigEndian.Uint32(b[2:6]),
+	}
+	b = b[6:]
+	b = b[copy(ch.salt[:], b):]
+	b = b[copy(ch.noncePrefix[:], b):]
+	b = b[copy(ch.check[:], b):]
+	ch.chunkSize = binary.BigEndian.Uint32(b)
+	if ch.chunkSize == 0 || ch.chunkSize > maxChunkSize || ch.flags&^FlagGzip != 0 {
+		return nil, ErrUnsupported
+	}
+	if ch.iterations == 0 || ch.iterations > MaxIterations {
+		return nil, ErrIterations
+	}
+	return ch, nil
+}
+
+// chunkAEAD 加密解密各数据块
+type chunkAEAD struct {
+	aead        cipher.AEAD
+	noncePrefix [noncePrefixSize]byte
+	ad          []byte // 附加数据, 即头部
+	counter     uint32
+	nonce       [12]byte
+}
+
+func newChunkAEAD(key []byte, ch *containerHeader) (*chunkAEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &chunkAEAD{
+		aead:        aead,
+		noncePrefix: ch.noncePrefix,
+		ad:          ch.bytes(),
+	}, nil
+}
+
+// next 返回下一块的 nonce
+func (ca *chunkAEAD) next(last bool) ([]byte, error) {
+	if ca.counter == ^uint32(0) {
+		return nil, errors.New("too many chunks")
+	}
+	copy(ca.nonce[:], ca.noncePrefix[:])
+	binary.BigEndian.PutUint32(ca.nonce[noncePrefixSize:], ca.counter)
+	ca.nonce[11] = 0
+	if last {
+		ca.nonce[11] = 1
+	}
+	ca.counter++
+	return ca.nonce[:], nil
+}
+
+// sealWriter 按块加密写入的数据, Close 时写入最后一块
+type sealWriter struct {
+	w   io.Writer
+	ca  *chunkAEAD
+	buf []byte
+	out []byte
+}
+
+func (sw *sealWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// 缓存已满, 且仍有数据, 则缓存不是最后一块
+		if len(sw.buf) == cap(sw.buf) {
+			err = sw.seal(false)
+			if err != nil {
+				return
+			}
+		}
+		m := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (sw *sealWriter) seal(last bool) error {
+	nonce, err := sw.ca.next(last)
+	if err != nil {
+		return err
+	}
+	sw.out = sw.ca.aead.Seal(sw.out[:0], nonce, sw.buf, sw.ca.ad)
+	sw.buf = sw.buf[:0]
+	_, err = sw.w.Write(sw.out)
+	return err
+}
+
+func (sw *sealWriter) Close() error {
+	return sw.seal(true)
+}
+
+// EncryptStream 读取 src, 加密为容器格式写入 dst, compress 为加密前是否使用 gzip 压缩
+func EncryptStream(dst io.Writer, src io.Reader, passphrase []byte, compress bool) error {
+	k, err := NewKey(passphrase)
+	if err != nil {
+		return err
+	}
+
+	ch := &containerHeader{
+		iterations: k.iterations,
+		salt:       k.salt,
+		check:      k.check,
+		chunkSize:  DefaultChunkSize,
+	}
+	if compress {
+		ch.flags |= FlagGzip
+	}
+	_, err = io.ReadFull(rand.Reader, ch.noncePrefix[:])
+	if err != nil {
+		return err
+	}
+
+	ca, err := newChunkAEAD(k.aesKey, ch)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(ca.ad)
+	if err != nil {
+		return err
+	}
+
+	sw := &sealWriter{
+		w:   dst,
+		ca:  ca,
+		buf: make([]byte, 0, ch.chunkSize),
+	}
+	if !compress {
+		_, err = io.Copy(sw, src)
+		if err != nil {
+			return err
+		}
+		return sw.Close()
+	}
+
+	gw := gzip.NewWriter(sw)
+	_, err = io.Copy(gw, src)
+	if err != nil {
+		return err
+	}
+	err = gw.Close()
+	if err != nil {
+		return err
+	}
+	return sw.Close()
+}
+
+// openReader 按块读取并解密, 只返回已通过认证的数据
+type openReader struct {
+	r     *bufio.Reader
+	ca    *chunkAEAD
+	chunk []byte // 读取的密文
+	plain []byte // 未读取的明文
+	done  bool   // 已读取最后一块
+}
+
+func (or *openReader) Read(p []byte) (n int, err error) {
+	for len(or.plain) == 0 {
+		if or.done {
+			return 0, io.EOF
+		}
+		err = or.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, or.plain)
+	or.plain = or.plain[n:]
+	return n, nil
+}
+
+// open 读取并解密下一块
+func (or *openReader) open() error {
+	n, err := io.ReadFull(or.r, or.chunk[:cap(or.chunk)])
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		// 数据不完整, 最后一块不足 chunkSize
+	default:
+		return err
+	}
+
+	// 完整的块之后没有数据, 则为最后一块
+	last := err != nil
+	if !last {
+		if _, perr := or.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+
+	nonce, err := or.ca.next(last)
+	if err != nil {
+		return err
+	}
+	or.plain, err = or.ca.aead.Open(or.chunk[:0], nonce, or.chunk[:n], or.ca.ad)
+	if err != nil {
+		return ErrAuthFailed
+	}
+	or.done = last
+	return nil
+}
+
+// DecryptStream 读取 src 的加密数据, 解密后写入 dst.
+// 支持容器格式, 以及 upload --encrypt 使用的 aes-256-ctr 格式,
+// 口令错误返回 ErrWrongKey, 数据被篡改返回 ErrAuthFailed, 不是以上格式返回 ErrNotEncrypted.
+// 认证失败前可能已写入部分数据, 调用者应丢弃
+func DecryptStream(dst io.Writer, src io.Reader, passphrase []byte) error {
+	br := bufio.NewReader(src)
+	b, _ := br.Peek(len(magic) + 1)
+	if len(b) < len(magic)+1 || !bytes.HasPrefix(b, []byte(magic)) {
+		return ErrNotEncrypted
+	}
+
+	switch b[len(magic)] {
+	case version:
+		return decryptCTRStream(dst, br, passphrase)
+	case containerVersion:
+	default:
+		return ErrUnsupported
+	}
+
+	b = make([]byte, containerHeaderSize)
+	_, err := io.ReadFull(br, b)
+	if err != nil {
+		return ErrNotEncrypted
+	}
+	ch, err := parseContainerHeader(b)
+	if err != nil {
+		return err
+	}
+
+	k := DeriveKey(passphrase, ch.salt, ch.iterations)
+	if subtle.ConstantTimeCompare(k.check[:], ch.check[:]) != 1 {
+		return ErrWrongKey
+	}
+	ca, err := newChunkAEAD(k.aesKey, ch)
+	if err != nil {
+		return err
+	}
+
+	or := &openReader{
+		r:     br,
+		ca:    ca,
+		chunk: make([]byte, 0, int(ch.chunkSize)+ca.aead.Overhead()),
+	}
+	var r io.Reader = or
+	if ch.flags&FlagGzip != 0 {
+		gr, err := gzip.NewReader(or)
+		if err != nil {
+			if err == ErrAuthFailed {
+				return err
+			}
+			return ErrAuthFailed
+		}
+		r = gr
+	}
+
+	_, err = io.Copy(dst, r)
+	if err != nil {
+		return err
+	}
+
+	// 检测最后一块之后没有多余的数据
+	_, err = io.Copy(ioutil.Discard, or)
+	if err != nil {
+		return err
+	}
+	if _, err = br.Peek(1); err != io.EOF {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// decryptCTRStream 解密 aes-256-ctr 格式, 此格式无法检测数据是否被篡改
+func decryptCTRStream(dst io.Writer, br *bufio.Reader, passphrase []byte) error {
+	b := make([]byte, HeaderSize)
+	_, err := io.ReadFull(br, b)
+	if err != nil {
+		return ErrNotEncrypted
+	}
+	h, err := ParseHeader(b)
+	if err != nil {
+		return err
+	}
+	c, err := h.Open(passphrase)
+	if err != nil {
+		return err
+	}
+
+	iv := c.iv
+	_, err = io.Copy(dst, &cipher.StreamReader{
+		S: cipher.NewCTR(c.block, iv[:]),
+		R: br,
+	})
+	return err
+}
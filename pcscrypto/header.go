@@ -0,0 +1,189 @@
+package pcscrypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+const (
+	// MethodAES256CTR aes-256-ctr 加密
+	MethodAES256CTR byte = 1
+
+	// HeaderSize 加密文件头部的长度
+	HeaderSize = len(magic) + 1 + 1 + 4 + saltSize + aes.BlockSize + checkSize
+
+	// DefaultIterations 密钥派生的默认迭代次数
+	DefaultIterations = 100000
+	// MaxIterations 解密时允许的最大迭代次数, 迭代次数读取自文件头部, 过大时密钥派生耗时过长
+	MaxIterations = 10 * DefaultIterations
+
+	magic     = "PCSENC"
+	version   = 1
+	saltSize  = 16
+	checkSize = 8
+)
+
+var (
+	// ErrNotEncrypted 数据不是加密文件的头部
+	ErrNotEncrypted = errors.New("not an encrypted file")
+	// ErrUnsupported 不支持的加密文件版本或加密方法
+	ErrUnsupported = errors.New("unsupported encrypted file version or method")
+	// ErrWrongKey 密钥错误
+	ErrWrongKey = errors.New("wrong key")
+	// ErrIterations 头部中密钥派生的迭代次数为 0 或超过 MaxIterations
+	ErrIterations = errors.New("key derivation iterations out of range")
+)
+
+// Header 加密文件的头部, 记录加密方法, 密钥派生的参数, 初始向量,
+// 以及用于检测密钥是否正确的校验值
+//
+//	magic(6) version(1) method(1) iterations(4) salt(16) iv(16) check(8)
+type Header struct {
+	Method     byte
+	Iterations uint32
+	Salt       [saltSize]byte
+	IV         [aes.BlockSize]byte
+	Check      [checkSize]byte
+}
+
+// ParseHeader 解析加密文件的头部, b 的长度至少为 HeaderSize, 迭代次数超出范围返回 ErrIterations
+func ParseHeader(b []byte) (*Header, error) {
+	if len(b) < HeaderSize || !bytes.HasPrefix(b, []byte(magic)) {
+		return nil, ErrNotEncrypted
+	}
+	b = b[len(magic):]
+	if b[0] != version || b[1] != MethodAES256CTR {
+		return nil, ErrUnsupported
+	}
+
+	h := &Header{
+		Method:     b[1],
+		Iterations: binary.BigEndian.Uint32(b[2:6]),
+	}
+	if h.Iterations == 0 || h.Iterations > MaxIterations {
+		return nil, ErrIterations
+	}
+	b = b[6:]
+	b = b[copy(h.Salt[:], b):]
+	b = b[copy(h.IV[:], b):]
+	copy(h.Check[:], b)
+	return h, nil
+}
+
+// Bytes 编码头部
+func (h *Header) Bytes() []byte {
+	b := make([]byte, 0, HeaderSize)
+	b = append(b, magic...)
+	b = append(b, version, h.Method)
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], h.Iterations)
+	b = append(b, h.Salt[:]...)
+	b = append(b, h.IV[:]...)
+	b = append(b, h.Check[:]...)
+	return b
+}
+
+// Key 由口令派生的密钥
+type Key struct {
+	salt       [saltSize]byte
+	iterations uint32
+	aesKey     []byte
+	check      [checkSize]byte
+}
+
+// DeriveKey 使用 PBKDF2-HMAC-SHA256 由口令派生密钥
+func DeriveKey(passphrase []byte, salt [saltSize]byte, iterations uint32) *Key {
+	dk := pbkdf2SHA256(passphrase, salt[:], int(iterations), 32+checkSize)
+	k := &Key{
+		salt:       salt,
+		iterations: iterations,
+		aesKey:     dk[:32],
+	}
+	copy(k.check[:], dk[32:])
+	return k
+}
+
+// NewKey 使用随机的盐派生密钥, 用于加密
+func NewKey(passphrase []byte) (*Key, error) {
+	var salt [saltSize]byte
+	_, err := io.ReadFull(rand.Reader, salt[:])
+	if err != nil {
+		return nil, err
+	}
+	return DeriveKey(passphrase, salt, DefaultIterations), nil
+}
+
+// NewHeader 返回使用随机初始向量的头部, 以及对应的 Cipher, 每个文件应使用不同的头部
+func (k *Key) NewHeader() (*Header, *Cipher, error) {
+	h := &Header{
+		Method:     MethodAES256CTR,
+		Iterations: k.iterations,
+		Salt:       k.salt,
+		Check:      k.check,
+	}
+	_, err := io.ReadFull(rand.Reader, h.IV[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := newCipher(k.aesKey, h.IV)
+	if err != nil {
+		return nil, nil, err
+	}
+	return h, c, nil
+}
+
+// Open 由口令和头部得到用于解密的 Cipher, 口令错误返回 ErrWrongKey
+func (h *Header) Open(passphrase []byte) (*Cipher, error) {
+	return h.open(DeriveKey(passphrase, h.Salt, h.Iterations))
+}
+
+func (h *Header) open(k *Key) (*Cipher, error) {
+	if subtle.ConstantTimeCompare(k.check[:], h.Check[:]) != 1 {
+		return nil, ErrWrongKey
+	}
+	return newCipher(k.aesKey, h.IV)
+}
+
+// keyID 区分派生的密钥
+type keyID struct {
+	salt       [saltSize]byte
+	iterations uint32
+}
+
+// KeyCache 缓存由同一个口令派生的密钥, 以盐和迭代次数区分.
+// 同一次上传的文件使用同一个密钥, 解密时只需派生一次
+type KeyCache struct {
+	passphrase []byte
+	keys       map[keyID]*Key
+	mu         sync.Mutex
+}
+
+// NewKeyCache 返回口令 passphrase 的密钥缓存
+func NewKeyCache(passphrase []byte) *KeyCache {
+	return &KeyCache{
+		passphrase: passphrase,
+		keys:       map[keyID]*Key{},
+	}
+}
+
+// Open 同 Header.Open, 优先使用已派生的密钥
+func (kc *KeyCache) Open(h *Header) (*Cipher, error) {
+	id := keyID{salt: h.Salt, iterations: h.Iterations}
+
+	kc.mu.Lock()
+	k := kc.keys[id]
+	if k == nil {
+		k = DeriveKey(kc.passphrase, h.Salt, h.Iterations)
+		kc.keys[id] = k
+	}
+	kc.mu.Unlock()
+
+	return h.open(k)
+}
@@ -0,0 +1,277 @@
+package pcscrypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// RFC 7914 第 11 节
+	dk := pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64)
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if hex.EncodeToString(dk) != want {
+		t.Fatalf("got %x", dk)
+	}
+}
+
+func TestXORKeyStreamAt(t *testing.T) {
+	key, iv := make([]byte, 32), [aes.BlockSize]byte{}
+	for k := range iv {
+		iv[k] = 0xff // 测试计数器进位
+	}
+	c, err := newCipher(key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := make([]byte, 1000)
+	cipher.NewCTR(c.block, iv[:]).XORKeyStream(full, full)
+	for _, off := range []int{0, 1, 15, 16, 17, 500, 999} {
+		part := make([]byte, len(full)-off)
+		c.XORKeyStreamAt(part, part, int64(off))
+		if !bytes.Equal(part, full[off:]) {
+			t.Fatalf("offset %d: keystream mismatch", off)
+		}
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	plain := bytes.Repeat([]byte("BaiduPCS-Go"), 10000)
+
+	k, err := NewKey([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, c, err := k.NewHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	er := NewEncryptReaderAt(bytes.NewReader(plain), int64(len(plain)), h, c)
+	encrypted, err := ioutil.ReadAll(io.NewSectionReader(er, 0, er.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streamed, err := ioutil.ReadAll(NewEncryptReader(bytes.NewReader(plain), h, c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encrypted, streamed) {
+		t.Fatal("EncryptReader and EncryptReaderAt mismatch")
+	}
+
+	parsed, err := ParseHeader(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = parsed.Open([]byte("wrong")); err != ErrWrongKey {
+		t.Fatalf("wrong key: %v", err)
+	}
+	dc, err := parsed.Open([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 乱序分段写入, 模拟多线程下载
+	f, err := ioutil.TempFile("", "pcscrypto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	w := NewDecryptWriter(f, dc)
+	for _, r := range [][2]int{{30000, len(encrypted)}, {10, 30000}, {0, 10}} {
+		if _, err = w.WriteAt(encrypted[r[0]:r[1]], int64(r[0])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	decrypted, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plain) {
+		t.Fatal("decrypted data mismatch")
+	}
+
+	if _, err = ParseHeader(plain); err != ErrNotEncrypted {
+		t.Fatalf("plain: %v", err)
+	}
+
+	// 篡改的头部, 迭代次数过大时不派生密钥
+	for _, iterations := range []uint32{0, MaxIterations + 1, 4e9} {
+		bad := *parsed
+		bad.Iterations = iterations
+		if _, err = ParseHeader(bad.Bytes()); err != ErrIterations {
+			t.Fatalf("iterations %d: %v", iterations, err)
+		}
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	k, err := NewKey([]byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h1, c1, _ := k.NewHeader()
+	h2, c2, _ := k.NewHeader()
+
+	kc := NewKeyCache([]byte("passphrase"))
+	for _, tt := range []struct {
+		h *Header
+		c *Cipher
+	}{{h1, c1}, {h2, c2}} {
+		dc, err := kc.Open(tt.h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, want, got := []byte("BaiduPCS-Go"), make([]byte, 11), make([]byte, 11)
+		tt.c.XORKeyStreamAt(want, src, 5)
+		dc.XORKeyStreamAt(got, src, 5)
+		if !bytes.Equal(got, want) {
+			t.Fatal("cipher mismatch")
+		}
+	}
+	if len(kc.keys) != 1 {
+		t.Fatalf("derived %d keys, want 1", len(kc.keys))
+	}
+
+	if _, err = NewKeyCache([]byte("wrong")).Open(h1); err != ErrWrongKey {
+		t.Fatalf("wrong key: %v", err)
+	}
+}
+
+func TestContainer(t *testing.T) {
+	passphrase := []byte("passphrase")
+	for _, size := range []int{0, 1, DefaultChunkSize, DefaultChunkSize + 1, 3 * DefaultChunkSize} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+
+		for _, compress := range []bool{false, true} {
+			encrypted := &bytes.Buffer{}
+			err := EncryptStream(encrypted, bytes.NewReader(plain), passphrase, compress)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decrypted := &bytes.Buffer{}
+			err = DecryptStream(decrypted, bytes.NewReader(encrypted.Bytes()), passphrase)
+			if err != nil {
+				t.Fatalf("size %d, compress %v: %s", size, compress, err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), plain) {
+				t.Fatalf("size %d, compress %v: decrypted data mismatch", size, compress)
+			}
+
+			err = DecryptStream(ioutil.Discard, bytes.NewReader(encrypted.Bytes()), []byte("wrong"))
+			if err != ErrWrongKey {
+				t.Fatalf("wrong key: %v", err)
+			}
+
+			data := encrypted.Bytes()
+			tampered := append([]byte{}, data...)
+			tampered[len(tampered)-1] ^= 1
+			truncated := data[:len(data)-1]
+			if size > DefaultChunkSize && !compress {
+				truncated = data[:containerHeaderSize+DefaultChunkSize+16] // 去除最后一块
+			}
+			appended := append(append([]byte{}, data...), 0)
+			for name, b := range map[string][]byte{"tampered": tampered, "truncated": truncated, "appended": appended} {
+				err = DecryptStream(ioutil.Discard, bytes.NewReader(b), passphrase)
+				if err != ErrAuthFailed {
+					t.Fatalf("size %d, compress %v, %s: %v", size, compress, name, err)
+				}
+			}
+		}
+	}
+
+	// 篡改头部中的迭代次数
+	encrypted := &bytes.Buffer{}
+	if err := EncryptStream(encrypted, bytes.NewReader([]byte("hello")), passphrase, false); err != nil {
+		t.Fatal(err)
+	}
+	tampered := encrypted.Bytes()
+	copy(tampered[len(magic)+3:], []byte{0xee, 0x6b, 0x28, 0x00}) // 4e9
+	if err := DecryptStream(ioutil.Discard, bytes.NewReader(tampered), passphrase); err != ErrIterations {
+		t.Fatalf("iterations: %v", err)
+	}
+}
+
+func TestDecryptCTRStream(t *testing.T) {
+	plain := bytes.Repeat([]byte("BaiduPCS-Go"), 1000)
+	k, _ := NewKey([]byte("passphrase"))
+	h, c, _ := k.NewHeader()
+	encrypted, _ := ioutil.ReadAll(NewEncryptReader(bytes.NewReader(plain), h, c))
+
+	decrypted := &bytes.Buffer{}
+	err := DecryptStream(decrypted, bytes.NewReader(encrypted), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted.Bytes(), plain) {
+		t.Fatal("decrypted data mismatch")
+	}
+
+	if err = DecryptStream(ioutil.Discard, bytes.NewReader(plain), nil); err != ErrNotEncrypted {
+		t.Fatalf("plain: %v", err)
+	}
+}
+
+func TestSIV(t *testing.T) {
+	// RFC 5297 A.1
+	key, _ := hex.DecodeString("fffefdfcfbfaf9f8f7f6f5f4f3f2f1f0f0f1f2f3f4f5f6f7f8f9fafbfcfdfeff")
+	ad, _ := hex.DecodeString("101112131415161718191a1b1c1d1e1f2021222324252627")
+	plain, _ := hex.DecodeString("112233445566778899aabbccddee")
+	s, err := newSIV(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := s.seal(plain, ad)
+	if hex.EncodeToString(sealed) != "85632d07c6e8f37f950acd320a2ecc9340c02b9690c4dc04daef7f6afe5c" {
+		t.Fatalf("got %x", sealed)
+	}
+	opened, err := s.open(sealed, ad)
+	if err != nil || !bytes.Equal(opened, plain) {
+		t.Fatalf("open: %x, %v", opened, err)
+	}
+	sealed[0] ^= 1
+	if _, err = s.open(sealed, ad); err != ErrAuthFailed {
+		t.Fatalf("tampered: %v", err)
+	}
+}
+
+func TestNameCipher(t *testing.T) {
+	nc, _, err := NewNameCipher([]byte("passphrase"), []byte("salt"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"", "a", "我的资源", "0123456789abcdef", "1.mp4"} {
+		encrypted, err := nc.EncryptName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, _ := nc.EncryptName(name)
+		if again != encrypted || strings.ToLower(encrypted) != encrypted {
+			t.Fatalf("%s: %s, %s", name, encrypted, again)
+		}
+		decrypted, err := nc.DecryptName(encrypted)
+		if err != nil || decrypted != name {
+			t.Fatalf("%s: %s, %v", name, decrypted, err)
+		}
+	}
+
+	if _, err = nc.DecryptName("1.mp4"); err != ErrNotEncryptedName {
+		t.Fatalf("plain name: %v", err)
+	}
+	if _, err = nc.EncryptName(strings.Repeat("a", 200)); err != ErrNameTooLong {
+		t.Fatalf("long name: %v", err)
+	}
+}
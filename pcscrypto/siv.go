@@ -0,0 +1,195 @@
+package pcscrypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+const (
+	// MaxEncryptedNameLen 加密后的文件名的最大长度
+	MaxEncryptedNameLen = 255
+
+	nameKeySize = 64 // aes-256-siv
+)
+
+var (
+	// ErrNameTooLong 文件名过长, 加密后超过 MaxEncryptedNameLen
+	ErrNameTooLong = errors.New("file name too long to encrypt")
+	// ErrNotEncryptedName 文件名不是加密的文件名, 或密钥错误
+	ErrNotEncryptedName = errors.New("not an encrypted file name")
+
+	nameEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// siv AES-SIV 确定性认证加密, 见 RFC 5297
+type siv struct {
+	mac cipher.Block // S2V 使用的 aes-cmac
+	ctr cipher.Block
+}
+
+// newSIV key 的前半部分用于 S2V, 后半部分用于 ctr 加密, 长度为 32, 48 或 64
+func newSIV(key []byte) (*siv, error) {
+	mac, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &siv{
+		mac: mac,
+		ctr: ctr,
+	}, nil
+}
+
+// dbl 在 GF(2^128) 中乘以 x
+func dbl(b *[aes.BlockSize]byte) {
+	carry := b[0] >> 7
+	for i := 0; i < aes.BlockSize-1; i++ {
+		b[i] = b[i]<<1 | b[i+1]>>7
+	}
+	b[aes.BlockSize-1] = b[aes.BlockSize-1]<<1 ^ carry*0x87
+}
+
+// xorBytes dst[i] = a[i] ^ b[i], 长度为 b 的长度
+func xorBytes(dst, a, b []byte) {
+	for i := range b {
+		dst[i] = a[i] ^ b[i]
+	}
+}
+
+// cmac AES-CMAC, 见 RFC 4493
+func cmac(block cipher.Block, msg []byte) (mac [aes.BlockSize]byte) {
+	var k1, k2 [aes.BlockSize]byte
+	block.Encrypt(k1[:], k1[:])
+	dbl(&k1)
+	k2 = k1
+	dbl(&k2)
+
+	// 最后一块完整时与 k1 异或, 否则填充后与 k2 异或
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+	var last [aes.BlockSize]byte
+	rest := msg[(n-1)*aes.BlockSize:]
+	if len(rest) == aes.BlockSize {
+		xorBytes(last[:], rest, k1[:])
+	} else {
+		copy(last[:], rest)
+		last[len(rest)] = 0x80
+		xorBytes(last[:], last[:], k2[:])
+	}
+
+	for i := 0; i < n-1; i++ {
+		xorBytes(mac[:], mac[:], msg[i*aes.BlockSize:(i+1)*aes.BlockSize])
+		block.Encrypt(mac[:], mac[:])
+	}
+	xorBytes(mac[:], mac[:], last[:])
+	block.Encrypt(mac[:], mac[:])
+	return
+}
+
+// s2v 由附加数据和明文计算合成的初始向量
+func (s *siv) s2v(plaintext []byte, ad ...[]byte) [aes.BlockSize]byte {
+	var zero [aes.BlockSize]byte
+	d := cmac(s.mac, zero[:])
+	for _, a := range ad {
+		dbl(&d)
+		m := cmac(s.mac, a)
+		xorBytes(d[:], d[:], m[:])
+	}
+
+	var t []byte
+	if len(plaintext) >= aes.BlockSize {
+		t = append([]byte{}, plaintext...)
+		end := t[len(t)-aes.BlockSize:]
+		xorBytes(end, end, d[:])
+	} else {
+		dbl(&d)
+		var padded [aes.BlockSize]byte
+		copy(padded[:], plaintext)
+		padded[len(plaintext)] = 0x80
+		xorBytes(d[:], d[:], padded[:])
+		t = d[:]
+	}
+	return cmac(s.mac, t)
+}
+
+// xorCTR 以 v 为计数器加密或解密, 计数器的第 31 和 63 位清零
+func (s *siv) xorCTR(dst, src []byte, v [aes.BlockSize]byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(dst, src)
+}
+
+// seal 加密, 返回 v || 密文
+func (s *siv) seal(plaintext []byte, ad ...[]byte) []byte {
+	v := s.s2v(plaintext, ad...)
+	out := make([]byte, aes.BlockSize+len(plaintext))
+	copy(out, v[:])
+	s.xorCTR(out[aes.BlockSize:], plaintext, v)
+	return out
+}
+
+// open 解密并认证, 失败返回 ErrAuthFailed
+func (s *siv) open(ciphertext []byte, ad ...[]byte) ([]byte, error) {
+	if len(ciphertext) < aes.BlockSize {
+		return nil, ErrAuthFailed
+	}
+	var v [aes.BlockSize]byte
+	copy(v[:], ciphertext)
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	s.xorCTR(plaintext, ciphertext[aes.BlockSize:], v)
+
+	expected := s.s2v(plaintext, ad...)
+	if subtle.ConstantTimeCompare(expected[:], v[:]) != 1 {
+		return nil, ErrAuthFailed
+	}
+	return plaintext, nil
+}
+
+// NameCipher 确定性地加密文件名, 同一密钥下相同的文件名加密结果相同,
+// 使用 aes-256-siv 加密, 小写的 base32 编码, 可用于不区分大小写的文件系统
+type NameCipher struct {
+	s *siv
+}
+
+// NewNameCipher 使用 PBKDF2-HMAC-SHA256 由口令派生文件名加密的密钥, 返回用于检测口令是否正确的校验值
+func NewNameCipher(passphrase, salt []byte, iterations int) (nc *NameCipher, check []byte, err error) {
+	dk := pbkdf2SHA256(passphrase, salt, iterations, nameKeySize+checkSize)
+	s, err := newSIV(dk[:nameKeySize])
+	if err != nil {
+		return nil, nil, err
+	}
+	return &NameCipher{
+		s: s,
+	}, dk[nameKeySize:], nil
+}
+
+// EncryptName 加密文件名
+func (nc *NameCipher) EncryptName(name string) (string, error) {
+	encrypted := strings.ToLower(nameEncoding.EncodeToString(nc.s.seal([]byte(name))))
+	if len(encrypted) > MaxEncryptedNameLen {
+		return "", ErrNameTooLong
+	}
+	return encrypted, nil
+}
+
+// DecryptName 解密文件名, 不是加密的文件名返回 ErrNotEncryptedName
+func (nc *NameCipher) DecryptName(encrypted string) (string, error) {
+	data, err := nameEncoding.DecodeString(strings.ToUpper(encrypted))
+	if err != nil {
+		return "", ErrNotEncryptedName
+	}
+	name, err := nc.s.open(data)
+	if err != nil {
+		return "", ErrNotEncryptedName
+	}
+	return string(name), nil
+}
@@ -0,0 +1,105 @@
+// Package pcsevent 传输事件, 下载器和上传器通过事件报告传输状态
+package pcsevent
+
+import (
+	"sync"
+	"time"
+)
+
+// Type 事件类型
+type Type string
+
+const (
+	// Started 开始传输
+	Started Type = "started"
+	// Progress 传输进度, 每秒一次
+	Progress Type = "progress"
+	// Retry 出错重试
+	Retry Type = "retry"
+	// Paused 暂停
+	Paused Type = "paused"
+	// Resumed 恢复
+	Resumed Type = "resumed"
+	// Finished 传输完成
+	Finished Type = "finished"
+	// Failed 传输失败
+	Failed Type = "failed"
+)
+
+const (
+	// KindDownload 下载
+	KindDownload = "download"
+	// KindUpload 上传
+	KindUpload = "upload"
+)
+
+// BlockStat 下载区块的状态
+type BlockStat struct {
+	ID    int   `json:"id"`
+	Begin int64 `json:"begin"`
+	End   int64 `json:"end"`
+	Speed int64 `json:"speed"` // 速度, 每秒
+	Done  bool  `json:"done"`
+}
+
+// Event 传输事件
+type Event struct {
+	Type      Type      `json:"type"`
+	Kind      string    `json:"kind"` // download, upload
+	Time      time.Time `json:"time"`
+	TaskID    int       `json:"task_id,omitempty"`
+	Path      string    `json:"path,omitempty"`       // 网盘路径
+	LocalPath string    `json:"local_path,omitempty"` // 本地路径
+
+	Total       int64         `json:"total"`       // 总大小
+	Transferred int64         `json:"transferred"` // 已传输的数据量
+	Speed       int64         `json:"speed"`       // 速度, 每秒
+	Elapsed     time.Duration `json:"elapsed"`     // 传输的时间, 单位: 纳秒
+	Connections int           `json:"connections,omitempty"`
+	Blocks      []BlockStat   `json:"blocks,omitempty"`
+
+	BlockID int    `json:"block_id,omitempty"` // 重试的区块
+	Attempt int    `json:"attempt,omitempty"`  // 第几次重试
+	Error   string `json:"error,omitempty"`
+}
+
+// Handler 事件处理函数
+type Handler func(e *Event)
+
+// Emitter 发布事件, 处理函数按订阅的顺序同步调用,
+// 零值可直接使用
+type Emitter struct {
+	handlers []Handler
+	mu       sync.RWMutex
+}
+
+// Subscribe 订阅事件
+func (em *Emitter) Subscribe(h Handler) {
+	if h == nil {
+		return
+	}
+
+	em.mu.Lock()
+	em.handlers = append(em.handlers, h)
+	em.mu.Unlock()
+}
+
+// HasSubscriber 是否有订阅者, 没有订阅者时, 可以不生成事件
+func (em *Emitter) HasSubscriber() bool {
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	return len(em.handlers) != 0
+}
+
+// Emit 发布事件, 未设置时间的, 设为当前时间
+func (em *Emitter) Emit(e *Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	em.mu.RLock()
+	defer em.mu.RUnlock()
+	for _, h := range em.handlers {
+		h(e)
+	}
+}
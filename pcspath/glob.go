@@ -0,0 +1,75 @@
+package pcspath
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob 检测路径 name 是否匹配通配符 pattern,
+// 支持 path.Match 的语法, 另外 "**" 可以匹配零个或多个目录,
+// 例如 "a/**/*.mkv" 可以匹配 "a/1.mkv", "a/b/c/1.mkv"
+func MatchGlob(pattern, name string) bool {
+	return matchSegments(splitSegments(pattern), splitSegments(name))
+}
+
+// MatchRule 按照 gitignore 的规则, 检测相对路径 rel 是否匹配 pattern:
+// pattern 以 "/" 结尾时, 只匹配目录;
+// pattern 不含 "/" 时, 匹配任意层级的文件名或目录名, 例如 "*.mkv", "node_modules";
+// pattern 含有 "/" 时, 从 rel 的起始位置开始匹配, 例如 "video/**/*.mkv"
+func MatchRule(pattern, rel string, isdir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isdir {
+			return false
+		}
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(rel))
+		return ok
+	}
+
+	return MatchGlob(pattern, rel)
+}
+
+// splitSegments 以 "/" 分割路径, 忽略空的部分
+func splitSegments(p string) (segs []string) {
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "" {
+			continue
+		}
+		segs = append(segs, seg)
+	}
+	return
+}
+
+func matchSegments(patterns, names []string) bool {
+	for len(patterns) > 0 {
+		if patterns[0] == "**" {
+			patterns = patterns[1:]
+			if len(patterns) == 0 {
+				return true
+			}
+
+			// 尝试跳过零个或多个目录
+			for k := range names {
+				if matchSegments(patterns, names[k:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(names) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(patterns[0], names[0]); !ok {
+			return false
+		}
+
+		patterns, names = patterns[1:], names[1:]
+	}
+
+	return len(names) == 0
+}
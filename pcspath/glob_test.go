@@ -0,0 +1,50 @@
+package pcspath
+
+import (
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		matched       bool
+	}{
+		{"*.mkv", "1.mkv", true},
+		{"*.mkv", "a/1.mkv", false},
+		{"a/*.mkv", "a/1.mkv", true},
+		{"**/*.mkv", "1.mkv", true},
+		{"**/*.mkv", "a/b/c/1.mkv", true},
+		{"a/**/*.mkv", "a/1.mkv", true},
+		{"a/**/*.mkv", "b/a/1.mkv", false},
+		{"**/node_modules/**", "x/node_modules/y/z.js", true},
+		{"build/**", "build", true},
+		{"/a/b", "a/b", true},
+		{"a/?", "a/bc", false},
+	}
+
+	for _, c := range cases {
+		if MatchGlob(c.pattern, c.name) != c.matched {
+			t.Errorf("MatchGlob(%q, %q) != %v", c.pattern, c.name, c.matched)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	cases := []struct {
+		pattern, rel string
+		isdir, matched bool
+	}{
+		{"*.mkv", "a/b/1.mkv", false, true},
+		{"node_modules", "a/node_modules", true, true},
+		{"node_modules/", "a/node_modules", false, false},
+		{"video/*.mkv", "video/1.mkv", false, true},
+		{"video/*.mkv", "a/video/1.mkv", false, false},
+		{"/build", "build", true, true},
+	}
+
+	for _, c := range cases {
+		if MatchRule(c.pattern, c.rel, c.isdir) != c.matched {
+			t.Errorf("MatchRule(%q, %q, %v) != %v", c.pattern, c.rel, c.isdir, c.matched)
+		}
+	}
+}
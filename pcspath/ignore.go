@@ -0,0 +1,63 @@
+package pcspath
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// IgnoreRules gitignore 格式的忽略规则, 例如 .pcsignore 文件,
+// 支持 # 注释, ! 取反, 规则的语法同 MatchRule, 后面的规则优先
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern string
+	negate  bool
+}
+
+// ParseIgnoreRules 按行读取 gitignore 格式的忽略规则
+func ParseIgnoreRules(r io.Reader) (ir *IgnoreRules, err error) {
+	ir = &IgnoreRules{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		ir.Add(scanner.Text())
+	}
+	return ir, scanner.Err()
+}
+
+// Add 添加一条规则, 忽略空行和注释
+func (ir *IgnoreRules) Add(line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	rule := ignoreRule{}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if line == "" || line == "/" {
+		return
+	}
+	rule.pattern = line
+	ir.rules = append(ir.rules, rule)
+}
+
+// Match 检测相对路径 rel 是否被忽略, matched 表示是否有规则匹配,
+// 用于多层规则的合并: 没有规则匹配时, 以上层目录的结果为准
+func (ir *IgnoreRules) Match(rel string, isdir bool) (ignored, matched bool) {
+	if ir == nil {
+		return false, false
+	}
+	for k := len(ir.rules) - 1; k >= 0; k-- {
+		if MatchRule(ir.rules[k].pattern, rel, isdir) {
+			return !ir.rules[k].negate, true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,41 @@
+package pcspath
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	ir, err := ParseIgnoreRules(strings.NewReader(`# 注释
+.git/
+*.log
+!keep.log
+/build
+\#hash
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		rel              string
+		isdir            bool
+		ignored, matched bool
+	}{
+		{".git", true, true, true},
+		{".git", false, false, false},
+		{"a/b.log", false, true, true},
+		{"a/keep.log", false, false, true},
+		{"build", true, true, true},
+		{"a/build", true, false, false},
+		{"#hash", false, true, true},
+		{"main.go", false, false, false},
+	}
+
+	for _, c := range cases {
+		ignored, matched := ir.Match(c.rel, c.isdir)
+		if ignored != c.ignored || matched != c.matched {
+			t.Errorf("Match(%q, %v) = %v, %v, want %v, %v", c.rel, c.isdir, ignored, matched, c.ignored, c.matched)
+		}
+	}
+}
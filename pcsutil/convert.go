@@ -3,6 +3,7 @@ package pcsutil
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"unsafe"
 )
 
@@ -48,6 +49,37 @@ func ConvertFileSize(size int64, precision ...int) string {
 	return fmt.Sprintf("%."+pint+"fPB", float64(size)/float64(PB))
 }
 
+// ParseFileSize 解析文件大小, 例如 1024, 512KB, 1.5G, 单位不区分大小写
+func ParseFileSize(s string) (size int64, err error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	str = strings.TrimSuffix(str, "B")
+
+	unit := B
+	if len(str) > 0 {
+		switch str[len(str)-1] {
+		case 'K':
+			unit = KB
+		case 'M':
+			unit = MB
+		case 'G':
+			unit = GB
+		case 'T':
+			unit = TB
+		case 'P':
+			unit = PB
+		}
+		if unit != B {
+			str = str[:len(str)-1]
+		}
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("无法解析文件大小: %s", s)
+	}
+	return int64(f * float64(unit)), nil
+}
+
 // ToString unsafe 转换, 将 []byte 转换为 string
 func ToString(p []byte) string {
 	return *(*string)(unsafe.Pointer(&p))
@@ -3,12 +3,13 @@ package pcsutil
 import (
 	"fmt"
 	"github.com/iikira/Baidu-Login/bdcrypto"
+	"github.com/iikira/BaiduPCS-Go/pcscrypto"
 	"io"
 	"os"
 	"strings"
 )
 
-// CryptoMethodSupport 检测是否支持加密解密方法
+// CryptoMethodSupport 检测是否支持加密解密方法, 用于解密旧格式的文件
 func CryptoMethodSupport(method string) bool {
 	switch method {
 	case "aes-128-ctr", "aes-192-ctr", "aes-256-ctr", "aes-128-cfb", "aes-192-cfb", "aes-256-cfb", "aes-128-ofb", "aes-192-ofb", "aes-256-ofb":
@@ -18,150 +19,114 @@ func CryptoMethodSupport(method string) bool {
 	return false
 }
 
-// EncryptFile 加密本地文件
-func EncryptFile(method string, key []byte, filePath string, isGzip bool) (encryptedFilePath string, err error) {
-	if !CryptoMethodSupport(method) {
-		return "", fmt.Errorf("unknown encrypt method: %s", method)
-	}
-
-	if isGzip {
-		err = bdcrypto.GZIPCompressFile(filePath)
-		if err != nil {
-			return
-		}
-	}
-
-	plainFile, err := os.OpenFile(filePath, os.O_RDONLY, 0)
+// EncryptFile 加密本地文件, 保存到 filePath.encrypt, 不修改源文件.
+// 使用口令派生密钥, aes-256-gcm 分块加密, 可检测密钥错误和数据被篡改, isGzip 为加密前是否压缩
+func EncryptFile(key []byte, filePath string, isGzip bool) (encryptedFilePath string, err error) {
+	encryptedFilePath = filePath + ".encrypt"
+	err = transformFile(filePath, encryptedFilePath, func(dst io.Writer, src *os.File) error {
+		return pcscrypto.EncryptStream(dst, src, key, isGzip)
+	})
 	if err != nil {
-		return
+		return "", err
 	}
+	return encryptedFilePath, nil
+}
 
-	defer plainFile.Close()
-
-	var cipherReader io.Reader
-	switch method {
-	case "aes-128-ctr":
-		cipherReader, err = bdcrypto.Aes128CTREncrypt(bdcrypto.Convert16bytes(key), plainFile)
-	case "aes-192-ctr":
-		cipherReader, err = bdcrypto.Aes192CTREncrypt(bdcrypto.Convert24bytes(key), plainFile)
-	case "aes-256-ctr":
-		cipherReader, err = bdcrypto.Aes256CTREncrypt(bdcrypto.Convert32bytes(key), plainFile)
-	case "aes-128-cfb":
-		cipherReader, err = bdcrypto.Aes128CFBEncrypt(bdcrypto.Convert16bytes(key), plainFile)
-	case "aes-192-cfb":
-		cipherReader, err = bdcrypto.Aes192CFBEncrypt(bdcrypto.Convert24bytes(key), plainFile)
-	case "aes-256-cfb":
-		cipherReader, err = bdcrypto.Aes256CFBEncrypt(bdcrypto.Convert32bytes(key), plainFile)
-	case "aes-128-ofb":
-		cipherReader, err = bdcrypto.Aes128OFBEncrypt(bdcrypto.Convert16bytes(key), plainFile)
-	case "aes-192-ofb":
-		cipherReader, err = bdcrypto.Aes192OFBEncrypt(bdcrypto.Convert24bytes(key), plainFile)
-	case "aes-256-ofb":
-		cipherReader, err = bdcrypto.Aes256OFBEncrypt(bdcrypto.Convert32bytes(key), plainFile)
-	default:
-		return "", fmt.Errorf("unknown encrypt method: %s", method)
+// DecryptFile 解密本地文件, 去除 .encrypt 后缀保存, 没有此后缀则保存到 filePath.decrypt, 不修改源文件.
+// 自动识别 EncryptFile 和 upload --encrypt 加密的文件, 旧版本加密的文件, 使用 method 和 isGzip 解密
+func DecryptFile(method string, key []byte, filePath string, isGzip bool) (decryptedFilePath string, err error) {
+	decryptedFilePath = strings.TrimSuffix(filePath, ".encrypt")
+	if decryptedFilePath == filePath {
+		decryptedFilePath = filePath + ".decrypt"
 	}
 
+	err = transformFile(filePath, decryptedFilePath, func(dst io.Writer, src *os.File) error {
+		err := pcscrypto.DecryptStream(dst, src, key)
+		if err != pcscrypto.ErrNotEncrypted {
+			return err
+		}
+
+		// 旧格式
+		_, err = src.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		return decryptLegacy(method, key, dst, src, isGzip)
+	})
 	if err != nil {
-		return
+		return "", err
 	}
+	return decryptedFilePath, nil
+}
 
-	plainFileInfo, err := plainFile.Stat()
+// transformFile 读取 srcPath, 经 fn 处理后写入新建的 dstPath, dstPath 已存在则返回错误, 失败时删除 dstPath
+func transformFile(srcPath, dstPath string, fn func(dst io.Writer, src *os.File) error) error {
+	src, err := os.Open(srcPath)
 	if err != nil {
-		return
+		return err
 	}
+	defer src.Close()
 
-	encryptedFilePath = filePath + ".encrypt"
-	encryptedFile, err := os.OpenFile(encryptedFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, plainFileInfo.Mode())
+	srcInfo, err := src.Stat()
 	if err != nil {
-		return
+		return err
 	}
 
-	defer encryptedFile.Close()
-
-	_, err = io.Copy(encryptedFile, cipherReader)
+	// 保留文件权限
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, srcInfo.Mode())
 	if err != nil {
-		return
+		return err
 	}
 
-	os.Remove(filePath)
-
-	return encryptedFilePath, nil
-}
-
-// DecryptFile 加密本地文件
-func DecryptFile(method string, key []byte, filePath string, isGzip bool) (decryptedFilePath string, err error) {
-	if !CryptoMethodSupport(method) {
-		return "", fmt.Errorf("unknown decrypt method: %s", method)
+	err = fn(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
 	}
-
-	cipherFile, err := os.OpenFile(filePath, os.O_RDONLY, 0644)
 	if err != nil {
-		return
+		os.Remove(dstPath)
+		return err
 	}
+	return nil
+}
 
-	defer cipherFile.Close()
-
+// decryptLegacy 解密旧格式的文件, 旧格式无法检测密钥错误, 只能通过 gzip 解压缩失败判断
+func decryptLegacy(method string, key []byte, dst io.Writer, cipherReader io.Reader, isGzip bool) (err error) {
 	var plainReader io.Reader
 	switch method {
 	case "aes-128-ctr":
-		plainReader, err = bdcrypto.Aes128CTRDecrypt(bdcrypto.Convert16bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes128CTRDecrypt(bdcrypto.Convert16bytes(key), cipherReader)
 	case "aes-192-ctr":
-		plainReader, err = bdcrypto.Aes192CTRDecrypt(bdcrypto.Convert24bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes192CTRDecrypt(bdcrypto.Convert24bytes(key), cipherReader)
 	case "aes-256-ctr":
-		plainReader, err = bdcrypto.Aes256CTRDecrypt(bdcrypto.Convert32bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes256CTRDecrypt(bdcrypto.Convert32bytes(key), cipherReader)
 	case "aes-128-cfb":
-		plainReader, err = bdcrypto.Aes128CFBDecrypt(bdcrypto.Convert16bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes128CFBDecrypt(bdcrypto.Convert16bytes(key), cipherReader)
 	case "aes-192-cfb":
-		plainReader, err = bdcrypto.Aes192CFBDecrypt(bdcrypto.Convert24bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes192CFBDecrypt(bdcrypto.Convert24bytes(key), cipherReader)
 	case "aes-256-cfb":
-		plainReader, err = bdcrypto.Aes256CFBDecrypt(bdcrypto.Convert32bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes256CFBDecrypt(bdcrypto.Convert32bytes(key), cipherReader)
 	case "aes-128-ofb":
-		plainReader, err = bdcrypto.Aes128OFBDecrypt(bdcrypto.Convert16bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes128OFBDecrypt(bdcrypto.Convert16bytes(key), cipherReader)
 	case "aes-192-ofb":
-		plainReader, err = bdcrypto.Aes192OFBDecrypt(bdcrypto.Convert24bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes192OFBDecrypt(bdcrypto.Convert24bytes(key), cipherReader)
 	case "aes-256-ofb":
-		plainReader, err = bdcrypto.Aes256OFBDecrypt(bdcrypto.Convert32bytes(key), cipherFile)
+		plainReader, err = bdcrypto.Aes256OFBDecrypt(bdcrypto.Convert32bytes(key), cipherReader)
 	default:
-		return "", fmt.Errorf("unknown decrypt method: %s", method)
+		return fmt.Errorf("unknown decrypt method: %s", method)
 	}
 
 	if err != nil {
 		return
 	}
 
-	cipherFileInfo, err := cipherFile.Stat()
-	if err != nil {
+	if !isGzip {
+		_, err = io.Copy(dst, plainReader)
 		return
 	}
 
-	decryptedFilePath = strings.TrimSuffix(filePath, ".encrypt")
-	decryptedTmpFilePath := decryptedFilePath + ".decrypted"
-	decryptedTmpFile, err := os.OpenFile(decryptedTmpFilePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, cipherFileInfo.Mode())
-
-	_, err = io.Copy(decryptedTmpFile, plainReader)
+	err = bdcrypto.GZIPUncompress(plainReader, dst)
 	if err != nil {
-		return
-	}
-
-	defer decryptedTmpFile.Close()
-
-	if isGzip {
-		err = bdcrypto.GZIPUnompressFile(decryptedTmpFilePath)
-		if err != nil {
-			os.Remove(decryptedTmpFilePath)
-			return
-		}
-
-		// 删除已加密的文件
-		os.Remove(filePath)
+		return fmt.Errorf("密钥或加密方法错误, %s", err)
 	}
-
-	if strings.Compare(filePath, decryptedFilePath) != 0 {
-		os.Rename(decryptedTmpFilePath, decryptedFilePath)
-	} else {
-		decryptedFilePath = decryptedTmpFilePath
-	}
-
-	return decryptedFilePath, nil
+	return nil
 }
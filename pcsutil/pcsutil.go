@@ -26,6 +26,15 @@ func init() {
 	PipeInput = (fileInfo.Mode() & os.ModeNamedPipe) == os.ModeNamedPipe
 }
 
+// IsTerminal 检测 f 是否为终端
+func IsTerminal(f *os.File) bool {
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return (fileInfo.Mode() & os.ModeCharDevice) == os.ModeCharDevice
+}
+
 // GetURLCookieString 返回cookie字串
 func GetURLCookieString(urlString string, jar *cookiejar.Jar) string {
 	url, _ := url.Parse(urlString)
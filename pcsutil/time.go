@@ -2,6 +2,8 @@ package pcsutil
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -51,3 +53,35 @@ func FormatTime(t int64) string {
 	hour, min, sec := tt.Clock()
 	return fmt.Sprintf("%d-%02d-%02d %02d:%02d:%02d", year, mon, day, hour, min, sec)
 }
+
+// ParseTime 解析时间, 支持日期 (北京时间), 例如 2018-01-02, 2018-01-02 15:04:05,
+// 和距离当前的时间长度, 例如 30m, 12h, 7d, 2w, 表示 30 分钟前, 12 小时前, 7 天前, 2 周前
+func ParseTime(s string) (t time.Time, err error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		t, err = time.ParseInLocation(layout, s, CSTLocation)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	var d time.Duration
+	switch {
+	case strings.HasSuffix(s, "d"), strings.HasSuffix(s, "w"):
+		n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return t, fmt.Errorf("无法解析时间: %s", s)
+		}
+		d = time.Duration(n * float64(24*time.Hour))
+		if strings.HasSuffix(s, "w") {
+			d *= 7
+		}
+	default:
+		d, err = time.ParseDuration(s)
+		if err != nil {
+			return t, fmt.Errorf("无法解析时间: %s", s)
+		}
+	}
+
+	return time.Now().Add(-d), nil
+}
@@ -0,0 +1,78 @@
+package requester
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadCookieFile 从 Netscape 格式的 cookie 文件 (如 curl, wget 导出的 cookies.txt)
+// 载入 cookie 到 jar
+func LoadCookieFile(jar *cookiejar.Jar, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		scanner = bufio.NewScanner(f)
+		lineNum int
+	)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// #HttpOnly_ 开头的为 HttpOnly 的 cookie, 其他 # 开头的为注释
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// domain, include subdomains, path, secure, expires, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			return fmt.Errorf("%s:%d: 不是 Netscape 格式的 cookie", filename, lineNum)
+		}
+
+		var (
+			domain = fields[0]
+			secure = strings.EqualFold(fields[3], "TRUE")
+			cookie = &http.Cookie{
+				Name:     fields[5],
+				Value:    fields[6],
+				Path:     fields[2],
+				Secure:   secure,
+				HttpOnly: httpOnly,
+			}
+		)
+
+		// 包含子域名的, 设置 Domain
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		if expires, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+
+		u := &url.URL{
+			Scheme: "http",
+			Host:   strings.TrimPrefix(domain, "."),
+			Path:   cookie.Path,
+		}
+		if secure {
+			u.Scheme = "https"
+		}
+		jar.SetCookies(u, []*http.Cookie{cookie})
+	}
+	return scanner.Err()
+}
@@ -4,6 +4,7 @@ package multipartreader
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -82,6 +83,39 @@ func (mr *MultipartReader) SetupHTTPRequest(req *http.Request) {
 
 	// 设置 Content-Length 不然请求会卡住不动!!!
 	req.ContentLength = mr.Len()
+
+	// 表单内容可重读时, 遇到重定向等情况可重新发送
+	req.GetBody = GetBody(mr)
+}
+
+// Seek 实现 io.Seeker 接口, 只支持回到开头 (offset 为 0, whence 为 io.SeekStart),
+// 用于重新发送请求, 已读取的数据量归零. 所有表单内容都需实现 io.Seeker 接口
+func (mr *MultipartReader) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		return 0, errors.New("multipartreader: only support seek to start")
+	}
+
+	for _, part := range mr.parts {
+		if part == nil {
+			continue
+		}
+		if err := rewind(part.readerlen); err != nil {
+			return 0, err
+		}
+	}
+	for _, part64 := range mr.part64s {
+		if part64 == nil {
+			continue
+		}
+		if err := rewind(part64.readerlen64); err != nil {
+			return 0, err
+		}
+	}
+
+	mr.once = sync.Once{}
+	mr.multiReader = nil
+	atomic.StoreInt64(&mr.readed, 0)
+	return 0, nil
 }
 
 func (mr *MultipartReader) Read(p []byte) (n int, err error) {
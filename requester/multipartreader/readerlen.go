@@ -1,11 +1,18 @@
 package multipartreader
 
 import (
+	"errors"
 	"io"
+	"io/ioutil"
 	"os"
 	"sync/atomic"
 )
 
+var (
+	// ErrNotSeekable 数据不可重读
+	ErrNotSeekable = errors.New("multipartreader: reader is not seekable")
+)
+
 // ReaderLen 实现io.Reader和32-bit长度接口
 type ReaderLen interface {
 	io.Reader
@@ -24,7 +31,32 @@ type ReadedLen64 interface {
 	Readed() int64
 }
 
-// NewFileReadedLen64 *os.File 实现 ReadedLen64 接口
+// rewind 回到 r 的开头
+func rewind(r io.Reader) error {
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return ErrNotSeekable
+	}
+	_, err := s.Seek(0, io.SeekStart)
+	return err
+}
+
+// GetBody 返回用于 http.Request.GetBody 的函数, 回到 r 的开头后重新发送,
+// r 未实现 io.Seeker 接口时返回 nil
+func GetBody(r io.Reader) func() (io.ReadCloser, error) {
+	if _, ok := r.(io.Seeker); !ok {
+		return nil
+	}
+
+	return func() (io.ReadCloser, error) {
+		if err := rewind(r); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(r), nil
+	}
+}
+
+// NewFileReadedLen64 *os.File 实现 ReadedLen64 接口, 从文件开头读取, 支持 Seek 重读
 func NewFileReadedLen64(f *os.File) ReadedLen64 {
 	if f == nil {
 		return nil
@@ -37,17 +69,36 @@ func NewFileReadedLen64(f *os.File) ReadedLen64 {
 }
 
 type fileReadedlen64 struct {
-	readed int64
+	readed int64 // 已读取的数据量, 也是读取的位置
 	f      *os.File
 }
 
 // Read 读文件, 并记录已读取数据量
 func (fr *fileReadedlen64) Read(b []byte) (n int, err error) {
-	n, err = fr.f.Read(b)
+	n, err = fr.f.ReadAt(b, atomic.LoadInt64(&fr.readed))
 	atomic.AddInt64(&fr.readed, int64(n))
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
 	return n, err
 }
 
+// Seek 实现 io.Seeker 接口, 已读取的数据量随读取位置改变
+func (fr *fileReadedlen64) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += atomic.LoadInt64(&fr.readed)
+	case io.SeekEnd:
+		offset += fr.Len()
+	}
+	if offset < 0 {
+		return 0, errors.New("multipartreader: negative position")
+	}
+
+	atomic.StoreInt64(&fr.readed, offset)
+	return offset, nil
+}
+
 // Len 返回文件的大小
 func (fr *fileReadedlen64) Len() int64 {
 	info, err := fr.f.Stat()
@@ -6,18 +6,25 @@ import (
 
 var (
 	cryptoDescription = `
-	可用的方法 <method>:
-		aes-128-ctr, aes-192-ctr, aes-256-ctr,
-		aes-128-cfb, aes-192-cfb, aes-256-cfb,
-		aes-128-ofb, aes-192-ofb, aes-256-ofb.
+	加密格式:
+		口令经 PBKDF2-SHA256 派生为密钥, 使用 aes-256-gcm 分块加密, 每个文件使用随机的盐和 nonce,
+		可检测口令错误和文件被篡改. 加密和解密均保存为新的文件, 不修改源文件, 不使用临时文件.
+		加密的文件保存为 <file>.encrypt, 解密时去除 .encrypt 后缀, 没有此后缀则保存为 <file>.decrypt, 目标文件已存在时不覆盖.
+		也可解密使用 upload --encrypt 上传后直接下载的文件.
 
-	密钥 <key>:
-		aes-128 对应key长度为16, aes-192 对应key长度为24, aes-256 对应key长度为32,
-		如果key长度不符合, 则自动修剪key, 舍弃超出长度的部分, 长度不足的部分用'\0'填充.
+	口令 <key>:
+		通过 -key, -key-file 或环境变量 BAIDUPCS_GO_KEY 指定.
 
 	GZIP <disable-gzip>:
-		在文件加密之前, 启用GZIP压缩文件; 文件解密之后启用GZIP解压缩文件, 默认启用,
-		如果不启用, 则无法检测文件是否解密成功, 解密文件时会保留源文件, 避免解密失败造成文件数据丢失.`
+		加密前使用GZIP压缩文件, 默认启用, 解密时自动识别.
+
+	旧格式:
+		旧版本加密的文件, 解密时需指定加密时的 -method (默认 aes-128-ctr), 未启用GZIP时需指定 -disable-gzip,
+		旧版本的默认密钥为 BaiduPCS-Go, 未指定口令时使用.
+		可用的方法 <method>:
+			aes-128-ctr, aes-192-ctr, aes-256-ctr,
+			aes-128-cfb, aes-192-cfb, aes-256-cfb,
+			aes-128-ofb, aes-192-ofb, aes-256-ofb.`
 )
 
 func init() {
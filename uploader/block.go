@@ -0,0 +1,283 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"sync/atomic"
+	"time"
+)
+
+// UploadFunc 上传分片的请求函数, 与 baidupcs.UploadFunc 相同
+type UploadFunc func(uploadURL string, jar *cookiejar.Jar) (resp *http.Response, err error)
+
+// BlockUploadFunc 上传分片 id, 调用 upload 执行上传请求, 返回分片的标识, 例如 md5
+type BlockUploadFunc func(id int, upload UploadFunc) (blockID string, err error)
+
+var (
+	// ErrTooManyBlocks 数据流的分片数超过限制
+	ErrTooManyBlocks = errors.New("分片数超过限制")
+)
+
+// blockState 分片上传的状态
+type blockState struct {
+	r         io.ReaderAt
+	length    int64        // 总大小
+	blockSize int64        // 分片大小
+	done      int64        // 已完成的分片的数据量, 原子操作
+	current   atomic.Value // 正在上传的分片, *readedReader
+
+	stream    io.Reader         // 长度未知的数据流, 按分片暂存到内存
+	maxBlocks int               // 数据流的最大分片数, 0 表示不限制
+	streamBuf []byte            // 数据流分片的缓存
+	streamed  *io.SectionReader // 数据流当前分片的数据
+}
+
+// readedReader 记录已读取数据量
+type readedReader struct {
+	r      *io.SectionReader
+	readed int64
+}
+
+func (rr *readedReader) Read(p []byte) (n int, err error) {
+	n, err = rr.r.Read(p)
+	atomic.AddInt64(&rr.readed, int64(n))
+	return
+}
+
+func (rr *readedReader) Len() int64 {
+	return rr.r.Size()
+}
+
+// Seek 用于重新发送请求, 已读取的数据量随读取位置改变
+func (rr *readedReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := rr.r.Seek(offset, whence)
+	if err == nil {
+		atomic.StoreInt64(&rr.readed, n)
+	}
+	return n, err
+}
+
+func (rr *readedReader) Readed() int64 {
+	return atomic.LoadInt64(&rr.readed)
+}
+
+// NewBlockUploader 返回分片上传的 uploader 对象, 将 r 的 length 数据量, 按 blockSize 分片上传
+func NewBlockUploader(r io.ReaderAt, length, blockSize int64, o *Options) (uploader *Uploader) {
+	uploader = NewUploader("", nil, o)
+	if blockSize <= 0 {
+		blockSize = length
+	}
+	uploader.blocks = &blockState{
+		r:         r,
+		length:    length,
+		blockSize: blockSize,
+	}
+
+	// 暂停时中止当前分片, 恢复后重新上传
+	uploader.ctrl.abortOnPause = true
+	return
+}
+
+// NewStreamUploader 返回长度未知的数据流的分片上传 uploader 对象,
+// 每次从 r 读取 blockSize 数据量暂存到内存, 作为一个分片上传, 分片失败时可从内存重试,
+// 分片数超过 maxBlocks 时返回 ErrTooManyBlocks, maxBlocks 为 0 表示不限制
+func NewStreamUploader(r io.Reader, blockSize int64, maxBlocks int, o *Options) (uploader *Uploader) {
+	uploader = NewBlockUploader(nil, 0, blockSize, o)
+	uploader.blocks.stream = r
+	uploader.blocks.maxBlocks = maxBlocks
+	return
+}
+
+// BlockNum 返回分片数, 数据流上传时, 返回已读取的分片数
+func (u *Uploader) BlockNum() int {
+	if u.blocks == nil || u.blocks.length == 0 {
+		return 1
+	}
+	return int((u.blocks.length + u.blocks.blockSize - 1) / u.blocks.blockSize)
+}
+
+// BlockMD5 计算分片 id 的本地数据的 md5, 用于校验服务器返回的分片 md5,
+// 数据流上传时, 只能在 BlockUploadFunc 中计算当前的分片
+func (u *Uploader) BlockMD5(id int) (string, error) {
+	section, err := u.blocks.section(id)
+	if err != nil {
+		return "", err
+	}
+	m := md5.New()
+	_, err = io.Copy(m, section)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(m.Sum(nil)), nil
+}
+
+// section 返回分片 id 的数据
+func (bs *blockState) section(id int) (*io.SectionReader, error) {
+	if bs.stream != nil {
+		if bs.streamed == nil || id != bs.streamID() {
+			return nil, errors.New("数据流的分片已不可读取")
+		}
+		return io.NewSectionReader(bs.streamed, 0, bs.streamed.Size()), nil
+	}
+
+	offset := int64(id) * bs.blockSize
+	size := bs.blockSize
+	if offset+size > bs.length {
+		size = bs.length - offset
+	}
+	return io.NewSectionReader(bs.r, offset, size), nil
+}
+
+// streamID 数据流当前分片的 id
+func (bs *blockState) streamID() int {
+	if bs.length == 0 {
+		return 0
+	}
+	return int((bs.length - 1) / bs.blockSize)
+}
+
+// nextStream 从数据流读取下一个分片, 没有更多数据时返回 io.EOF,
+// 数据流为空时, 返回一个空的分片
+func (bs *blockState) nextStream(id int) (err error) {
+	if bs.streamBuf == nil {
+		bs.streamBuf = make([]byte, bs.blockSize)
+	}
+
+	n, err := io.ReadFull(bs.stream, bs.streamBuf)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
+	case io.EOF:
+		if id != 0 {
+			return io.EOF
+		}
+	default:
+		return err
+	}
+
+	if bs.maxBlocks > 0 && id >= bs.maxBlocks {
+		return ErrTooManyBlocks
+	}
+
+	bs.streamed = io.NewSectionReader(bytes.NewReader(bs.streamBuf[:n]), 0, int64(n))
+	atomic.AddInt64(&bs.length, int64(n))
+	return nil
+}
+
+// ExecuteBlocks 按顺序执行分片上传, 各分片失败时单独重试,
+// 暂停时中止当前分片, 恢复后重新上传该分片, 收到返回值信号则为上传结束
+func (u *Uploader) ExecuteBlocks(fn BlockUploadFunc, checkFunc func(blockIDs []string, err error)) <-chan struct{} {
+	finish := make(chan struct{}, 0)
+	u.startStatus()
+	go func() {
+		u.touch(u.onExecute)
+		u.emit(pcsevent.Started, nil)
+
+		blockIDs, err := u.executeBlocks(fn)
+
+		u.finish(err)
+
+		if checkFunc != nil {
+			checkFunc(blockIDs, err)
+		}
+
+		u.touch(u.onFinish)
+
+		finish <- struct{}{}
+	}()
+	return finish
+}
+
+func (u *Uploader) executeBlocks(fn BlockUploadFunc) (blockIDs []string, err error) {
+	bs := u.blocks
+	num := u.BlockNum()
+	for id := 0; bs.stream != nil || id < num; id++ {
+		if bs.stream != nil {
+			err = bs.nextStream(id)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		section, err := bs.section(id)
+		if err != nil {
+			return nil, err
+		}
+
+		var blockID string
+		for attempt := 0; ; {
+			if err = u.ctrl.wait(); err != nil {
+				return nil, err
+			}
+
+			block := &readedReader{
+				r: io.NewSectionReader(section, 0, section.Size()),
+			}
+			bs.current.Store(block)
+
+			blockID, err = fn(id, func(uploadURL string, jar *cookiejar.Jar) (*http.Response, error) {
+				if jar != nil {
+					u.Options.Client.SetCookiejar(jar)
+				}
+				return u.do(uploadURL, block)
+			})
+			bs.current.Store((*readedReader)(nil))
+			if err == nil {
+				atomic.AddInt64(&bs.done, section.Size())
+				break
+			}
+
+			// 已取消, 或者被暂停中止, 暂停的不计入重试次数
+			if u.ctrl.isCanceled() {
+				return nil, ErrCanceled
+			}
+			if u.ctrl.isPaused() {
+				continue
+			}
+
+			attempt++
+			if attempt > MaxRetry {
+				return nil, err
+			}
+			u.emit(pcsevent.Retry, func(e *pcsevent.Event) {
+				e.BlockID = id
+				e.Attempt = attempt
+				e.Error = err.Error()
+			})
+			time.Sleep(3 * time.Second)
+		}
+		blockIDs = append(blockIDs, blockID)
+	}
+	return blockIDs, nil
+}
+
+// length 返回要上传的总数据量, 数据流上传时未知, 返回 0
+func (u *Uploader) length() int64 {
+	if u.blocks != nil {
+		if u.blocks.stream != nil {
+			return 0
+		}
+		return u.blocks.length
+	}
+	return u.Body.Len()
+}
+
+// readed 返回已上传的数据量
+func (u *Uploader) readed() int64 {
+	if u.blocks != nil {
+		n := atomic.LoadInt64(&u.blocks.done)
+		if current, ok := u.blocks.current.Load().(*readedReader); ok && current != nil {
+			n += current.Readed()
+		}
+		return n
+	}
+	return u.Body.Readed()
+}
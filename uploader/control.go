@@ -0,0 +1,141 @@
+package uploader
+
+import (
+	"errors"
+	"github.com/iikira/BaiduPCS-Go/requester/multipartreader"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrCanceled 上传已取消
+	ErrCanceled = errors.New("上传已取消")
+)
+
+// control 控制上传的暂停, 恢复和取消
+type control struct {
+	paused       bool
+	canceled     bool
+	abortOnPause bool   // 暂停时中止进行中的请求, 用于分片上传
+	abort        func() // 中止进行中的请求
+	mu           sync.Mutex
+	cond         *sync.Cond
+}
+
+func newControl() *control {
+	c := &control{}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// wait 暂停时等待恢复, 已取消则返回 ErrCanceled
+func (c *control) wait() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.paused && !c.canceled {
+		c.cond.Wait()
+	}
+	if c.canceled {
+		return ErrCanceled
+	}
+	return nil
+}
+
+// setAbort 设置中止进行中的请求的函数, 已取消则立即中止
+func (c *control) setAbort(abort func()) {
+	c.mu.Lock()
+	c.abort = abort
+	canceled := c.canceled
+	c.mu.Unlock()
+
+	if canceled && abort != nil {
+		abort()
+	}
+}
+
+// pause 暂停, 返回是否改变了状态
+func (c *control) pause() bool {
+	c.mu.Lock()
+	if c.paused || c.canceled {
+		c.mu.Unlock()
+		return false
+	}
+	c.paused = true
+	abort := c.abort
+	c.mu.Unlock()
+
+	if c.abortOnPause && abort != nil {
+		abort()
+	}
+	return true
+}
+
+// resume 恢复, 返回是否改变了状态
+func (c *control) resume() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.paused || c.canceled {
+		return false
+	}
+	c.paused = false
+	c.cond.Broadcast()
+	return true
+}
+
+// cancel 取消, 中止进行中的请求, 返回是否改变了状态
+func (c *control) cancel() bool {
+	c.mu.Lock()
+	if c.canceled {
+		c.mu.Unlock()
+		return false
+	}
+	c.canceled = true
+	abort := c.abort
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	if abort != nil {
+		abort()
+	}
+	return true
+}
+
+func (c *control) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+func (c *control) isCanceled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.canceled
+}
+
+// controlledReader 暂停时阻塞读取, 取消时返回 ErrCanceled
+type controlledReader struct {
+	r    multipartreader.ReaderLen64
+	ctrl *control
+}
+
+func (cr *controlledReader) Read(p []byte) (n int, err error) {
+	if err = cr.ctrl.wait(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
+func (cr *controlledReader) Len() int64 {
+	return cr.r.Len()
+}
+
+// Seek 用于重新发送请求, r 需实现 io.Seeker 接口
+func (cr *controlledReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := cr.r.(io.Seeker)
+	if !ok {
+		return 0, multipartreader.ErrNotSeekable
+	}
+	return s.Seek(offset, whence)
+}
@@ -1,6 +1,7 @@
 package uploader
 
 import (
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
 	"time"
 )
 
@@ -19,24 +20,48 @@ func (u *Uploader) startStatus() {
 	go func() {
 		t := time.Now()
 		for {
-			old := u.Body.Readed()
+			old := u.readed()
 
 			time.Sleep(1 * time.Second) // 每秒统计
 
-			if u.finished {
+			if u.isFinished() {
 				// 上传完毕, 结束
 				close(c)
 				return
 			}
 
-			c <- UploadStatus{
-				Length:      u.Body.Len(),
-				Uploaded:    u.Body.Readed(),
-				Speed:       u.Body.Readed() - old,
+			status := UploadStatus{
+				Length:      u.length(),
+				Uploaded:    u.readed(),
+				Speed:       u.readed() - old,
 				TimeElapsed: time.Since(t) / 1000000 * 1000000,
 			}
+			u.emit(pcsevent.Progress, func(e *pcsevent.Event) {
+				e.Speed = status.Speed
+				e.Elapsed = status.TimeElapsed
+			})
+
+			c <- status
 		}
 	}()
 
 	u.UploadStatus = c
 }
+
+// emit 发布事件, 没有订阅者时忽略
+func (u *Uploader) emit(typ pcsevent.Type, fn func(e *pcsevent.Event)) {
+	if !u.Events.HasSubscriber() {
+		return
+	}
+
+	e := &pcsevent.Event{
+		Type:        typ,
+		Kind:        pcsevent.KindUpload,
+		Total:       u.length(),
+		Transferred: u.readed(),
+	}
+	if fn != nil {
+		fn(e)
+	}
+	u.Events.Emit(e)
+}
@@ -2,9 +2,20 @@
 package uploader
 
 import (
+	"context"
+	"github.com/iikira/BaiduPCS-Go/pcsevent"
 	"github.com/iikira/BaiduPCS-Go/requester"
 	"github.com/iikira/BaiduPCS-Go/requester/multipartreader"
+	"io"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// MaxRetry 上传请求失败的最大重试次数, 分片上传为每个分片的最大重试次数
+	MaxRetry = 3
 )
 
 // Uploader 上传
@@ -15,10 +26,17 @@ type Uploader struct {
 	Options *Options
 
 	UploadStatus <-chan UploadStatus // 上传状态
-	finished     bool
+	Events       pcsevent.Emitter    // 上传事件
+	finished     int32               // 是否已结束, 原子操作
+
+	ctrl   *control    // 暂停, 恢复, 取消
+	blocks *blockState // 分片上传的状态, 非分片上传时为 nil
 
 	onExecute func()
 	onFinish  func()
+	onPause   func()
+	onResume  func()
+	onCancel  func()
 }
 
 // Options are the options for creating a new Uploader
@@ -33,6 +51,7 @@ func NewUploader(url string, readedlen64 multipartreader.ReadedLen64, o *Options
 		URL:     url,
 		Body:    readedlen64,
 		Options: o,
+		ctrl:    newControl(),
 	}
 
 	if uploader.Options == nil {
@@ -58,12 +77,34 @@ func (u *Uploader) Execute(checkFunc func(resp *http.Response, err error)) <-cha
 	u.startStatus()
 	go func() {
 		u.touch(u.onExecute)
+		u.emit(pcsevent.Started, nil)
 
-		// 开始上传
-		resp, _, err := u.execute()
+		// 开始上传, 失败时回到开头重试, 不重新打开文件
+		var (
+			resp *http.Response
+			err  error
+		)
+		for attempt := 0; ; {
+			resp, err = u.do(u.URL, u.Body)
+			if err == nil || err == ErrCanceled || attempt >= MaxRetry {
+				break
+			}
+			if s, ok := u.Body.(io.Seeker); !ok {
+				break
+			} else if _, serr := s.Seek(0, io.SeekStart); serr != nil {
+				break
+			}
+
+			attempt++
+			u.emit(pcsevent.Retry, func(e *pcsevent.Event) {
+				e.Attempt = attempt
+				e.Error = err.Error()
+			})
+			time.Sleep(3 * time.Second)
+		}
 
 		// 上传结束
-		u.finished = true
+		u.finish(err)
 
 		if checkFunc != nil {
 			checkFunc(resp, err)
@@ -76,39 +117,125 @@ func (u *Uploader) Execute(checkFunc func(resp *http.Response, err error)) <-cha
 	return finish
 }
 
-func (u *Uploader) execute() (resp *http.Response, code int, err error) {
+// do 执行上传请求, 请求可被暂停和取消
+func (u *Uploader) do(uploadURL string, body multipartreader.ReaderLen64) (resp *http.Response, err error) {
+	if err = u.ctrl.wait(); err != nil {
+		return nil, err
+	}
+
 	var (
 		contentType string
 		obody       multipartreader.ReaderLen64
+		cbody       = &controlledReader{
+			r:    body,
+			ctrl: u.ctrl,
+		}
 	)
 
 	if u.Options.IsMultiPart {
 		mr := multipartreader.NewMultipartReader()
-		mr.AddFormFile("uploadedfile", "", u.Body)
+		mr.AddFormFile("uploadedfile", "", cbody)
 
 		contentType = mr.ContentType()
 		obody = mr
 	} else {
 		contentType = "application/x-www-form-urlencoded"
-		obody = u.Body
+		obody = cbody
 	}
 
-	req, err := http.NewRequest("POST", u.URL, obody)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest("POST", uploadURL, obody)
 	if err != nil {
-		return nil, 1, err
+		cancel()
+		return nil, err
 	}
+	req = req.WithContext(ctx)
 
 	req.Header.Add("Content-Type", contentType)
 
 	// 设置 Content-Length 不然请求会卡住不动!!!
 	req.ContentLength = obody.Len()
 
+	// 遇到 307 重定向等情况时, 回到开头重新发送, 已读取的数据量归零
+	req.GetBody = multipartreader.GetBody(obody)
+
+	// 取消时中止请求, 关闭连接
+	u.ctrl.setAbort(cancel)
 	resp, err = u.Options.Client.Do(req)
 	if err != nil {
-		return nil, 2, err
+		u.ctrl.setAbort(nil)
+		cancel()
+		if u.ctrl.isCanceled() {
+			return nil, ErrCanceled
+		}
+		return nil, err
 	}
 
-	return resp, 0, nil
+	// 响应读取完毕关闭时, 释放 context, 清除中止函数
+	resp.Body = &releaseBody{
+		ReadCloser: resp.Body,
+		release: func() {
+			u.ctrl.setAbort(nil)
+			cancel()
+		},
+	}
+	return resp, nil
+}
+
+// releaseBody 关闭时调用 release 的响应
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (rb *releaseBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.once.Do(rb.release)
+	return err
+}
+
+// finish 设置上传结束, 发布事件
+func (u *Uploader) finish(err error) {
+	atomic.StoreInt32(&u.finished, 1)
+	if err != nil {
+		u.emit(pcsevent.Failed, func(e *pcsevent.Event) {
+			e.Error = err.Error()
+		})
+	} else {
+		u.emit(pcsevent.Finished, nil)
+	}
+}
+
+func (u *Uploader) isFinished() bool {
+	return atomic.LoadInt32(&u.finished) == 1
+}
+
+// Pause 暂停上传, 分片上传会中止当前分片, 恢复后重新上传该分片;
+// 非分片上传会阻塞读取, 保持连接
+func (u *Uploader) Pause() {
+	if u.isFinished() || !u.ctrl.pause() {
+		return
+	}
+	u.emit(pcsevent.Paused, nil)
+	u.touch(u.onPause)
+}
+
+// Resume 恢复上传
+func (u *Uploader) Resume() {
+	if u.isFinished() || !u.ctrl.resume() {
+		return
+	}
+	u.emit(pcsevent.Resumed, nil)
+	u.touch(u.onResume)
+}
+
+// Cancel 取消上传, 关闭连接, 上传返回 ErrCanceled
+func (u *Uploader) Cancel() {
+	if u.isFinished() || !u.ctrl.cancel() {
+		return
+	}
+	u.touch(u.onCancel)
 }
 
 // touch 用于触发事件
@@ -127,3 +254,18 @@ func (u *Uploader) OnExecute(fn func()) {
 func (u *Uploader) OnFinish(fn func()) {
 	u.onFinish = fn
 }
+
+// OnPause 任务暂停时触发的事件
+func (u *Uploader) OnPause(fn func()) {
+	u.onPause = fn
+}
+
+// OnResume 任务恢复时触发的事件
+func (u *Uploader) OnResume(fn func()) {
+	u.onResume = fn
+}
+
+// OnCancel 任务取消时触发的事件
+func (u *Uploader) OnCancel(fn func()) {
+	u.onCancel = fn
+}
@@ -0,0 +1,266 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"github.com/iikira/BaiduPCS-Go/requester/multipartreader"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newBlockServer 模拟分片上传的服务器, 保存收到的分片, 返回分片的 md5
+func newBlockServer(delay time.Duration) (*httptest.Server, map[string][]byte, *sync.Mutex) {
+	var (
+		blocks = map[string][]byte{}
+		mu     = &sync.Mutex{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		// 文件名为空, 作为普通表单读取
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data := []byte(r.FormValue("uploadedfile"))
+
+		sum := md5.Sum(data)
+		id := hex.EncodeToString(sum[:])
+		mu.Lock()
+		blocks[id] = data
+		mu.Unlock()
+		w.Write([]byte(id))
+	}))
+	return ts, blocks, mu
+}
+
+func uploadBlock(ts *httptest.Server) BlockUploadFunc {
+	return func(id int, upload UploadFunc) (string, error) {
+		resp, err := upload(ts.URL, nil)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", errors.New(resp.Status)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+}
+
+func TestBlockUploadPauseResume(t *testing.T) {
+	data := make([]byte, 1024*1024+100)
+	rand.Read(data)
+
+	ts, blocks, mu := newBlockServer(200 * time.Millisecond)
+	defer ts.Close()
+
+	u := NewBlockUploader(bytes.NewReader(data), int64(len(data)), 256*1024, &Options{
+		IsMultiPart: true,
+	})
+	if u.BlockNum() != 5 {
+		t.Fatalf("got %d blocks, want 5", u.BlockNum())
+	}
+
+	var (
+		blockIDs []string
+		uperr    error
+	)
+	done := u.ExecuteBlocks(uploadBlock(ts), func(ids []string, err error) {
+		blockIDs, uperr = ids, err
+	})
+
+	time.Sleep(300 * time.Millisecond)
+	u.Pause()
+	time.Sleep(300 * time.Millisecond)
+	u.Resume()
+	<-done
+
+	if uperr != nil {
+		t.Fatal(uperr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var joined []byte
+	for _, id := range blockIDs {
+		joined = append(joined, blocks[id]...)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatalf("data mismatch, %d/%d, ids %q", len(joined), len(data), blockIDs)
+	}
+}
+
+// TestStreamUpload 长度未知的数据流, 按分片暂存后上传
+func TestStreamUpload(t *testing.T) {
+	data := make([]byte, 1024*1024+100)
+	rand.Read(data)
+
+	ts, blocks, mu := newBlockServer(0)
+	defer ts.Close()
+
+	for _, c := range []struct {
+		data      []byte
+		maxBlocks int
+		blockNum  int
+		err       error
+	}{
+		{data, 0, 5, nil},
+		{nil, 0, 1, nil},
+		{data, 4, 0, ErrTooManyBlocks},
+	} {
+		u := NewStreamUploader(bytes.NewReader(c.data), 256*1024, c.maxBlocks, &Options{
+			IsMultiPart: true,
+		})
+
+		var (
+			blockIDs []string
+			uperr    error
+		)
+		upload := uploadBlock(ts)
+		<-u.ExecuteBlocks(func(id int, fn UploadFunc) (string, error) {
+			blockID, err := upload(id, fn)
+			if err != nil {
+				return "", err
+			}
+			localMD5, err := u.BlockMD5(id)
+			if err != nil {
+				return "", err
+			}
+			return blockID, VerifyMD5("md5", localMD5, blockID)
+		}, func(ids []string, err error) {
+			blockIDs, uperr = ids, err
+		})
+
+		if uperr != c.err {
+			t.Fatalf("got error %v, want %v", uperr, c.err)
+		}
+		if uperr != nil {
+			continue
+		}
+		if len(blockIDs) != c.blockNum {
+			t.Fatalf("got %d blocks, want %d", len(blockIDs), c.blockNum)
+		}
+
+		mu.Lock()
+		var joined []byte
+		for _, id := range blockIDs {
+			joined = append(joined, blocks[id]...)
+		}
+		mu.Unlock()
+		if !bytes.Equal(joined, c.data) {
+			t.Fatalf("data mismatch, %d/%d", len(joined), len(c.data))
+		}
+	}
+}
+
+// TestUploadRedirect 遇到 307 重定向时, 重新发送请求, 已读取的数据量归零
+func TestUploadRedirect(t *testing.T) {
+	data := make([]byte, 1024*1024)
+	rand.Read(data)
+
+	ts, blocks, mu := newBlockServer(0)
+	defer ts.Close()
+
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		http.Redirect(w, r, ts.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirect.Close()
+
+	f, err := ioutil.TempFile("", "uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	f.Write(data)
+
+	body := multipartreader.NewFileReadedLen64(f)
+	u := NewUploader(redirect.URL, body, &Options{IsMultiPart: true})
+
+	var blockID string
+	<-u.Execute(func(resp *http.Response, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		id, _ := ioutil.ReadAll(resp.Body)
+		blockID = string(id)
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !bytes.Equal(blocks[blockID], data) {
+		t.Fatal("data mismatch")
+	}
+	if body.Readed() != int64(len(data)) {
+		t.Fatalf("readed %d, want %d", body.Readed(), len(data))
+	}
+
+	// 响应关闭后清除中止函数
+	u.ctrl.mu.Lock()
+	defer u.ctrl.mu.Unlock()
+	if u.ctrl.abort != nil {
+		t.Fatal("abort not cleared after the response was closed")
+	}
+}
+
+func TestUploadCancel(t *testing.T) {
+	// 服务器不读取数据, 上传会一直阻塞
+	block := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer ts.Close()
+	defer close(block)
+
+	u := NewUploader(ts.URL, &testReadedLen64{
+		Reader: strings.NewReader(strings.Repeat("x", 64*1024*1024)),
+		length: 64 * 1024 * 1024,
+	}, &Options{IsMultiPart: true})
+
+	var uperr error
+	done := u.Execute(func(resp *http.Response, err error) {
+		uperr = err
+	})
+
+	time.Sleep(200 * time.Millisecond)
+	u.Cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel timeout")
+	}
+	if uperr != ErrCanceled {
+		t.Fatalf("got error %v, want %v", uperr, ErrCanceled)
+	}
+}
+
+type testReadedLen64 struct {
+	io.Reader
+	length int64
+	readed int64
+}
+
+func (r *testReadedLen64) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	atomic.AddInt64(&r.readed, int64(n))
+	return
+}
+
+func (r *testReadedLen64) Len() int64    { return r.length }
+func (r *testReadedLen64) Readed() int64 { return atomic.LoadInt64(&r.readed) }
@@ -0,0 +1,41 @@
+package uploader
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VerifyError 上传完成后校验失败, 服务器返回的 md5 或大小与本地不一致, 应重新上传
+type VerifyError struct {
+	Item   string // 校验项, 例如 md5, 大小, 分片 md5
+	Local  string // 本地的值
+	Remote string // 服务器返回的值
+}
+
+func (ve *VerifyError) Error() string {
+	return fmt.Sprintf("校验失败, %s 不一致, 本地: %s, 网盘: %s", ve.Item, ve.Local, ve.Remote)
+}
+
+// VerifyMD5 比较本地和服务器返回的 md5 (十六进制, 不区分大小写), 不一致返回 *VerifyError
+func VerifyMD5(item, local, remote string) error {
+	if strings.EqualFold(local, remote) {
+		return nil
+	}
+	return &VerifyError{
+		Item:   item,
+		Local:  local,
+		Remote: remote,
+	}
+}
+
+// VerifySize 比较本地和服务器返回的大小, 不一致返回 *VerifyError
+func VerifySize(local, remote int64) error {
+	if local == remote {
+		return nil
+	}
+	return &VerifyError{
+		Item:   "大小",
+		Local:  fmt.Sprint(local),
+		Remote: fmt.Sprint(remote),
+	}
+}